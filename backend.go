@@ -3,6 +3,7 @@ package courier
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/nyaruka/courier/config"
 )
@@ -24,6 +25,13 @@ type Backend interface {
 	// GetChannel returns the channel with the passed in type and UUID
 	GetChannel(ChannelType, ChannelUUID) (Channel, error)
 
+	// GetChannels returns all the active channels with the passed in type
+	GetChannels(ChannelType) ([]Channel, error)
+
+	// GetContact returns the contact for the passed in channel and URN, creating it with the passed in name if it
+	// doesn't exist yet. Any auth passed in, such as an access token, is saved on the contact's URN
+	GetContact(channel Channel, urn URN, auth string, name string) (Contact, error)
+
 	// NewIncomingMsg creates a new message from the given params
 	NewIncomingMsg(channel Channel, urn URN, text string) Msg
 
@@ -39,6 +47,16 @@ type Backend interface {
 	// WriteMsgStatus writes the passed in status update to our backend
 	WriteMsgStatus(MsgStatus) error
 
+	// WriteDeliveredWatermark marks all the wired or sent msgs to the passed in URN on the passed in channel which were
+	// sent at or before the passed in time as delivered, returning the statuses that were written
+	WriteDeliveredWatermark(Channel, URN, time.Time) ([]MsgStatus, error)
+
+	// NewCallEventForExternalID creates a new CallEvent for the call with the given external id
+	NewCallEventForExternalID(Channel, string, CallStatusValue) CallEvent
+
+	// WriteCallEvent writes the passed in call event to our backend
+	WriteCallEvent(CallEvent) error
+
 	// WriteChannelLogs writes the passed in channel logs to our backend
 	WriteChannelLogs([]*ChannelLog) error
 
@@ -58,6 +76,9 @@ type Backend interface {
 	// StopMsgContact marks the contact for the passed in msg as stopped
 	StopMsgContact(Msg)
 
+	// StopContact marks the contact with the passed in URN as stopped
+	StopContact(Channel, URN) error
+
 	// Health returns a string describing any health problems the backend has, or empty string if all is well
 	Health() string
 
@@ -2,6 +2,7 @@ package rapidpro
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/url"
@@ -39,6 +40,29 @@ func (b *backend) GetChannel(ct courier.ChannelType, uuid courier.ChannelUUID) (
 	return getChannel(b, ct, uuid)
 }
 
+// GetChannels returns all the active channels for the passed in type
+func (b *backend) GetChannels(ct courier.ChannelType) ([]courier.Channel, error) {
+	return getChannels(b, ct)
+}
+
+// GetContact returns the contact for the passed in channel and URN, creating it with the passed in name if necessary,
+// any auth passed in is saved on the contact's URN
+func (b *backend) GetContact(c courier.Channel, urn courier.URN, auth string, name string) (courier.Contact, error) {
+	dbChannel := c.(*DBChannel)
+	contact, err := contactForURN(b.db, dbChannel.OrgID_, dbChannel.ID_, urn, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if auth != "" {
+		err = updateContactURNAuth(b.db, contact.URNID, auth)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return contact, nil
+}
+
 // NewIncomingMsg creates a new message from the given params
 func (b *backend) NewIncomingMsg(channel courier.Channel, urn courier.URN, text string) courier.Msg {
 	// remove any control characters
@@ -134,6 +158,21 @@ func (b *backend) StopMsgContact(m courier.Msg) {
 	b.notifier.addStopContactNotification(dbMsg.ContactID_)
 }
 
+// StopContact marks the contact with the passed in URN as stopped, there is nothing to stop if we don't know them
+func (b *backend) StopContact(c courier.Channel, urn courier.URN) error {
+	dbChannel := c.(*DBChannel)
+	contact, err := lookupContactForURN(b.db, dbChannel.OrgID_, urn)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	b.notifier.addStopContactNotification(contact.ID)
+	return nil
+}
+
 // WriteMsg writes the passed in message to our store
 func (b *backend) WriteMsg(m courier.Msg) error {
 	return writeMsg(b, m)
@@ -176,6 +215,21 @@ func (b *backend) WriteMsgStatus(status courier.MsgStatus) error {
 	return nil
 }
 
+// WriteDeliveredWatermark marks all the msgs sent to the passed in URN at or before the passed in time as delivered
+func (b *backend) WriteDeliveredWatermark(channel courier.Channel, urn courier.URN, watermark time.Time) ([]courier.MsgStatus, error) {
+	return writeDeliveredWatermark(b, channel, urn, watermark)
+}
+
+// NewCallEventForExternalID creates a new CallEvent for the call with the given external id
+func (b *backend) NewCallEventForExternalID(channel courier.Channel, externalID string, status courier.CallStatusValue) courier.CallEvent {
+	return newCallEvent(channel, externalID, status)
+}
+
+// WriteCallEvent writes the passed in CallEvent to our store
+func (b *backend) WriteCallEvent(event courier.CallEvent) error {
+	return writeCallEvent(b, event)
+}
+
 // WriteChannelLogs persists the passed in logs to our database, for rapidpro we swallow all errors, logging isn't critical
 func (b *backend) WriteChannelLogs(logs []*courier.ChannelLog) error {
 	for _, l := range logs {
@@ -367,6 +421,9 @@ func (b *backend) Start() error {
 	if err == nil {
 		err = courier.EnsureSpoolDirPresent(b.config.SpoolDir, "statuses")
 	}
+	if err == nil {
+		err = courier.EnsureSpoolDirPresent(b.config.SpoolDir, "calls")
+	}
 	if err != nil {
 		log.WithError(err).Error("spool directories not writable")
 	} else {
@@ -380,6 +437,7 @@ func (b *backend) Start() error {
 	// register and start our msg spool flushers
 	courier.RegisterFlusher(path.Join(b.config.SpoolDir, "msgs"), b.flushMsgFile)
 	courier.RegisterFlusher(path.Join(b.config.SpoolDir, "statuses"), b.flushStatusFile)
+	courier.RegisterFlusher(path.Join(b.config.SpoolDir, "calls"), b.flushCallEventFile)
 
 	logrus.WithFields(logrus.Fields{
 		"comp":  "backend",
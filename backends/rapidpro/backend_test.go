@@ -1,6 +1,7 @@
 package rapidpro
 
 import (
+	"database/sql"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -108,12 +109,14 @@ func (ts *BackendTestSuite) TestMsgUnmarshal() {
 		"uuid": "54c893b9-b026-44fc-a490-50aed0361c3f", 
 		"next_attempt": "2017-07-21T19:22:23.254182Z", 
 		"urn": "telegram:3527065", 
+		"urn_auth": "5ApPVsFDcFt:RZdK9ne7LgfvBYdtCYg7tv99hC9P2", 
 		"org_id": 1, 
 		"created_on": "2017-07-21T19:22:23.242757Z", 
 		"sent_on": null, 
 		"priority": 1000, 
 		"channel_id": 11, 
 		"response_to_id": 15, 
+		"response_to_external_id": "external-id", 
 		"external_id": null
 	}`
 
@@ -124,6 +127,9 @@ func (ts *BackendTestSuite) TestMsgUnmarshal() {
 	ts.Equal(msg.ChannelID_, courier.NewChannelID(11))
 	ts.Equal([]string{"https://foo.bar/image.jpg"}, msg.Attachments())
 	ts.Equal(msg.ExternalID(), "")
+	ts.Equal(courier.NewMsgID(15), msg.ResponseToID())
+	ts.Equal("external-id", msg.ResponseToExternalID())
+	ts.Equal("5ApPVsFDcFt:RZdK9ne7LgfvBYdtCYg7tv99hC9P2", msg.URNAuth())
 }
 
 func (ts *BackendTestSuite) TestCheckMsgExists() {
@@ -163,7 +169,7 @@ func (ts *BackendTestSuite) TestContact() {
 	contact2, err := contactForURN(ts.b.db, knChannel.OrgID(), knChannel.ID(), urn, "Other Name")
 	ts.NoError(err)
 
-	ts.Equal(contact.UUID, contact2.UUID)
+	ts.Equal(contact.UUID_, contact2.UUID_)
 	ts.Equal(contact.ID, contact2.ID)
 	ts.Equal(knChannel.OrgID(), contact2.OrgID)
 	ts.Equal("Ryan Lewis", contact2.Name.String)
@@ -178,7 +184,49 @@ func (ts *BackendTestSuite) TestContact() {
 	ts.NotNil(contact)
 
 	ts.Equal("", contact.Name.String)
-	ts.Equal("a984069d-0008-4d8c-a772-b14a8a6acccc", contact.UUID)
+	ts.Equal("a984069d-0008-4d8c-a772-b14a8a6acccc", contact.UUID_.String())
+}
+
+func (ts *BackendTestSuite) TestGetContact() {
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	// existing contacts are looked up by URN
+	contact, err := ts.b.GetContact(knChannel, courier.NewTelURNForCountry("+12067799192", "US"), "", "")
+	ts.NoError(err)
+	ts.Equal("a984069d-0008-4d8c-a772-b14a8a6acccc", contact.UUID().String())
+
+	// new ones are created with our name
+	urn := courier.NewTelURNForCountry("12065551519", "US")
+	contact, err = ts.b.GetContact(knChannel, urn, "", "Jane Doe")
+	ts.NoError(err)
+	ts.NotEqual(courier.NilContactUUID, contact.UUID())
+	ts.Equal("Jane Doe", contact.(*DBContact).Name.String)
+
+	// looking them up again can save auth on their URN
+	contact2, err := ts.b.GetContact(knChannel, urn, "token123", "")
+	ts.NoError(err)
+	ts.Equal(contact.UUID(), contact2.UUID())
+
+	var auth string
+	err = ts.b.db.Get(&auth, "SELECT auth FROM contacts_contacturn WHERE identity = $1", urn.Identity())
+	ts.NoError(err)
+	ts.Equal("token123", auth)
+}
+
+func (ts *BackendTestSuite) TestStopContact() {
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	// contacts we know about are stopped
+	err := ts.b.StopContact(knChannel, courier.NewTelURNForCountry("+12067799192", "US"))
+	ts.NoError(err)
+
+	// and we don't create contacts just to stop them
+	urn := courier.NewTelURNForCountry("12065551520", "US")
+	err = ts.b.StopContact(knChannel, urn)
+	ts.NoError(err)
+
+	_, err = lookupContactForURN(ts.b.db, knChannel.OrgID_, urn)
+	ts.Equal(sql.ErrNoRows, err)
 }
 
 func (ts *BackendTestSuite) TestContactURN() {
@@ -315,6 +363,64 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	ts.Equal(m.ErrorCount_, 3)
 }
 
+func (ts *BackendTestSuite) TestDeliveredWatermark() {
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn := courier.URN("tel:+12067799192")
+
+	// put our msgs back how we found them
+	defer ts.b.db.MustExec(`UPDATE msgs_msg SET status = 'W' WHERE id IN (10000, 10001)`)
+
+	// nothing was sent before this watermark
+	statuses, err := ts.b.WriteDeliveredWatermark(channel, urn, time.Now().Add(-time.Hour))
+	ts.NoError(err)
+	ts.Equal(0, len(statuses))
+
+	// but both our msgs were sent before this one
+	statuses, err = ts.b.WriteDeliveredWatermark(channel, urn, time.Now().Add(time.Minute))
+	ts.NoError(err)
+	ts.Equal(2, len(statuses))
+
+	for _, id := range []int64{10000, 10001} {
+		m, err := readMsgFromDB(ts.b, courier.NewMsgID(id))
+		ts.NoError(err)
+		ts.Equal(courier.MsgDelivered, m.Status_)
+	}
+
+	// delivered msgs aren't updated again
+	statuses, err = ts.b.WriteDeliveredWatermark(channel, urn, time.Now().Add(time.Minute))
+	ts.NoError(err)
+	ts.Equal(0, len(statuses))
+
+	// nor are msgs to other URNs
+	ts.b.db.MustExec(`UPDATE msgs_msg SET status = 'W' WHERE id IN (10000, 10001)`)
+	statuses, err = ts.b.WriteDeliveredWatermark(channel, courier.URN("tel:+12065551212"), time.Now().Add(time.Minute))
+	ts.NoError(err)
+	ts.Equal(0, len(statuses))
+}
+
+func (ts *BackendTestSuite) TestCallEvent() {
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	now := time.Now().In(time.UTC)
+	time.Sleep(2 * time.Millisecond)
+
+	// update by external id
+	event := ts.b.NewCallEventForExternalID(channel, "call1", courier.CallRinging)
+	err := ts.b.WriteCallEvent(event)
+	ts.NoError(err)
+
+	var status string
+	var modifiedOn time.Time
+	err = ts.b.db.QueryRow(`SELECT status, modified_on FROM channels_channelsession WHERE id = 100`).Scan(&status, &modifiedOn)
+	ts.NoError(err)
+	ts.Equal("R", status)
+	ts.True(modifiedOn.After(now))
+
+	// no such external id
+	event = ts.b.NewCallEventForExternalID(channel, "call2", courier.CallCompleted)
+	err = ts.b.WriteCallEvent(event)
+	ts.Equal(courier.ErrCallNotFound, err)
+}
+
 func (ts *BackendTestSuite) TestHealth() {
 	// all should be well in test land
 	ts.Equal(ts.b.Health(), "")
@@ -426,6 +532,23 @@ func (ts *BackendTestSuite) TestChannel() {
 	ts.Equal("missingValue", val)
 }
 
+func (ts *BackendTestSuite) TestGetChannels() {
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	channels, err := ts.b.GetChannels(courier.ChannelType("KN"))
+	ts.NoError(err)
+	ts.Equal(1, len(channels))
+	ts.Equal(knChannel.UUID(), channels[0].UUID())
+
+	// channels we load are cached
+	_, err = getLocalChannel(courier.ChannelType("KN"), channels[0].UUID())
+	ts.NoError(err)
+
+	channels, err = ts.b.GetChannels(courier.ChannelType("XX"))
+	ts.NoError(err)
+	ts.Equal(0, len(channels))
+}
+
 func (ts *BackendTestSuite) TestChanneLog() {
 	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
 
@@ -556,7 +679,7 @@ func (ts *BackendTestSuite) TestWriteMsg() {
 	ts.Equal("test contact", contact.Name.String)
 	ts.Equal(m.OrgID_, contact.OrgID)
 	ts.Equal(m.ContactID_, contact.ID)
-	ts.NotNil(contact.UUID)
+	ts.NotNil(contact.UUID_)
 	ts.NotNil(contact.ID)
 
 	// waiting 5 seconds should let us write it successfully
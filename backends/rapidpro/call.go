@@ -0,0 +1,110 @@
+package rapidpro
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nyaruka/courier"
+)
+
+// newCallEvent creates a new DBCallEvent for the passed in parameters
+func newCallEvent(channel courier.Channel, externalID string, status courier.CallStatusValue) *DBCallEvent {
+	return &DBCallEvent{
+		ChannelUUID_: channel.UUID(),
+		ExternalID_:  externalID,
+		Status_:      status,
+		ModifiedOn_:  time.Now().In(time.UTC),
+	}
+}
+
+// writeCallEvent writes the passed in call event to the database, queueing it to our spool in case the database is down
+func writeCallEvent(b *backend, event courier.CallEvent) error {
+	dbEvent := event.(*DBCallEvent)
+
+	err := writeCallEventToDB(b, dbEvent)
+	if err == courier.ErrCallNotFound {
+		return err
+	}
+
+	// failed writing, write to our spool instead
+	if err != nil {
+		err = courier.WriteToSpool(b.config.SpoolDir, "calls", dbEvent)
+	}
+
+	return err
+}
+
+const updateCallExternalID = `
+UPDATE channels_channelsession SET 
+	status = :status,
+	modified_on = :modified_on
+
+WHERE channels_channelsession.id IN
+	(SELECT channels_channelsession.id 
+		FROM channels_channelsession INNER JOIN channels_channel ON (channels_channelsession.channel_id = channels_channel.id) 
+		WHERE (channels_channelsession.external_id = :external_id AND channels_channel.uuid = :channel_uuid)) 
+		RETURNING channels_channelsession.id
+`
+
+// writeCallEventToDB writes the passed in call event to our db
+func writeCallEventToDB(b *backend, event *DBCallEvent) error {
+	if event.ExternalID() == "" {
+		return fmt.Errorf("attempt to update call without external id")
+	}
+
+	rows, err := b.db.NamedQuery(updateCallExternalID, event)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// scan and read the id of the call that was updated
+	if rows.Next() {
+		rows.Scan(&event.SessionID_)
+	} else {
+		return courier.ErrCallNotFound
+	}
+
+	return nil
+}
+
+func (b *backend) flushCallEventFile(filename string, contents []byte) error {
+	event := &DBCallEvent{}
+	err := json.Unmarshal(contents, event)
+	if err != nil {
+		log.Printf("ERROR unmarshalling spool file '%s', renaming: %s\n", filename, err)
+		os.Rename(filename, fmt.Sprintf("%s.error", filename))
+		return nil
+	}
+
+	return writeCallEventToDB(b, event)
+}
+
+//-----------------------------------------------------------------------------
+// CallEvent implementation
+//-----------------------------------------------------------------------------
+
+// DBCallEvent represents a change in state of a call, which RapidPro stores as a channel session
+type DBCallEvent struct {
+	ChannelUUID_ courier.ChannelUUID     `json:"channel_uuid"             db:"channel_uuid"`
+	SessionID_   int64                   `json:"session_id,omitempty"     db:"session_id"`
+	ExternalID_  string                  `json:"external_id"              db:"external_id"`
+	Status_      courier.CallStatusValue `json:"status"                   db:"status"`
+	ModifiedOn_  time.Time               `json:"modified_on"              db:"modified_on"`
+
+	logs []*courier.ChannelLog
+}
+
+func (e *DBCallEvent) ChannelUUID() courier.ChannelUUID { return e.ChannelUUID_ }
+
+func (e *DBCallEvent) ExternalID() string      { return e.ExternalID_ }
+func (e *DBCallEvent) SetExternalID(id string) { e.ExternalID_ = id }
+
+func (e *DBCallEvent) Status() courier.CallStatusValue          { return e.Status_ }
+func (e *DBCallEvent) SetStatus(status courier.CallStatusValue) { e.Status_ = status }
+
+func (e *DBCallEvent) Logs() []*courier.ChannelLog    { return e.logs }
+func (e *DBCallEvent) AddLog(log *courier.ChannelLog) { e.logs = append(e.logs, log) }
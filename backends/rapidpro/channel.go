@@ -74,6 +74,27 @@ func loadChannelFromDB(b *backend, channel *DBChannel, channelType courier.Chann
 	return nil
 }
 
+const lookupChannelsFromTypeSQL = `
+SELECT org_id, id, uuid, channel_type, schemes, address, country, config 
+FROM channels_channel 
+WHERE channel_type = $1 AND is_active = true AND org_id IS NOT NULL`
+
+// getChannels loads all the active channels with the passed in type from our database, caching each of them locally
+func getChannels(b *backend, channelType courier.ChannelType) ([]courier.Channel, error) {
+	dbChannels := make([]*DBChannel, 0)
+	err := b.db.Select(&dbChannels, lookupChannelsFromTypeSQL, channelType)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := make([]courier.Channel, len(dbChannels))
+	for i, channel := range dbChannels {
+		cacheLocalChannel(channel)
+		channels[i] = channel
+	}
+	return channels, nil
+}
+
 // getLocalChannel returns a Channel object for the passed in type and UUID.
 func getLocalChannel(channelType courier.ChannelType, uuid courier.ChannelUUID) (*DBChannel, error) {
 	// first see if the channel exists in our local cache
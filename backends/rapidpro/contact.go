@@ -45,6 +45,17 @@ FROM contacts_contact AS c, contacts_contacturn AS u
 WHERE u.identity = $1 AND u.contact_id = c.id AND u.org_id = $2 AND c.is_active = TRUE AND c.is_test = FALSE
 `
 
+// lookupContactForURN looks up the contact for the passed in URN without creating one, returning sql.ErrNoRows if
+// there isn't one
+func lookupContactForURN(db *sqlx.DB, org OrgID, urn courier.URN) (*DBContact, error) {
+	contact := &DBContact{}
+	err := db.Get(contact, lookupContactFromURNSQL, urn.Identity(), org)
+	if err != nil {
+		return nil, err
+	}
+	return contact, nil
+}
+
 // contactForURN first tries to look up a contact for the passed in URN, if not finding one then creating one
 func contactForURN(db *sqlx.DB, org OrgID, channelID courier.ChannelID, urn courier.URN, name string) (*DBContact, error) {
 	// try to look up our contact by URN
@@ -62,7 +73,7 @@ func contactForURN(db *sqlx.DB, org OrgID, channelID courier.ChannelID, urn cour
 
 	// didn't find it, we need to create it instead
 	contact.OrgID = org
-	contact.UUID = uuid.NewV4().String()
+	contact.UUID_ = courier.ContactUUID{UUID: uuid.NewV4()}
 	contact.CreatedOn = time.Now()
 	contact.ModifiedOn = time.Now()
 
@@ -96,10 +107,10 @@ func contactForURN(db *sqlx.DB, org OrgID, channelID courier.ChannelID, urn cour
 
 // DBContact is our struct for a contact in the database
 type DBContact struct {
-	OrgID OrgID       `db:"org_id"`
-	ID    ContactID   `db:"id"`
-	UUID  string      `db:"uuid"`
-	Name  null.String `db:"name"`
+	OrgID OrgID               `db:"org_id"`
+	ID    ContactID           `db:"id"`
+	UUID_ courier.ContactUUID `db:"uuid"`
+	Name  null.String         `db:"name"`
 
 	URNID ContactURNID `db:"urn_id"`
 
@@ -109,3 +120,6 @@ type DBContact struct {
 	CreatedBy  int `db:"created_by_id"`
 	ModifiedBy int `db:"modified_by_id"`
 }
+
+// UUID returns the UUID for this contact
+func (c *DBContact) UUID() courier.ContactUUID { return c.UUID_ }
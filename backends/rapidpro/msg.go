@@ -57,7 +57,7 @@ func writeMsg(b *backend, msg courier.Msg) error {
 	// if we have media, go download it to S3
 	for i, attachment := range m.Attachments_ {
 		if strings.HasPrefix(attachment, "http") {
-			url, err := downloadMediaToS3(b, m.OrgID_, m.UUID_, attachment)
+			url, err := downloadMediaToS3(b, m.Channel_, m.OrgID_, m.UUID_, attachment)
 			if err != nil {
 				return err
 			}
@@ -168,14 +168,20 @@ func readMsgFromDB(b *backend, id courier.MsgID) (*DBMsg, error) {
 // Media download and classification
 //-----------------------------------------------------------------------------
 
-func downloadMediaToS3(b *backend, orgID OrgID, msgUUID courier.MsgUUID, mediaURL string) (string, error) {
+func downloadMediaToS3(b *backend, channel courier.Channel, orgID OrgID, msgUUID courier.MsgUUID, mediaURL string) (string, error) {
 	parsedURL, err := url.Parse(mediaURL)
 	if err != nil {
 		return "", err
 	}
 
-	// first fetch our media
-	req, err := http.NewRequest("GET", mediaURL, nil)
+	// first fetch our media, some channels need to authenticate this request so let their handler build it
+	var req *http.Request
+	builder, isBuilder := courier.GetHandler(channel.ChannelType()).(courier.MediaDownloadRequestBuilder)
+	if isBuilder {
+		req, err = builder.BuildDownloadMediaRequest(channel, mediaURL)
+	} else {
+		req, err = http.NewRequest("GET", mediaURL, nil)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -331,10 +337,14 @@ type DBMsg struct {
 	Visibility_  MsgVisibility          `json:"visibility"   db:"visibility"`
 	Priority_    courier.MsgPriority    `json:"priority"     db:"priority"`
 	URN_         courier.URN            `json:"urn"`
+	URNAuth_     string                 `json:"urn_auth"`
 	Text_        string                 `json:"text"         db:"text"`
 	Attachments_ pq.StringArray         `json:"attachments"  db:"attachments"`
 	ExternalID_  null.String            `json:"external_id"  db:"external_id"`
 
+	ResponseToID_         courier.MsgID `json:"response_to_id"           db:"response_to_id"`
+	ResponseToExternalID_ string        `json:"response_to_external_id"`
+
 	ChannelID_    courier.ChannelID `json:"channel_id"      db:"channel_id"`
 	ContactID_    ContactID         `json:"contact_id"      db:"contact_id"`
 	ContactURNID_ ContactURNID      `json:"contact_urn_id"  db:"contact_urn_id"`
@@ -363,9 +373,13 @@ func (m *DBMsg) Text() string                  { return m.Text_ }
 func (m *DBMsg) Attachments() []string         { return []string(m.Attachments_) }
 func (m *DBMsg) ExternalID() string            { return m.ExternalID_.String }
 func (m *DBMsg) URN() courier.URN              { return m.URN_ }
+func (m *DBMsg) URNAuth() string               { return m.URNAuth_ }
 func (m *DBMsg) ContactName() string           { return m.ContactName_ }
 func (m *DBMsg) Priority() courier.MsgPriority { return m.Priority_ }
 
+func (m *DBMsg) ResponseToID() courier.MsgID  { return m.ResponseToID_ }
+func (m *DBMsg) ResponseToExternalID() string { return m.ResponseToExternalID_ }
+
 func (m *DBMsg) ReceivedOn() *time.Time { return &m.SentOn_ }
 func (m *DBMsg) SentOn() *time.Time     { return &m.SentOn_ }
 
@@ -377,6 +391,9 @@ func (m *DBMsg) fingerprint() string {
 // WithContactName can be used to set the contact name on a msg
 func (m *DBMsg) WithContactName(name string) courier.Msg { m.ContactName_ = name; return m }
 
+// WithURNAuth can be used to set the auth of the URN this message is for
+func (m *DBMsg) WithURNAuth(auth string) courier.Msg { m.URNAuth_ = auth; return m }
+
 // WithReceivedOn can be used to set sent_on on a msg in a chained call
 func (m *DBMsg) WithReceivedOn(date time.Time) courier.Msg { m.SentOn_ = date; return m }
 
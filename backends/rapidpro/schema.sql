@@ -78,6 +78,22 @@ CREATE TABLE msgs_msg (
     topup_id integer
 );
 
+DROP TABLE IF EXISTS channels_channelsession CASCADE;
+CREATE TABLE channels_channelsession (
+    id serial primary key,
+    is_active boolean NOT NULL,
+    created_on timestamp with time zone NOT NULL,
+    modified_on timestamp with time zone NOT NULL,
+    external_id character varying(255) NOT NULL,
+    status character varying(1) NOT NULL,
+    direction character varying(1) NOT NULL,
+    session_type character varying(1) NOT NULL,
+    channel_id integer NOT NULL references channels_channel(id) on delete cascade,
+    contact_id integer NOT NULL references contacts_contact(id) on delete cascade,
+    contact_urn_id integer NOT NULL references contacts_contacturn(id) on delete cascade,
+    org_id integer NOT NULL references orgs_org(id) on delete cascade
+);
+
 DROP TABLE IF EXISTS channels_channellog CASCADE;
 CREATE TABLE channels_channellog (
     id serial primary key,
@@ -120,6 +120,44 @@ func writeMsgStatusToDB(b *backend, status *DBMsgStatus) error {
 	return nil
 }
 
+// messages which are still wired or sent to the URN which were sent at or before the watermark, messages which were
+// wired without a sent_on use the time they were wired instead
+const updateMsgsDeliveredWatermark = `
+UPDATE msgs_msg SET 
+	status = 'D',
+	modified_on = $4
+
+WHERE msgs_msg.id IN
+	(SELECT msgs_msg.id 
+		FROM msgs_msg 
+		INNER JOIN channels_channel ON (msgs_msg.channel_id = channels_channel.id) 
+		INNER JOIN contacts_contacturn ON (msgs_msg.contact_urn_id = contacts_contacturn.id)
+		WHERE (channels_channel.uuid = $1 AND contacts_contacturn.identity = $2 AND contacts_contacturn.org_id = channels_channel.org_id AND
+		       msgs_msg.direction = 'O' AND msgs_msg.status IN ('W', 'S') AND COALESCE(msgs_msg.sent_on, msgs_msg.modified_on) <= $3))
+		RETURNING msgs_msg.id
+`
+
+// writeDeliveredWatermark marks all the msgs to the passed in URN sent at or before the watermark as delivered,
+// returning a status for each msg that was updated
+func writeDeliveredWatermark(b *backend, channel courier.Channel, urn courier.URN, watermark time.Time) ([]courier.MsgStatus, error) {
+	rows, err := b.db.Query(updateMsgsDeliveredWatermark, channel.UUID().String(), urn.Identity(), watermark, time.Now().In(time.UTC))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make([]courier.MsgStatus, 0, 1)
+	for rows.Next() {
+		status := newMsgStatus(channel, courier.NilMsgID, "", courier.MsgDelivered)
+		err = rows.Scan(&status.ID_)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, rows.Err()
+}
+
 func (b *backend) flushStatusFile(filename string, contents []byte) error {
 	status := &DBMsgStatus{}
 	err := json.Unmarshal(contents, status)
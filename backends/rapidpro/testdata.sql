@@ -37,4 +37,10 @@ INSERT INTO msgs_msg("id", "text", "priority", "created_on", "modified_on", "sen
 INSERT INTO msgs_msg("id", "text", "priority", "created_on", "modified_on", "sent_on", "queued_on", "direction", "status", "visibility",
                         "has_template_error", "msg_count", "error_count", "next_attempt", "external_id", "channel_id", "contact_id", "contact_urn_id", "org_id")
               VALUES(10001, 'test message without external', 500, now(), now(), now(), now(), 'O', 'W', 'V',
-                     False, 1, 0, now(), 'ext1', 10, 100, 1000, 1);                     
\ No newline at end of file
+                     False, 1, 0, now(), 'ext1', 10, 100, 1000, 1);                     
+
+/** ChannelSession with id 100 */
+DELETE from channels_channelsession;
+INSERT INTO channels_channelsession("id", "is_active", "created_on", "modified_on", "external_id", "status", "direction", "session_type",
+                                    "channel_id", "contact_id", "contact_urn_id", "org_id")
+                             VALUES(100, True, now(), now(), 'call1', 'W', 'O', 'F', 10, 100, 1000, 1);
@@ -191,6 +191,18 @@ func updateContactURN(db *sqlx.DB, urn *DBContactURN) error {
 	return err
 }
 
+const updateURNAuth = `
+UPDATE contacts_contacturn
+SET auth = $2
+WHERE id = $1
+`
+
+// updateContactURNAuth sets the auth, such as an access token, that the channel gave us for the passed in URN
+func updateContactURNAuth(db *sqlx.DB, urnID ContactURNID, auth string) error {
+	_, err := db.Exec(updateURNAuth, urnID, auth)
+	return err
+}
+
 // DBContactURN is our struct to map to database level URNs
 type DBContactURN struct {
 	OrgID     OrgID             `db:"org_id"`
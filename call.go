@@ -0,0 +1,42 @@
+package courier
+
+import "errors"
+
+// ErrCallNotFound is returned when trying to write an event for a call that doesn't exist
+var ErrCallNotFound = errors.New("call not found")
+
+// CallStatusValue is the status of a call on a voice channel
+type CallStatusValue string
+
+// Possible values for CallEvent
+const (
+	CallPending    CallStatusValue = "P"
+	CallQueued     CallStatusValue = "Q"
+	CallWired      CallStatusValue = "W"
+	CallRinging    CallStatusValue = "R"
+	CallInProgress CallStatusValue = "I"
+	CallCompleted  CallStatusValue = "D"
+	CallBusy       CallStatusValue = "B"
+	CallFailed     CallStatusValue = "F"
+	CallNoAnswer   CallStatusValue = "N"
+	CallCanceled   CallStatusValue = "C"
+	NilCallStatus  CallStatusValue = ""
+)
+
+//-----------------------------------------------------------------------------
+// CallEvent Interface
+//-----------------------------------------------------------------------------
+
+// CallEvent represents a change in the state of a call, calls are identified by the id the channel gave them
+type CallEvent interface {
+	ChannelUUID() ChannelUUID
+
+	ExternalID() string
+	SetExternalID(string)
+
+	Status() CallStatusValue
+	SetStatus(CallStatusValue)
+
+	Logs() []*ChannelLog
+	AddLog(log *ChannelLog)
+}
@@ -14,10 +14,35 @@ import (
 	// load channel handler packages
 	_ "github.com/nyaruka/courier/handlers/africastalking"
 	_ "github.com/nyaruka/courier/handlers/blackmyna"
+	_ "github.com/nyaruka/courier/handlers/chikka"
+	_ "github.com/nyaruka/courier/handlers/clickatell"
+	_ "github.com/nyaruka/courier/handlers/dart"
+	_ "github.com/nyaruka/courier/handlers/facebook"
+	_ "github.com/nyaruka/courier/handlers/firebase"
+	_ "github.com/nyaruka/courier/handlers/globe"
+	_ "github.com/nyaruka/courier/handlers/highconnection"
+	_ "github.com/nyaruka/courier/handlers/hub9"
+	_ "github.com/nyaruka/courier/handlers/infobip"
+	_ "github.com/nyaruka/courier/handlers/jasmin"
+	_ "github.com/nyaruka/courier/handlers/junebug"
 	_ "github.com/nyaruka/courier/handlers/kannel"
+	_ "github.com/nyaruka/courier/handlers/line"
+	_ "github.com/nyaruka/courier/handlers/m3tech"
+	_ "github.com/nyaruka/courier/handlers/macrokiosk"
+	_ "github.com/nyaruka/courier/handlers/mblox"
+	_ "github.com/nyaruka/courier/handlers/nexmo"
+	_ "github.com/nyaruka/courier/handlers/plivo"
 	_ "github.com/nyaruka/courier/handlers/shaqodoon"
+	_ "github.com/nyaruka/courier/handlers/smpp"
+	_ "github.com/nyaruka/courier/handlers/start"
 	_ "github.com/nyaruka/courier/handlers/telegram"
 	_ "github.com/nyaruka/courier/handlers/twilio"
+	_ "github.com/nyaruka/courier/handlers/twitter"
+	_ "github.com/nyaruka/courier/handlers/verboice"
+	_ "github.com/nyaruka/courier/handlers/viber"
+	_ "github.com/nyaruka/courier/handlers/vumi"
+	_ "github.com/nyaruka/courier/handlers/whatsapp"
+	_ "github.com/nyaruka/courier/handlers/zenvia"
 
 	// load available backends
 
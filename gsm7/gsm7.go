@@ -109,33 +109,32 @@ var validGSM7 = map[rune]byte{
 	'g':  0x67,
 	'h':  0x68,
 	'i':  0x69,
-	'j':  0x7A,
-	'k':  0x7B,
-	'l':  0x7C,
-	'm':  0x7D,
-	'n':  0x7E,
-	'o':  0x7F,
-	'p':  0x80,
-	'q':  0x81,
-	'r':  0x82,
-	's':  0x83,
-	't':  0x84,
-	'u':  0x85,
-	'v':  0x86,
-	'w':  0x87,
-	'x':  0x88,
-	'y':  0x89,
-	'z':  0x8A,
-	'ä':  0x8B,
-	'ö':  0x8C,
-	'ñ':  0x8D,
-	'ü':  0x8E,
-	'à':  0x8F,
+	'j':  0x6A,
+	'k':  0x6B,
+	'l':  0x6C,
+	'm':  0x6D,
+	'n':  0x6E,
+	'o':  0x6F,
+	'p':  0x70,
+	'q':  0x71,
+	'r':  0x72,
+	's':  0x73,
+	't':  0x74,
+	'u':  0x75,
+	'v':  0x76,
+	'w':  0x77,
+	'x':  0x78,
+	'y':  0x79,
+	'z':  0x7A,
+	'ä':  0x7B,
+	'ö':  0x7C,
+	'ñ':  0x7D,
+	'ü':  0x7E,
+	'à':  0x7F,
+}
 
-	// extended char set
-	// 'FF':  0x0A   // Page break
-	// 'CR2': 0x0D   // Control char
-	// 'SS2': 0x1B   // Single shift escape
+// characters in the GSM7 extension table, these are encoded as the escape character followed by their code
+var extendedGSM7 = map[rune]byte{
 	'^':  0x14,
 	'{':  0x28,
 	'}':  0x29,
@@ -147,6 +146,22 @@ var validGSM7 = map[rune]byte{
 	'€':  0x65,
 }
 
+// the escape character which precedes characters in the extension table
+const escapeGSM7 = 0x1B
+
+// the reverse of our tables above, used for decoding
+var validRunes = make(map[byte]rune, len(validGSM7))
+var extendedRunes = make(map[byte]rune, len(extendedGSM7))
+
+func init() {
+	for r, code := range validGSM7 {
+		validRunes[code] = r
+	}
+	for r, code := range extendedGSM7 {
+		extendedRunes[code] = r
+	}
+}
+
 // Characters we replace in GSM7 with versions that can actually be encoded
 var gsm7Replacements = map[rune]rune{
 	'á': 'a',
@@ -180,6 +195,10 @@ var gsm7Replacements = map[rune]rune{
 	'Ù': 'U',
 	'Û': 'U',
 
+	// Portuguese ordinal indicators
+	'ª': 'a',
+	'º': 'o',
+
 	// shit Word likes replacing automatically
 	'’':    '\'',
 	'‘':    '\'',
@@ -193,6 +212,9 @@ var gsm7Replacements = map[rune]rune{
 func IsGSM7(text string) bool {
 	for _, r := range text {
 		_, present := validGSM7[r]
+		if !present {
+			_, present = extendedGSM7[r]
+		}
 		if !present {
 			return false
 		}
@@ -200,6 +222,42 @@ func IsGSM7(text string) bool {
 	return true
 }
 
+// Encode encodes the passed in text as GSM7, one septet per byte rather than packed as that is how SMPP carries it.
+// Characters which can't be encoded are replaced with '?'
+func Encode(text string) []byte {
+	output := make([]byte, 0, len(text))
+	for _, r := range text {
+		if code, present := validGSM7[r]; present {
+			output = append(output, code)
+		} else if code, present := extendedGSM7[r]; present {
+			output = append(output, escapeGSM7, code)
+		} else {
+			output = append(output, validGSM7['?'])
+		}
+	}
+	return output
+}
+
+// Decode decodes the passed in unpacked GSM7 bytes, unknown codes are decoded as '?'
+func Decode(gsm7 []byte) string {
+	output := bytes.Buffer{}
+	for i := 0; i < len(gsm7); i++ {
+		if gsm7[i] == escapeGSM7 && i+1 < len(gsm7) {
+			i++
+			if r, present := extendedRunes[gsm7[i]]; present {
+				output.WriteRune(r)
+				continue
+			}
+		}
+		if r, present := validRunes[gsm7[i]]; present {
+			output.WriteRune(r)
+		} else {
+			output.WriteRune('?')
+		}
+	}
+	return output.String()
+}
+
 // ReplaceNonGSM7Chars replaces all the non-gsm7 characters it can in the passed in string
 func ReplaceNonGSM7Chars(text string) string {
 	output := bytes.Buffer{}
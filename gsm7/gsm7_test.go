@@ -0,0 +1,50 @@
+package gsm7
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsGSM7(t *testing.T) {
+	assert.True(t, IsGSM7("Hello World! {with} [brackets] and €"))
+	assert.True(t, IsGSM7("añejo ÄÖÑÜ§¿äöñüà"))
+	assert.False(t, IsGSM7("☺"))
+	assert.False(t, IsGSM7("não"))
+}
+
+func TestEncodeDecode(t *testing.T) {
+	tcs := []struct {
+		text    string
+		encoded []byte
+		decoded string
+	}{
+		{"", []byte{}, ""},
+		{"Hello", []byte{0x48, 0x65, 0x6C, 0x6C, 0x6F}, "Hello"},
+		{"jklmnopqrstuvwxyz", []byte{0x6A, 0x6B, 0x6C, 0x6D, 0x6E, 0x6F, 0x70, 0x71, 0x72, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7A}, "jklmnopqrstuvwxyz"},
+		{"@£$¥ü", []byte{0x00, 0x01, 0x02, 0x03, 0x7E}, "@£$¥ü"},
+		{"{€}", []byte{0x1B, 0x28, 0x1B, 0x65, 0x1B, 0x29}, "{€}"},
+		{"☺ ok", []byte{0x3F, 0x20, 0x6F, 0x6B}, "? ok"},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.encoded, Encode(tc.text), "encoding %s", tc.text)
+		assert.Equal(t, tc.decoded, Decode(tc.encoded), "decoding %s", tc.text)
+	}
+
+	// every character we can encode must decode back to itself
+	for r := range validGSM7 {
+		assert.Equal(t, string(r), Decode(Encode(string(r))))
+	}
+	for r := range extendedGSM7 {
+		assert.Equal(t, string(r), Decode(Encode(string(r))))
+	}
+
+	// an escape without a following character, or followed by something not in the extension table
+	assert.Equal(t, "?", Decode([]byte{0x1B}))
+	assert.Equal(t, "A", Decode([]byte{0x1B, 0x41}))
+}
+
+func TestReplaceNonGSM7Chars(t *testing.T) {
+	assert.Equal(t, "nao é' a", ReplaceNonGSM7Chars("não é’ ª"))
+}
@@ -12,6 +12,15 @@ type ChannelReceiveMsgFunc func(Channel, http.ResponseWriter, *http.Request) ([]
 // status requests. The Server will take care of looking up the channel by UUID before passing it to this function.
 type ChannelUpdateStatusFunc func(Channel, http.ResponseWriter, *http.Request) ([]MsgStatus, error)
 
+// ChannelReceiveMsgAndStatusFunc is the interface ChannelHandler functions must satisfy to handle a URL which receives
+// both incoming msgs and status updates. The Server will take care of looking up the channel by UUID before passing it
+// to this function.
+type ChannelReceiveMsgAndStatusFunc func(Channel, http.ResponseWriter, *http.Request) ([]Msg, []MsgStatus, error)
+
+// ChannelCallEventFunc is the interface ChannelHandler functions must satisfy to handle events about calls
+// on voice channels. The Server will take care of looking up the channel by UUID before passing it to this function.
+type ChannelCallEventFunc func(Channel, http.ResponseWriter, *http.Request) ([]CallEvent, error)
+
 // ChannelActionHandlerFunc is the interface ChannelHandler functions must satisfy to handle other types
 // of requests. These generic handlers should only be used if they are not dealing with receiving messages
 // or status updates.
@@ -27,6 +36,18 @@ type ChannelHandler interface {
 	SendMsg(Msg) (MsgStatus, error)
 }
 
+// ChannelCallHandler is the interface handlers for voice channels satisfy to start outgoing calls
+type ChannelCallHandler interface {
+	ChannelHandler
+	StartCall(Channel, URN) (CallEvent, error)
+}
+
+// MediaDownloadRequestBuilder is the interface handlers satisfy when the media they attach to incoming messages
+// can only be downloaded with a request that carries the channel's credentials
+type MediaDownloadRequestBuilder interface {
+	BuildDownloadMediaRequest(Channel, string) (*http.Request, error)
+}
+
 // RegisterHandler adds a new handler for a channel type, this is called by individual handlers when they are initialized
 func RegisterHandler(handler ChannelHandler) {
 	registeredHandlers[handler.ChannelType()] = handler
@@ -34,3 +55,8 @@ func RegisterHandler(handler ChannelHandler) {
 
 var registeredHandlers = make(map[ChannelType]ChannelHandler)
 var activeHandlers = make(map[ChannelType]ChannelHandler)
+
+// GetHandler returns the active handler for the passed in channel type, or nil if there isn't one
+func GetHandler(channelType ChannelType) ChannelHandler {
+	return activeHandlers[channelType]
+}
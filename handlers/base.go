@@ -3,12 +3,14 @@ package handlers
 import (
 	"encoding/base64"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"regexp"
 	"strings"
+	"unicode"
 
 	"github.com/gorilla/schema"
 	"github.com/nyaruka/courier"
@@ -83,6 +85,34 @@ func NameFromFirstLastUsername(first string, last string, username string) strin
 	return ""
 }
 
+// SplitMsg splits the passed in text into parts of at most max characters, breaking on whitespace where possible
+func SplitMsg(text string, max int) []string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return []string{text}
+	}
+
+	parts := make([]string, 0, 2)
+	for len(runes) > max {
+		// look for the last space in our window, as long as it isn't too far back
+		split := max
+		for i := max; i > max/2; i-- {
+			if unicode.IsSpace(runes[i]) {
+				split = i
+				break
+			}
+		}
+
+		parts = append(parts, strings.TrimRightFunc(string(runes[:split]), unicode.IsSpace))
+		runes = []rune(strings.TrimLeftFunc(string(runes[split:]), unicode.IsSpace))
+	}
+
+	if len(runes) > 0 {
+		parts = append(parts, string(runes))
+	}
+	return parts
+}
+
 // Validate validates the passe din struct using our shared validator instance
 func Validate(form interface{}) error {
 	return validate.Struct(form)
@@ -151,6 +181,30 @@ func DecodeAndValidateJSON(envelope interface{}, r *http.Request) error {
 	return nil
 }
 
+// DecodeAndValidateXML takes the passed in envelope and tries to unmarshal it from the body
+// of the passed in request, then validating it
+func DecodeAndValidateXML(envelope interface{}, r *http.Request) error {
+	// read our body
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 100000))
+	defer r.Body.Close()
+	if err != nil {
+		return fmt.Errorf("unable to read request body: %s", err)
+	}
+
+	// try to decode our envelope
+	if err = xml.Unmarshal(body, envelope); err != nil {
+		return fmt.Errorf("unable to parse request XML: %s", err)
+	}
+
+	// check our input is valid
+	err = validate.Struct(envelope)
+	if err != nil {
+		return fmt.Errorf("request XML doesn't match required schema: %s", err)
+	}
+
+	return nil
+}
+
 /*
 DecodePossibleBase64 detects and decodes a possibly base64 encoded messages by doing:
  * check it's at least 60 characters
@@ -1,3 +1,225 @@
 package chikka
 
-/* no logs */
+/*
+POST /c/ck/uuid/receive/
+message_type=incoming&mobile_number=639178020779&shortcode=29290930&request_id=4004&message=Hello+World&timestamp=1457670059.69
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+)
+
+const (
+	typeIncoming = "incoming"
+	typeOutgoing = "outgoing"
+
+	typeSend  = "SEND"
+	typeReply = "REPLY"
+)
+
+var sendURL = "https://post.chikka.com/smsapi/request"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Chikka handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("CK"), "Chikka")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+
+	// Chikka posts both incoming messages and delivery notifications to the same URL
+	return s.AddReceiveMsgAndStatusRoute(h, "POST", "receive", h.ReceiveEvent)
+}
+
+type ckRequest struct {
+	MessageType string `validate:"required" name:"message_type"`
+}
+
+type ckMessage struct {
+	MobileNumber string  `validate:"required" name:"mobile_number"`
+	RequestID    string  `validate:"required" name:"request_id"`
+	Message      string  `name:"message"`
+	Timestamp    float64 `validate:"required" name:"timestamp"`
+}
+
+type ckStatus struct {
+	MessageID int64  `validate:"required" name:"message_id"`
+	Status    string `validate:"required" name:"status"`
+}
+
+var ckStatusMapping = map[string]courier.MsgStatusValue{
+	"SENT":   courier.MsgSent,
+	"FAILED": courier.MsgFailed,
+}
+
+// ReceiveEvent is our HTTP handler function for incoming messages and delivery notifications, Chikka posts
+// both to the same URL, distinguished by their message_type
+func (h *handler) ReceiveEvent(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, []courier.MsgStatus, error) {
+	ckRequest := &ckRequest{}
+	err := handlers.DecodeAndValidateForm(ckRequest, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch ckRequest.MessageType {
+	case typeIncoming:
+		msgs, err := h.receiveMessage(channel, w, r)
+		return msgs, nil, err
+
+	case typeOutgoing:
+		statuses, err := h.receiveStatus(channel, w, r)
+		return nil, statuses, err
+
+	default:
+		return nil, nil, fmt.Errorf("unknown message_type '%s', must be one of '%s' or '%s'", ckRequest.MessageType, typeIncoming, typeOutgoing)
+	}
+}
+
+// receiveMessage decodes and writes the incoming message in the passed in request
+func (h *handler) receiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	ckMessage := &ckMessage{}
+	err := handlers.DecodeAndValidateForm(ckMessage, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// create our date from the timestamp, which is in seconds with fractional parts
+	date := time.Unix(0, int64(ckMessage.Timestamp*float64(time.Second))).Round(time.Microsecond).UTC()
+
+	// create our URN
+	urn := courier.NewTelURNForChannel(ckMessage.MobileNumber, channel)
+
+	// build our msg, we use the request id as the external id so replies can reference it
+	msg := h.Backend().NewIncomingMsg(channel, urn, ckMessage.Message).WithExternalID(ckMessage.RequestID).WithReceivedOn(date)
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, writeAck(w)
+}
+
+// receiveStatus decodes and writes the delivery notification in the passed in request
+func (h *handler) receiveStatus(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.MsgStatus, error) {
+	ckStatus := &ckStatus{}
+	err := handlers.DecodeAndValidateForm(ckStatus, r)
+	if err != nil {
+		return nil, err
+	}
+
+	msgStatus, found := ckStatusMapping[strings.ToUpper(ckStatus.Status)]
+	if !found {
+		return nil, fmt.Errorf("unknown status '%s', must be either 'SENT' or 'FAILED'", ckStatus.Status)
+	}
+
+	// write our status
+	status := h.Backend().NewMsgStatusForID(channel, courier.NewMsgID(ckStatus.MessageID), msgStatus)
+	err = h.Backend().WriteMsgStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.MsgStatus{status}, writeAck(w)
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
+	if username == "" {
+		return nil, fmt.Errorf("no username set for CK channel")
+	}
+
+	password := msg.Channel().StringConfigForKey(courier.ConfigPassword, "")
+	if password == "" {
+		return nil, fmt.Errorf("no password set for CK channel")
+	}
+
+	form := url.Values{
+		"message_type":  []string{typeSend},
+		"mobile_number": []string{strings.TrimPrefix(msg.URN().Path(), "+")},
+		"shortcode":     []string{strings.TrimPrefix(msg.Channel().Address(), "+")},
+		"message_id":    []string{msg.ID().String()},
+		"message":       []string{courier.GetTextAndAttachments(msg)},
+		"request_cost":  []string{"FREE"},
+		"client_id":     []string{username},
+		"secret_key":    []string{password},
+	}
+
+	// if we are responding to a message, we reply to its request id
+	if msg.ResponseToExternalID() != "" {
+		form["message_type"] = []string{typeReply}
+		form["request_id"] = []string{msg.ResponseToExternalID()}
+	}
+
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	rr, err := sendForm(form)
+	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+	status.AddLog(log)
+
+	// replies are only accepted within a window after the incoming message, if our request id has
+	// expired or was already used, try again as a plain send
+	if err != nil && form.Get("message_type") == typeReply && isInvalidRequestID(rr) {
+		form["message_type"] = []string{typeSend}
+		delete(form, "request_id")
+
+		rr, err = sendForm(form)
+		log = courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+		status.AddLog(log)
+	}
+
+	if err != nil {
+		return status, nil
+	}
+
+	status.SetStatus(courier.MsgWired)
+	return status, nil
+}
+
+// sendForm posts the passed in form to Chikka
+func sendForm(form url.Values) (*utils.RequestResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, sendURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return utils.MakeHTTPRequest(req)
+}
+
+// isInvalidRequestID returns whether the passed in response is Chikka rejecting the request id we replied to
+func isInvalidRequestID(rr *utils.RequestResponse) bool {
+	if rr == nil || rr.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	description, err := jsonparser.GetString(rr.Body, "description")
+	if err != nil {
+		return false
+	}
+	return description == "Invalid/Used Request ID"
+}
+
+func writeAck(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	_, err := fmt.Fprint(w, "Accepted")
+	return err
+}
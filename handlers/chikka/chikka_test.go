@@ -0,0 +1,122 @@
+package chikka
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/config"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "CK", "2020", "PH", nil),
+}
+
+var (
+	receiveURL = "/c/ck/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+
+	receiveValid        = "message_type=incoming&mobile_number=639178020779&shortcode=29290930&request_id=4004&message=Hello+World&timestamp=1457670059.69"
+	receiveMissingFrom  = "message_type=incoming&shortcode=29290930&request_id=4004&message=Hello+World&timestamp=1457670059.69"
+	receiveMissingType  = "mobile_number=639178020779&shortcode=29290930&request_id=4004&message=Hello+World&timestamp=1457670059.69"
+	receiveUnknownType  = "message_type=unknown&mobile_number=639178020779&request_id=4004&message=Hello+World&timestamp=1457670059.69"
+	statusSent          = "message_type=outgoing&message_id=10&status=SENT&credits_cost=10&timestamp=1457672059.69"
+	statusFailed        = "message_type=outgoing&message_id=10&status=FAILED&credits_cost=10&timestamp=1457672059.69"
+	statusUnknown       = "message_type=outgoing&message_id=10&status=UNKNOWN&credits_cost=10&timestamp=1457672059.69"
+	statusMissingStatus = "message_type=outgoing&message_id=10&credits_cost=10&timestamp=1457672059.69"
+)
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid", URL: receiveURL, Data: receiveValid, Status: 200, Response: "Accepted",
+		Text: Sp("Hello World"), URN: Sp("tel:+639178020779"), External: Sp("4004"), Date: Tp(time.Date(2016, 3, 11, 4, 20, 59, 690000000, time.UTC))},
+	{Label: "Receive Missing From", URL: receiveURL, Data: receiveMissingFrom, Status: 400, Response: "field 'mobilenumber' required"},
+	{Label: "Receive Missing Type", URL: receiveURL, Data: receiveMissingType, Status: 400, Response: "field 'messagetype' required"},
+	{Label: "Receive Unknown Type", URL: receiveURL, Data: receiveUnknownType, Status: 400, Response: "unknown message_type 'unknown'"},
+
+	{Label: "Status Sent", URL: receiveURL, Data: statusSent, Status: 200, Response: "Accepted"},
+	{Label: "Status Failed", URL: receiveURL, Data: statusFailed, Status: 200, Response: "Accepted"},
+	{Label: "Status Unknown", URL: receiveURL, Data: statusUnknown, Status: 400, Response: "unknown status 'UNKNOWN'"},
+	{Label: "Status Missing Status", URL: receiveURL, Data: statusMissingStatus, Status: 400, Response: "field 'status' required"},
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func TestStatusEvents(t *testing.T) {
+	mb := courier.NewMockBackend()
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	// delivery notifications should be returned as statuses so they aren't logged as failed receives
+	r := httptest.NewRequest(http.MethodPost, receiveURL, strings.NewReader(statusFailed))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	msgs, statuses, err := h.ReceiveEvent(testChannels[0], httptest.NewRecorder(), r)
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+	require.Equal(t, 1, len(statuses))
+	assert.Equal(t, courier.NewMsgID(10), statuses[0].ID())
+	assert.Equal(t, courier.MsgFailed, statuses[0].Status())
+
+	status, err := mb.GetLastMsgStatus()
+	require.NoError(t, err)
+	assert.Equal(t, statuses[0], status)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	sendURL = server.URL
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "tel:+63911231234",
+		Status:       "W",
+		ResponseBody: `{"status":200,"message":"ACCEPTED"}`, ResponseStatus: 200,
+		PostParams: map[string]string{"message_type": "SEND", "mobile_number": "63911231234", "shortcode": "2020", "message_id": "10",
+			"message": "Simple Message", "request_cost": "FREE", "client_id": "Username", "secret_key": "Password"},
+		SendPrep: setSendURL},
+	{Label: "Reply Send",
+		Text: "Simple Message", URN: "tel:+63911231234", ResponseToID: 5, ResponseToExternalID: "4004",
+		Status:       "W",
+		ResponseBody: `{"status":200,"message":"ACCEPTED"}`, ResponseStatus: 200,
+		PostParams: map[string]string{"message_type": "REPLY", "request_id": "4004", "message": "Simple Message"},
+		SendPrep:   setSendURL},
+	{Label: "Expired Reply Send",
+		Text: "Simple Message", URN: "tel:+63911231234", ResponseToID: 5, ResponseToExternalID: "4004",
+		Status:       "E",
+		ResponseBody: `{"status":400,"message":"BAD REQUEST","description":"Invalid\/Used Request ID"}`, ResponseStatus: 400,
+		PostParams: map[string]string{"message_type": "SEND", "request_id": ""},
+		SendPrep:   setSendURL},
+	{Label: "Send Attachment",
+		Text: "My pic!", URN: "tel:+63911231234", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status:       "W",
+		ResponseBody: `{"status":200,"message":"ACCEPTED"}`, ResponseStatus: 200,
+		PostParams: map[string]string{"message": "My pic!\nhttps://foo.bar/image.jpg"},
+		SendPrep:   setSendURL},
+	{Label: "Error Sending",
+		Text: "Error Message", URN: "tel:+63911231234",
+		Status:       "E",
+		ResponseBody: `{"status":400,"message":"BAD REQUEST","description":"Invalid mobile number"}`, ResponseStatus: 400,
+		SendPrep: setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "CK", "2020", "PH",
+		map[string]interface{}{
+			courier.ConfigUsername: "Username",
+			courier.ConfigPassword: "Password",
+		})
+
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+}
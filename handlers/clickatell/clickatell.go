@@ -3,3 +3,211 @@ package clickatell
 /*
 GET /api/v1/clickatell/receive/uuid?api_id=12345&from=263778181111&timestamp=2017-05-03+07%3A30%3A10&text=Msg&charset=ISO-8859-1&udh=&moMsgId=b1e4782a3c87339d706ab1343b4df1ce&to=33500
 */
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/gsm7"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+const configAPIID = "api_id"
+
+var sendURL = "https://api.clickatell.com/http/sendmsg"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Clickatell handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("CT"), "Clickatell")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddReceiveMsgRoute(h, "GET", "receive", h.ReceiveMessage)
+	if err != nil {
+		return err
+	}
+
+	return s.AddUpdateStatusRoute(h, "GET", "status", h.StatusMessage)
+}
+
+type ctMessage struct {
+	MoMsgID   string `validate:"required" name:"moMsgId"`
+	From      string `validate:"required" name:"from"`
+	To        string `validate:"required" name:"to"`
+	Text      string `name:"text"`
+	Charset   string `name:"charset"`
+	Timestamp string `name:"timestamp"`
+}
+
+type ctStatus struct {
+	APIMsgID string `validate:"required" name:"apiMsgId"`
+	Status   int    `validate:"required" name:"status"`
+}
+
+var ctStatusMapping = map[int]courier.MsgStatusValue{
+	1:  courier.MsgFailed,    // message unknown
+	2:  courier.MsgWired,     // message queued
+	3:  courier.MsgSent,      // delivered to gateway
+	4:  courier.MsgDelivered, // received by recipient
+	5:  courier.MsgFailed,    // error with message
+	6:  courier.MsgFailed,    // user cancelled message delivery
+	7:  courier.MsgFailed,    // error delivering message
+	8:  courier.MsgWired,     // message received by gateway
+	9:  courier.MsgFailed,    // routing error
+	10: courier.MsgFailed,    // message expired
+	11: courier.MsgWired,     // message queued for later delivery
+	12: courier.MsgFailed,    // out of credit
+	14: courier.MsgFailed,    // maximum MT limit exceeded
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	// get our params
+	ctMsg := &ctMessage{}
+	err := handlers.DecodeAndValidateQueryParams(ctMsg, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// our text is encoded in whatever charset Clickatell tells us, convert it to UTF-8
+	text := ctMsg.Text
+	switch strings.ToUpper(ctMsg.Charset) {
+	case "ISO-8859-1":
+		text = utils.DecodeLatin1([]byte(ctMsg.Text))
+	case "UTF-16BE":
+		text, err = utils.DecodeUTF16BE([]byte(ctMsg.Text))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// dates come in UTC
+	date := time.Now().UTC()
+	if ctMsg.Timestamp != "" {
+		date, err = time.Parse("2006-01-02 15:04:05", ctMsg.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp: %s", ctMsg.Timestamp)
+		}
+	}
+
+	// create our URN
+	urn := courier.NewTelURNForChannel(ctMsg.From, channel)
+
+	// build our msg
+	msg := h.Backend().NewIncomingMsg(channel, urn, text).WithExternalID(ctMsg.MoMsgID).WithReceivedOn(date)
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, courier.WriteReceiveSuccess(w, r, msg)
+}
+
+// StatusMessage is our HTTP handler function for status updates
+func (h *handler) StatusMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.MsgStatus, error) {
+	// get our params
+	ctStatus := &ctStatus{}
+	err := handlers.DecodeAndValidateQueryParams(ctStatus, r)
+	if err != nil {
+		return nil, err
+	}
+
+	msgStatus, found := ctStatusMapping[ctStatus.Status]
+	if !found {
+		return nil, fmt.Errorf("unknown status '%d'", ctStatus.Status)
+	}
+
+	// write our status
+	status := h.Backend().NewMsgStatusForExternalID(channel, ctStatus.APIMsgID, msgStatus)
+	err = h.Backend().WriteMsgStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.MsgStatus{status}, courier.WriteStatusSuccess(w, r, status)
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	apiID := msg.Channel().StringConfigForKey(configAPIID, "")
+	if apiID == "" {
+		return nil, fmt.Errorf("no api id set for CT channel")
+	}
+
+	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
+	if username == "" {
+		return nil, fmt.Errorf("no username set for CT channel")
+	}
+
+	password := msg.Channel().StringConfigForKey(courier.ConfigPassword, "")
+	if password == "" {
+		return nil, fmt.Errorf("no password set for CT channel")
+	}
+
+	// build our request, we ask for concatenation of up to 3 parts and for all intermediate and final statuses
+	form := url.Values{
+		"api_id":   []string{apiID},
+		"user":     []string{username},
+		"password": []string{password},
+		"from":     []string{strings.TrimPrefix(msg.Channel().Address(), "+")},
+		"to":       []string{strings.TrimPrefix(msg.URN().Path(), "+")},
+		"concat":   []string{"3"},
+		"callback": []string{"7"},
+		"mo":       []string{"1"},
+	}
+
+	// send as GSM7 if we can, otherwise send as unicode which Clickatell wants as hex encoded UTF-16BE
+	text := gsm7.ReplaceNonGSM7Chars(courier.GetTextAndAttachments(msg))
+	if gsm7.IsGSM7(text) {
+		form["unicode"] = []string{"0"}
+		form["text"] = []string{text}
+	} else {
+		form["unicode"] = []string{"1"}
+		form["text"] = []string{hex.EncodeToString(utils.EncodeUTF16BE(courier.GetTextAndAttachments(msg)))}
+	}
+
+	msgURL, _ := url.Parse(sendURL)
+	msgURL.RawQuery = form.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, msgURL.String(), nil)
+	rr, err := utils.MakeHTTPRequest(req)
+
+	// record our status and log
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+	status.AddLog(log)
+	if err != nil {
+		return status, nil
+	}
+
+	// successful sends look like: ID: 1e3b5d5f7a4b1a4e2c7e8b2f, errors like: ERR: 114, Cannot route message
+	body := strings.TrimSpace(string(rr.Body))
+	if !strings.HasPrefix(body, "ID: ") {
+		log.WithError("Message Send Error", errors.Errorf("received error from Clickatell: %s", body))
+		return status, nil
+	}
+
+	status.SetStatus(courier.MsgWired)
+	status.SetExternalID(strings.TrimSpace(strings.TrimPrefix(body, "ID: ")))
+
+	return status, nil
+}
@@ -0,0 +1,111 @@
+package clickatell
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "CT", "2020", "US", nil),
+}
+
+var (
+	receiveURL = "/c/ct/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+	statusURL  = "/c/ct/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status/"
+
+	receiveValid = receiveURL + "?api_id=12345&from=263778181111&timestamp=2017-05-03+07%3A30%3A10&text=Msg&charset=ISO-8859-1" +
+		"&udh=&moMsgId=b1e4782a3c87339d706ab1343b4df1ce&to=33500"
+	receiveLatin1 = receiveURL + "?api_id=12345&from=263778181111&timestamp=2017-05-03+07%3A30%3A10&text=%E9t%E9&charset=ISO-8859-1" +
+		"&moMsgId=b1e4782a3c87339d706ab1343b4df1ce&to=33500"
+	receiveUTF16 = receiveURL + "?api_id=12345&from=263778181111&timestamp=2017-05-03+07%3A30%3A10&text=%00m%00e%00s%00s%00a%00g%00e%00+%00%E2%26%3A" +
+		"&charset=UTF-16BE&moMsgId=b1e4782a3c87339d706ab1343b4df1ce&to=33500"
+	receiveInvalidUTF16 = receiveURL + "?api_id=12345&from=263778181111&text=%00m%00&charset=UTF-16BE&moMsgId=b1e4782a3c87339d706ab1343b4df1ce&to=33500"
+	receiveMissingFrom  = receiveURL + "?api_id=12345&text=Msg&moMsgId=b1e4782a3c87339d706ab1343b4df1ce&to=33500"
+	receiveInvalidDate  = receiveURL + "?api_id=12345&from=263778181111&timestamp=20170503&text=Msg&moMsgId=b1e4782a3c87339d706ab1343b4df1ce&to=33500"
+
+	statusDelivered = statusURL + "?apiMsgId=id1234&cliMsgId=&status=004&timestamp=1459288245&to=263778181111&from=2020&charge=0.3"
+	statusFailed    = statusURL + "?apiMsgId=id1234&cliMsgId=&status=009&timestamp=1459288245&to=263778181111&from=2020&charge=0.3"
+	statusUnknown   = statusURL + "?apiMsgId=id1234&status=013"
+	statusMissingID = statusURL + "?status=004"
+)
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid", URL: receiveValid, Status: 200, Response: "Accepted",
+		Text: Sp("Msg"), URN: Sp("tel:+263778181111"), External: Sp("b1e4782a3c87339d706ab1343b4df1ce"), Date: Tp(time.Date(2017, 5, 3, 7, 30, 10, 0, time.UTC))},
+	{Label: "Receive ISO-8859-1", URL: receiveLatin1, Status: 200, Response: "Accepted", Text: Sp("été")},
+	{Label: "Receive UTF-16BE", URL: receiveUTF16, Status: 200, Response: "Accepted", Text: Sp("message â☺")},
+	{Label: "Receive Invalid UTF-16BE", URL: receiveInvalidUTF16, Status: 400, Response: "even number of bytes"},
+	{Label: "Receive Missing From", URL: receiveMissingFrom, Status: 400, Response: "field 'from' required"},
+	{Label: "Receive Invalid Date", URL: receiveInvalidDate, Status: 400, Response: "invalid timestamp: 20170503"},
+
+	{Label: "Status Delivered", URL: statusDelivered, Status: 200, Response: `"status":"D"`},
+	{Label: "Status Failed", URL: statusFailed, Status: 200, Response: `"status":"F"`},
+	{Label: "Status Unknown", URL: statusUnknown, Status: 400, Response: "unknown status '13'"},
+	{Label: "Status Missing ID", URL: statusMissingID, Status: 400, Response: "field 'apimsgid' required"},
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	sendURL = server.URL
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "tel:+250788383383",
+		Status: "W", ExternalID: "id1002",
+		ResponseBody: `ID: id1002`, ResponseStatus: 200,
+		URLParams: map[string]string{"api_id": "API-ID", "user": "Username", "password": "Password", "from": "2020", "to": "250788383383",
+			"text": "Simple Message", "unicode": "0", "concat": "3", "callback": "7", "mo": "1"},
+		SendPrep: setSendURL},
+	{Label: "Smart Encoding",
+		Text: "Fancy “Smart” Quotes", URN: "tel:+250788383383",
+		Status: "W", ExternalID: "id1002",
+		ResponseBody: `ID: id1002`, ResponseStatus: 200,
+		URLParams: map[string]string{"text": `Fancy "Smart" Quotes`, "unicode": "0"},
+		SendPrep:  setSendURL},
+	{Label: "Unicode Send",
+		Text: "Hi ☺", URN: "tel:+250788383383",
+		Status: "W", ExternalID: "id1002",
+		ResponseBody: `ID: id1002`, ResponseStatus: 200,
+		URLParams: map[string]string{"text": "004800690020263a", "unicode": "1"},
+		SendPrep:  setSendURL},
+	{Label: "Send Attachment",
+		Text: "My pic!", URN: "tel:+250788383383", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status: "W", ExternalID: "id1002",
+		ResponseBody: `ID: id1002`, ResponseStatus: 200,
+		URLParams: map[string]string{"text": "My pic!\nhttps://foo.bar/image.jpg"},
+		SendPrep:  setSendURL},
+	{Label: "Error Response",
+		Text: "Error Message", URN: "tel:+250788383383",
+		Status:       "E",
+		ResponseBody: `ERR: 114, Cannot route message`, ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Error Sending",
+		Text: "Error Message", URN: "tel:+250788383383",
+		Status:       "E",
+		ResponseBody: `Error`, ResponseStatus: 401,
+		SendPrep: setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "CT", "2020", "US",
+		map[string]interface{}{
+			configAPIID:            "API-ID",
+			courier.ConfigUsername: "Username",
+			courier.ConfigPassword: "Password",
+		})
+
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+}
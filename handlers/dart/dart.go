@@ -3,3 +3,238 @@ package dart
 /*
 GET /handlers/dartmedia/received/uuid?userid=username&password=xxxxxxxx&original=6285218761111&sendto=93456&messagetype=0&messageid=170503131327@170504131327@93456SMS9755064&message=Msg&date=20170503131559&dcs=0&udhl=0&charset=utf-8
 */
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DartMedia acknowledges every request with this body and expects the same from us
+	ackResponse = "000"
+
+	maxMsgLength = 160
+)
+
+var sendURL = "http://202.43.169.11/APIhttpU/receive2waysms.php"
+
+// DartMedia only reports 10 for delivered messages, anything else is a failure
+var statusMapping = map[int]courier.MsgStatusValue{
+	10: courier.MsgDelivered,
+}
+
+var errorCodes = map[string]string{
+	"001": "Authentication error",
+	"101": "Account expired or invalid parameters",
+	"102": "Invalid IP address",
+	"103": "Invalid destination number",
+	"104": "Invalid sender",
+	"105": "Message too long",
+	"106": "Invalid message type",
+	"107": "Insufficient credit",
+	"108": "Duplicate message id",
+	"201": "Internal server error",
+	"202": "Connection to operator failed",
+}
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+	sendURL       string
+	statusMapping map[int]courier.MsgStatusValue
+}
+
+// NewHandler returns a new DartMedia handler
+func NewHandler() courier.ChannelHandler {
+	return NewDartHandler(courier.ChannelType("DA"), "DartMedia", sendURL, statusMapping)
+}
+
+// NewDartHandler returns a new handler for a channel type using the DartMedia protocol, such as Hub9, which
+// sends to the passed in URL and maps delivery report status codes using the passed in mapping
+func NewDartHandler(channelType courier.ChannelType, name string, sendURL string, statusMapping map[int]courier.MsgStatusValue) courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(channelType, name), sendURL, statusMapping}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddReceiveMsgRoute(h, "GET", "received", h.ReceiveMessage)
+	if err != nil {
+		return err
+	}
+
+	return s.AddUpdateStatusRoute(h, "GET", "delivered", h.StatusMessage)
+}
+
+type daMessage struct {
+	Original  string `validate:"required" name:"original"`
+	SendTo    string `validate:"required" name:"sendto"`
+	MessageID string `validate:"required" name:"messageid"`
+	Message   string `name:"message"`
+	Date      string `name:"date"`
+}
+
+type daStatus struct {
+	MessageID string `validate:"required" name:"messageid"`
+	Status    *int   `validate:"required" name:"status"`
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	// get our params
+	daMsg := &daMessage{}
+	err := handlers.DecodeAndValidateQueryParams(daMsg, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// message ids are made up of the submit date, expiry date and DartMedia's id joined by @, we only keep their id
+	idParts := strings.Split(daMsg.MessageID, "@")
+	externalID := idParts[len(idParts)-1]
+
+	// dates are in Jakarta time
+	date := time.Now().UTC()
+	if daMsg.Date != "" {
+		loc, err := time.LoadLocation("Asia/Jakarta")
+		if err != nil {
+			return nil, err
+		}
+
+		date, err = time.ParseInLocation("20060102150405", daMsg.Date, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date: %s", daMsg.Date)
+		}
+		date = date.UTC()
+	}
+
+	// create our URN
+	urn := courier.NewTelURNForChannel(daMsg.Original, channel)
+
+	// build our msg
+	msg := h.Backend().NewIncomingMsg(channel, urn, daMsg.Message).WithExternalID(externalID).WithReceivedOn(date)
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, writeAck(w)
+}
+
+// StatusMessage is our HTTP handler function for status updates
+func (h *handler) StatusMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.MsgStatus, error) {
+	// get our params
+	daStatus := &daStatus{}
+	err := handlers.DecodeAndValidateQueryParams(daStatus, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// any status we don't know is a failure
+	msgStatus, found := h.statusMapping[*daStatus.Status]
+	if !found {
+		msgStatus = courier.MsgFailed
+	}
+
+	// our message id is the id of the msg we sent, with a .<part> suffix for multipart messages
+	msgID, err := strconv.ParseInt(strings.Split(daStatus.MessageID, ".")[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid messageid: %s", daStatus.MessageID)
+	}
+
+	// write our status
+	status := h.Backend().NewMsgStatusForID(channel, courier.NewMsgID(msgID), msgStatus)
+	err = h.Backend().WriteMsgStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.MsgStatus{status}, writeAck(w)
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
+	if username == "" {
+		return nil, fmt.Errorf("no username set for %s channel", msg.Channel().ChannelType())
+	}
+
+	password := msg.Channel().StringConfigForKey(courier.ConfigPassword, "")
+	if password == "" {
+		return nil, fmt.Errorf("no password set for %s channel", msg.Channel().ChannelType())
+	}
+
+	// the send URL can be overridden per channel
+	sendURL := msg.Channel().StringConfigForKey(courier.ConfigSendURL, h.sendURL)
+
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	parts := handlers.SplitMsg(courier.GetTextAndAttachments(msg), maxMsgLength)
+	for i, part := range parts {
+		// our msg id is used to correlate delivery reports, multipart messages get a part suffix
+		messageID := msg.ID().String()
+		if len(parts) > 1 {
+			messageID = fmt.Sprintf("%s.%d", msg.ID().String(), i+1)
+		}
+
+		form := url.Values{
+			"userid":    []string{username},
+			"password":  []string{password},
+			"original":  []string{strings.TrimPrefix(msg.Channel().Address(), "+")},
+			"sendto":    []string{strings.TrimPrefix(msg.URN().Path(), "+")},
+			"messageid": []string{messageID},
+			"message":   []string{part},
+			"dcs":       []string{"0"},
+			"udhl":      []string{"0"},
+		}
+
+		msgURL, err := url.Parse(sendURL)
+		if err != nil {
+			return nil, err
+		}
+		msgURL.RawQuery = form.Encode()
+
+		req, err := http.NewRequest(http.MethodGet, msgURL.String(), nil)
+		rr, err := utils.MakeHTTPRequest(req)
+
+		// record our log
+		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+		status.AddLog(log)
+		if err != nil {
+			return status, nil
+		}
+
+		// a successful send is acknowledged with 000, anything else is an error code
+		code := strings.TrimSpace(string(rr.Body))
+		if code != ackResponse {
+			description, found := errorCodes[code]
+			if !found {
+				description = "Unknown error"
+			}
+			log.WithError("Message Send Error", errors.Errorf("received error code '%s': %s", code, description))
+			return status, nil
+		}
+	}
+
+	status.SetStatus(courier.MsgWired)
+	return status, nil
+}
+
+func writeAck(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	_, err := fmt.Fprint(w, ackResponse)
+	return err
+}
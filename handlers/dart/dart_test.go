@@ -0,0 +1,102 @@
+package dart
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "DA", "2020", "ID", nil),
+}
+
+var (
+	receiveURL = "/c/da/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/received/"
+	statusURL  = "/c/da/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered/"
+
+	receiveValid = receiveURL + "?userid=username&password=xxxxxxxx&original=6285218761111&sendto=93456&messagetype=0" +
+		"&messageid=170503131327@170504131327@93456SMS9755064&message=Msg&date=20170503131559&dcs=0&udhl=0&charset=utf-8"
+	receiveMissingFrom = receiveURL + "?userid=username&password=xxxxxxxx&sendto=93456&messageid=170503131327@170504131327@93456SMS9755064&message=Msg"
+	receiveInvalidDate = receiveURL + "?original=6285218761111&sendto=93456&messageid=170503131327@170504131327@93456SMS9755064&message=Msg&date=2017-05-03"
+
+	statusDelivered = statusURL + "?messageid=12345&status=10"
+	statusFailed    = statusURL + "?messageid=12345&status=21"
+	statusZero      = statusURL + "?messageid=12345&status=0"
+	statusMissing   = statusURL + "?messageid=12345"
+	statusPart      = statusURL + "?messageid=12345.2&status=10"
+	statusInvalidID = statusURL + "?messageid=abc&status=10"
+	statusMissingID = statusURL + "?status=10"
+)
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid", URL: receiveValid, Status: 200, Response: "000",
+		Text: Sp("Msg"), URN: Sp("tel:+6285218761111"), External: Sp("93456SMS9755064"), Date: Tp(time.Date(2017, 5, 3, 6, 15, 59, 0, time.UTC))},
+	{Label: "Receive Missing Original", URL: receiveMissingFrom, Status: 400, Response: "field 'original' required"},
+	{Label: "Receive Invalid Date", URL: receiveInvalidDate, Status: 400, Response: "invalid date: 2017-05-03"},
+
+	{Label: "Status Delivered", URL: statusDelivered, Status: 200, Response: "000"},
+	{Label: "Status Failed", URL: statusFailed, Status: 200, Response: "000"},
+	{Label: "Status Zero", URL: statusZero, Status: 200, Response: "000"},
+	{Label: "Status Missing", URL: statusMissing, Status: 400, Response: "field 'status' required"},
+	{Label: "Status Part", URL: statusPart, Status: 200, Response: "000"},
+	{Label: "Status Invalid ID", URL: statusInvalidID, Status: 400, Response: "invalid messageid: abc"},
+	{Label: "Status Missing ID", URL: statusMissingID, Status: 400, Response: "field 'messageid' required"},
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	channel.(*courier.MockChannel).SetConfig(courier.ConfigSendURL, server.URL)
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "tel:+6285218761111",
+		Status:       "W",
+		ResponseBody: "000", ResponseStatus: 200,
+		URLParams: map[string]string{"userid": "Username", "password": "Password", "original": "2020", "sendto": "6285218761111",
+			"messageid": "10", "message": "Simple Message", "dcs": "0", "udhl": "0"},
+		SendPrep: setSendURL},
+	{Label: "Long Send",
+		Text:   "This is a longer message than 160 characters and will cause us to split it into two separate parts, isn't that right but it is even longer than before I say, I need to keep adding more things to make it work",
+		URN:    "tel:+6285218761111",
+		Status: "W", ResponseBody: "000", ResponseStatus: 200,
+		URLParams: map[string]string{"messageid": "10.2", "message": "need to keep adding more things to make it work"},
+		SendPrep:  setSendURL},
+	{Label: "Send Attachment",
+		Text: "My pic!", URN: "tel:+6285218761111", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status:       "W",
+		ResponseBody: "000", ResponseStatus: 200,
+		URLParams: map[string]string{"message": "My pic!\nhttps://foo.bar/image.jpg"},
+		SendPrep:  setSendURL},
+	{Label: "Error Code",
+		Text: "Error Message", URN: "tel:+6285218761111",
+		Status:       "E",
+		ResponseBody: "001", ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Error Sending",
+		Text: "Error Message", URN: "tel:+6285218761111",
+		Status:       "E",
+		ResponseBody: "Error", ResponseStatus: 400,
+		SendPrep: setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "DA", "2020", "ID",
+		map[string]interface{}{
+			courier.ConfigUsername: "Username",
+			courier.ConfigPassword: "Password",
+		})
+
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+}
@@ -4,3 +4,417 @@ package facebook
 POST /handlers/facebook/uuid
 {"object":"page","entry":[{"id":"12345","time":1493775157144,"messaging":[{"sender":{"id":"12345"},"recipient":{"id":"12345"},"timestamp":1493775157107,"delivery":{"watermark":1493220507044,"seq":0}}]}]}
 */
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+// the config key for the token we expect Facebook to send when verifying our webhook
+const configSecret = "secret"
+
+// the config key for the app secret used to sign incoming requests
+const configAppSecret = "app_secret"
+
+// the config key for the message tag to use when sending, see https://developers.facebook.com/docs/messenger-platform/send-messages/message-tags
+const configMessageTag = "message_tag"
+
+const fbSignatureHeader = "X-Hub-Signature"
+
+var sendURL = "https://graph.facebook.com/v2.10/me/messages"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Facebook handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("FB"), "Facebook")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddReceiveMsgAndStatusRoute(h, "POST", "receive", h.ReceiveEvents)
+	if err != nil {
+		return err
+	}
+
+	return s.AddChannelRoute(h, "GET", "receive", h.VerifyURL)
+}
+
+// VerifyURL is our HTTP handler function for Facebook's webhook verification, we echo back their challenge
+// if the verify token matches our secret
+func (h *handler) VerifyURL(channel courier.Channel, w http.ResponseWriter, r *http.Request) error {
+	// our parameters have dots in their names so we can't use our form decoder
+	mode := r.URL.Query().Get("hub.mode")
+	verifyToken := r.URL.Query().Get("hub.verify_token")
+	challenge := r.URL.Query().Get("hub.challenge")
+
+	if mode != "subscribe" {
+		return fmt.Errorf("unknown hub.mode '%s'", mode)
+	}
+
+	secret := channel.StringConfigForKey(configSecret, "")
+	if secret == "" || !hmac.Equal([]byte(secret), []byte(verifyToken)) {
+		return fmt.Errorf("token does not match secret")
+	}
+
+	if challenge == "" {
+		return fmt.Errorf("missing hub.challenge")
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(200)
+	_, err := fmt.Fprint(w, challenge)
+	return err
+}
+
+// ReceiveEvents is our HTTP handler function for incoming messages and delivery receipts, Facebook batches these
+// for many users in a single request
+func (h *handler) ReceiveEvents(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, []courier.MsgStatus, error) {
+	err := h.validateSignature(channel, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	payload := &fbEnvelope{}
+	err = handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// not a page subscription? ignore
+	if payload.Object != "page" {
+		return nil, nil, courier.WriteIgnored(w, r, fmt.Sprintf("Ignoring request, unknown object '%s'", payload.Object))
+	}
+
+	msgs := make([]courier.Msg, 0, 2)
+	statuses := make([]courier.MsgStatus, 0, 2)
+
+	for _, entry := range payload.Entry {
+		for _, msg := range entry.Messaging {
+			// delivery receipts may reference the ids of the messages we sent
+			if msg.Delivery != nil {
+				for _, mid := range msg.Delivery.MIDs {
+					status := h.Backend().NewMsgStatusForExternalID(channel, mid, courier.MsgDelivered)
+					err = h.Backend().WriteMsgStatus(status)
+
+					// we may not know about this message, that's ok
+					if err == courier.ErrMsgNotFound {
+						continue
+					}
+					if err != nil {
+						return nil, nil, err
+					}
+					statuses = append(statuses, status)
+				}
+
+				// and their watermark tells us everything sent to this user before it was delivered, often it's all we get
+				if msg.Delivery.Watermark > 0 && msg.Sender.ID != "" {
+					urn, err := courier.NewURNFromParts(courier.FacebookScheme, msg.Sender.ID, "")
+					if err != nil {
+						return nil, nil, err
+					}
+
+					watermark := time.Unix(0, msg.Delivery.Watermark*int64(time.Millisecond)).UTC()
+					delivered, err := h.Backend().WriteDeliveredWatermark(channel, urn, watermark)
+					if err != nil {
+						return nil, nil, err
+					}
+					statuses = append(statuses, delivered...)
+				}
+				continue
+			}
+
+			// build our URN, ignoring any events without a sender
+			if msg.Sender.ID == "" {
+				continue
+			}
+			urn, err := courier.NewURNFromParts(courier.FacebookScheme, msg.Sender.ID, "")
+			if err != nil {
+				return nil, nil, err
+			}
+
+			date := time.Unix(0, msg.Timestamp*int64(time.Millisecond)).UTC()
+			text := ""
+			externalID := ""
+			attachments := make([]string, 0, 1)
+
+			if msg.Message != nil {
+				// this is a message we sent being echoed back to us, ignore it
+				if msg.Message.IsEcho {
+					continue
+				}
+
+				text = msg.Message.Text
+				externalID = msg.Message.MID
+
+				for _, att := range msg.Message.Attachments {
+					if att.Type == "location" && att.Payload.Coordinates != nil {
+						text = fmt.Sprintf("%f,%f", att.Payload.Coordinates.Lat, att.Payload.Coordinates.Long)
+						attachments = append(attachments, fmt.Sprintf("geo:%f,%f", att.Payload.Coordinates.Lat, att.Payload.Coordinates.Long))
+					} else if att.Payload.URL != "" {
+						attachments = append(attachments, att.Payload.URL)
+					}
+				}
+			} else if msg.Postback != nil {
+				// postbacks carry the payload of the button which was pressed
+				text = msg.Postback.Payload
+				if text == "" {
+					text = msg.Postback.Title
+				}
+			} else if msg.Referral != nil {
+				// referrals come from m.me links and ads, the ref is what they were configured with
+				text = msg.Referral.Ref
+			} else {
+				// read receipts, optins and the like aren't something we deal with
+				continue
+			}
+
+			// nothing to create a message from? skip it
+			if text == "" && len(attachments) == 0 {
+				continue
+			}
+
+			m := h.Backend().NewIncomingMsg(channel, urn, text).WithReceivedOn(date)
+			if externalID != "" {
+				m.WithExternalID(externalID)
+			}
+			for _, attachment := range attachments {
+				m.WithAttachment(attachment)
+			}
+
+			err = h.Backend().WriteMsg(m)
+			if err != nil {
+				return nil, nil, err
+			}
+			msgs = append(msgs, m)
+		}
+	}
+
+	if len(msgs) == 0 && len(statuses) == 0 {
+		return nil, nil, courier.WriteIgnored(w, r, "Ignoring request, no message or delivery events")
+	}
+
+	return msgs, statuses, courier.WriteEventsSuccess(w, r, msgs, statuses)
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	accessToken := msg.Channel().StringConfigForKey(courier.ConfigAuthToken, "")
+	if accessToken == "" {
+		return nil, fmt.Errorf("missing access token for FB channel")
+	}
+
+	query := url.Values{}
+	query.Set("access_token", accessToken)
+	msgURL := fmt.Sprintf("%s?%s", sendURL, query.Encode())
+
+	// figure out how this message is being sent, tagged messages can be sent outside the 24 hour window
+	messagingType := "RESPONSE"
+	if msg.Priority() == courier.BulkPriority {
+		messagingType = "UPDATE"
+	}
+	tag := msg.Channel().StringConfigForKey(configMessageTag, "")
+	if tag != "" {
+		messagingType = "MESSAGE_TAG"
+	}
+
+	// the status that will be written for this message
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+
+	// build up all the parts we need to send, text first then each attachment
+	parts := make([]fbOutgoingMessage, 0, len(msg.Attachments())+1)
+	if msg.Text() != "" {
+		parts = append(parts, fbOutgoingMessage{Text: msg.Text()})
+	}
+	for _, attachment := range msg.Attachments() {
+		mediaType, mediaURL := courier.SplitAttachment(attachment)
+
+		attType := strings.Split(mediaType, "/")[0]
+		if attType != "image" && attType != "audio" && attType != "video" {
+			attType = "file"
+		}
+
+		part := fbOutgoingMessage{Attachment: &fbOutgoingAttachment{Type: attType}}
+		part.Attachment.Payload.URL = mediaURL
+		part.Attachment.Payload.IsReusable = true
+		parts = append(parts, part)
+	}
+
+	for _, part := range parts {
+		payload := &fbOutgoing{MessagingType: messagingType, Tag: tag, Message: part}
+		payload.Recipient.ID = msg.URN().Path()
+
+		body, _ := json.Marshal(payload)
+		req, err := http.NewRequest(http.MethodPost, msgURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		rr, err := utils.MakeHTTPRequest(req)
+
+		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+		status.AddLog(log)
+		if err != nil {
+			return status, nil
+		}
+
+		externalID, err := jsonparser.GetString(rr.Body, "message_id")
+		if err != nil {
+			log.WithError("Message Send Error", errors.Errorf("unable to get message_id from body"))
+			return status, nil
+		}
+
+		// the first part is the one we track our status against
+		if status.ExternalID() == "" {
+			status.SetExternalID(externalID)
+		}
+	}
+
+	status.SetStatus(courier.MsgWired)
+	return status, nil
+}
+
+// see https://developers.facebook.com/docs/messenger-platform/webhook#security
+func (h *handler) validateSignature(channel courier.Channel, r *http.Request) error {
+	actual := r.Header.Get(fbSignatureHeader)
+	if actual == "" {
+		return fmt.Errorf("missing request signature")
+	}
+
+	appSecret := channel.StringConfigForKey(configAppSecret, "")
+	if appSecret == "" {
+		return fmt.Errorf("invalid or missing app secret in config")
+	}
+
+	// read our body, we put it back afterwards so it can be decoded
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 100000))
+	r.Body.Close()
+	if err != nil {
+		return fmt.Errorf("unable to read request body: %s", err)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	expected := calculateSignature(appSecret, body)
+
+	// compare signatures in way that isn't sensitive to a timing attack
+	if !hmac.Equal([]byte(expected), []byte(actual)) {
+		return fmt.Errorf("invalid request signature")
+	}
+	return nil
+}
+
+// calculateSignature returns the signature Facebook sends for the passed in body, sha1= followed by the hex HMAC-SHA1
+func calculateSignature(appSecret string, body []byte) string {
+	mac := hmac.New(sha1.New, []byte(appSecret))
+	mac.Write(body)
+	return fmt.Sprintf("sha1=%s", hex.EncodeToString(mac.Sum(nil)))
+}
+
+type fbOutgoingAttachment struct {
+	Type    string `json:"type"`
+	Payload struct {
+		URL        string `json:"url"`
+		IsReusable bool   `json:"is_reusable"`
+	} `json:"payload"`
+}
+
+type fbOutgoingMessage struct {
+	Text       string                `json:"text,omitempty"`
+	Attachment *fbOutgoingAttachment `json:"attachment,omitempty"`
+}
+
+type fbOutgoing struct {
+	MessagingType string `json:"messaging_type"`
+	Tag           string `json:"tag,omitempty"`
+	Recipient     struct {
+		ID string `json:"id"`
+	} `json:"recipient"`
+	Message fbOutgoingMessage `json:"message"`
+}
+
+// {
+//   "object":"page",
+//   "entry":[{
+//     "id":"180005062406476",
+//     "time":1514924367082,
+//     "messaging":[{
+//       "sender":  {"id":"1630934236957797"},
+//       "recipient":{"id":"180005062406476"},
+//       "timestamp":1514924366807,
+//       "message":{
+//         "mid":"mid.$cAAD5QiNHkz1m6cyj11guxokwkhi2",
+//         "seq":33116,
+//         "text":"65863634"
+//       }
+//     }]
+//   }]
+// }
+type fbEnvelope struct {
+	Object string `json:"object"`
+	Entry  []struct {
+		ID        string `json:"id"`
+		Time      int64  `json:"time"`
+		Messaging []struct {
+			Sender struct {
+				ID string `json:"id"`
+			} `json:"sender"`
+			Recipient struct {
+				ID string `json:"id"`
+			} `json:"recipient"`
+			Timestamp int64 `json:"timestamp"`
+			Message   *struct {
+				IsEcho      bool   `json:"is_echo"`
+				MID         string `json:"mid"`
+				Text        string `json:"text"`
+				Attachments []struct {
+					Type    string `json:"type"`
+					Payload struct {
+						URL         string `json:"url"`
+						Coordinates *struct {
+							Lat  float64 `json:"lat"`
+							Long float64 `json:"long"`
+						} `json:"coordinates"`
+					} `json:"payload"`
+				} `json:"attachments"`
+			} `json:"message"`
+			Postback *struct {
+				Title   string `json:"title"`
+				Payload string `json:"payload"`
+			} `json:"postback"`
+			Referral *struct {
+				Ref    string `json:"ref"`
+				Source string `json:"source"`
+				Type   string `json:"type"`
+			} `json:"referral"`
+			Delivery *struct {
+				MIDs      []string `json:"mids"`
+				Watermark int64    `json:"watermark"`
+				Seq       int      `json:"seq"`
+			} `json:"delivery"`
+		} `json:"messaging"`
+	} `json:"entry"`
+}
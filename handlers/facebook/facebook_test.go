@@ -0,0 +1,310 @@
+package facebook
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/config"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "FB", "1234", "", map[string]interface{}{
+		courier.ConfigAuthToken: "a123",
+		configSecret:            "fb_secret",
+		configAppSecret:         "app_secret",
+	}),
+}
+
+var (
+	receiveURL = "/c/fb/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+
+	helloMsg = `{
+		"object":"page",
+		"entry": [{
+			"id": "208685479508187",
+			"messaging": [{
+				"message": {
+					"text": "Hello World",
+					"mid": "external_id"
+				},
+				"recipient": {"id": "1234"},
+				"sender": {"id": "5678"},
+				"timestamp": 1459991487970
+			}],
+			"time": 1459991487970
+		}]
+	}`
+
+	batchedMsgs = `{
+		"object":"page",
+		"entry": [{
+			"id": "208685479508187",
+			"messaging": [{
+				"message": {"text": "First", "mid": "external_id_1"},
+				"recipient": {"id": "1234"},
+				"sender": {"id": "5678"},
+				"timestamp": 1459991487970
+			},{
+				"message": {"text": "Second", "mid": "external_id_2"},
+				"recipient": {"id": "1234"},
+				"sender": {"id": "5679"},
+				"timestamp": 1459991487971
+			}],
+			"time": 1459991487970
+		}]
+	}`
+
+	attachment = `{
+		"object":"page",
+		"entry": [{
+			"id": "208685479508187",
+			"messaging": [{
+				"message": {
+					"mid": "external_id",
+					"attachments":[{
+						"type":"image",
+						"payload":{"url":"https://image-url/foo.png"}
+					}]
+				},
+				"recipient": {"id": "1234"},
+				"sender": {"id": "5678"},
+				"timestamp": 1459991487970
+			}],
+			"time": 1459991487970
+		}]
+	}`
+
+	location = `{
+		"object":"page",
+		"entry": [{
+			"id": "208685479508187",
+			"messaging": [{
+				"message": {
+					"mid": "external_id",
+					"attachments":[{
+						"type":"location",
+						"payload":{"coordinates":{"lat":1.2,"long":-1.3}}
+					}]
+				},
+				"recipient": {"id": "1234"},
+				"sender": {"id": "5678"},
+				"timestamp": 1459991487970
+			}],
+			"time": 1459991487970
+		}]
+	}`
+
+	postback = `{
+		"object":"page",
+		"entry": [{
+			"id": "208685479508187",
+			"messaging": [{
+				"postback": {"title": "Get Started", "payload": "get_started"},
+				"recipient": {"id": "1234"},
+				"sender": {"id": "5678"},
+				"timestamp": 1459991487970
+			}],
+			"time": 1459991487970
+		}]
+	}`
+
+	referral = `{
+		"object":"page",
+		"entry": [{
+			"id": "208685479508187",
+			"messaging": [{
+				"referral": {"ref": "join", "source": "SHORTLINK", "type": "OPEN_THREAD"},
+				"recipient": {"id": "1234"},
+				"sender": {"id": "5678"},
+				"timestamp": 1459991487970
+			}],
+			"time": 1459991487970
+		}]
+	}`
+
+	echo = `{
+		"object":"page",
+		"entry": [{
+			"id": "208685479508187",
+			"messaging": [{
+				"message": {"is_echo": true, "text": "Hi", "mid": "external_id"},
+				"recipient": {"id": "5678"},
+				"sender": {"id": "1234"},
+				"timestamp": 1459991487970
+			}],
+			"time": 1459991487970
+		}]
+	}`
+
+	delivery = `{
+		"object":"page",
+		"entry": [{
+			"id": "208685479508187",
+			"messaging": [{
+				"delivery": {"mids": ["mid.1458668856218:ed81099e15d3f4f233"], "watermark": 1458668856253, "seq": 37},
+				"recipient": {"id": "1234"},
+				"sender": {"id": "5678"},
+				"timestamp": 1459991487970
+			}],
+			"time": 1459991487970
+		}]
+	}`
+
+	watermark = `{
+		"object":"page",
+		"entry": [{
+			"id": "208685479508187",
+			"messaging": [{
+				"delivery": {"watermark": 1458668856253, "seq": 37},
+				"recipient": {"id": "1234"},
+				"sender": {"id": "5678"},
+				"timestamp": 1459991487970
+			}],
+			"time": 1459991487970
+		}]
+	}`
+
+	notPage = `{"object":"notpage","entry":[]}`
+)
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Message", URL: receiveURL, Data: helloMsg, Status: 200, Response: "Handled",
+		Text: Sp("Hello World"), URN: Sp("facebook:5678"), External: Sp("external_id"), Date: Tp(time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC)),
+		PrepRequest: addValidSignature},
+	{Label: "Receive Batched", URL: receiveURL, Data: batchedMsgs, Status: 200, Response: `"text":"First"`,
+		Text: Sp("Second"), URN: Sp("facebook:5679"), External: Sp("external_id_2"), PrepRequest: addValidSignature},
+	{Label: "Receive Attachment", URL: receiveURL, Data: attachment, Status: 200, Response: "Handled",
+		Text: Sp(""), Attachment: Sp("https://image-url/foo.png"), PrepRequest: addValidSignature},
+	{Label: "Receive Location", URL: receiveURL, Data: location, Status: 200, Response: "Handled",
+		Text: Sp("1.200000,-1.300000"), Attachment: Sp("geo:1.200000,-1.300000"), PrepRequest: addValidSignature},
+	{Label: "Receive Postback", URL: receiveURL, Data: postback, Status: 200, Response: "Handled",
+		Text: Sp("get_started"), URN: Sp("facebook:5678"), PrepRequest: addValidSignature},
+	{Label: "Receive Referral", URL: receiveURL, Data: referral, Status: 200, Response: "Handled",
+		Text: Sp("join"), URN: Sp("facebook:5678"), PrepRequest: addValidSignature},
+	{Label: "Receive Echo", URL: receiveURL, Data: echo, Status: 200, Response: "Ignoring request", PrepRequest: addValidSignature},
+	{Label: "Receive Delivery", URL: receiveURL, Data: delivery, Status: 200, Response: `"status":"D"`, PrepRequest: addValidSignature},
+	{Label: "Receive Watermark Nothing Sent", URL: receiveURL, Data: watermark, Status: 200, Response: "Ignoring request", PrepRequest: addValidSignature},
+	{Label: "Receive Not Page", URL: receiveURL, Data: notPage, Status: 200, Response: "unknown object", PrepRequest: addValidSignature},
+	{Label: "Receive Invalid Signature", URL: receiveURL, Data: helloMsg, Status: 400, Response: "invalid request signature",
+		PrepRequest: addInvalidSignature},
+	{Label: "Receive Missing Signature", URL: receiveURL, Data: helloMsg, Status: 400, Response: "missing request signature"},
+
+	{Label: "Verify Valid", URL: receiveURL + "?hub.mode=subscribe&hub.verify_token=fb_secret&hub.challenge=yarchallenge", Status: 200, Response: "yarchallenge"},
+	{Label: "Verify Invalid Token", URL: receiveURL + "?hub.mode=subscribe&hub.verify_token=wrong_secret&hub.challenge=yarchallenge", Status: 400, Response: "token does not match secret"},
+	{Label: "Verify Invalid Mode", URL: receiveURL + "?hub.mode=unsubscribe&hub.verify_token=fb_secret&hub.challenge=yarchallenge", Status: 400, Response: "unknown hub.mode"},
+}
+
+func addValidSignature(r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.Header.Set(fbSignatureHeader, calculateSignature("app_secret", body))
+}
+
+func addInvalidSignature(r *http.Request) {
+	r.Header.Set(fbSignatureHeader, "sha1=invalidsig")
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func TestDeliveryWatermark(t *testing.T) {
+	mb := courier.NewMockBackend()
+	mb.AddChannel(testChannels[0])
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	// we've sent one message to our user and one to someone else
+	sent := mb.NewOutgoingMsg(testChannels[0], courier.NewMsgID(10), "facebook:5678", "Hi", courier.DefaultPriority)
+	mb.MarkOutgoingMsgComplete(sent, mb.NewMsgStatusForID(testChannels[0], sent.ID(), courier.MsgWired))
+	other := mb.NewOutgoingMsg(testChannels[0], courier.NewMsgID(11), "facebook:9999", "Hi", courier.DefaultPriority)
+	mb.MarkOutgoingMsgComplete(other, mb.NewMsgStatusForID(testChannels[0], other.ID(), courier.MsgWired))
+
+	// a receipt with only a watermark marks what we sent to our user as delivered
+	r := httptest.NewRequest(http.MethodPost, receiveURL, strings.NewReader(watermark))
+	addValidSignature(r)
+	w := httptest.NewRecorder()
+
+	msgs, statuses, err := h.ReceiveEvents(testChannels[0], w, r)
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+	require.Equal(t, 1, len(statuses))
+	assert.Equal(t, courier.NewMsgID(10), statuses[0].ID())
+	assert.Equal(t, courier.MsgDelivered, statuses[0].Status())
+	assert.Contains(t, w.Body.String(), `"status":"D"`)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	sendURL = server.URL
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "facebook:12345",
+		Status: "W", ExternalID: "mid.133",
+		ResponseBody: `{"recipient_id":"12345","message_id":"mid.133"}`, ResponseStatus: 200,
+		URLParams:   map[string]string{"access_token": "a123"},
+		RequestBody: `{"messaging_type":"RESPONSE","recipient":{"id":"12345"},"message":{"text":"Simple Message"}}`,
+		SendPrep:    setSendURL},
+	{Label: "Bulk Send",
+		Text: "Simple Message", URN: "facebook:12345", Priority: courier.BulkPriority,
+		Status: "W", ExternalID: "mid.133",
+		ResponseBody: `{"recipient_id":"12345","message_id":"mid.133"}`, ResponseStatus: 200,
+		RequestBody: `{"messaging_type":"UPDATE","recipient":{"id":"12345"},"message":{"text":"Simple Message"}}`,
+		SendPrep:    setSendURL},
+	{Label: "Send Photo",
+		Text: "", URN: "facebook:12345", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status: "W", ExternalID: "mid.133",
+		ResponseBody: `{"recipient_id":"12345","message_id":"mid.133"}`, ResponseStatus: 200,
+		RequestBody: `{"messaging_type":"RESPONSE","recipient":{"id":"12345"},"message":{"attachment":{"type":"image","payload":{"url":"https://foo.bar/image.jpg","is_reusable":true}}}}`,
+		SendPrep:    setSendURL},
+	{Label: "Send Document",
+		Text: "", URN: "facebook:12345", Attachments: []string{"application/pdf:https://foo.bar/doc.pdf"},
+		Status: "W", ExternalID: "mid.133",
+		ResponseBody: `{"recipient_id":"12345","message_id":"mid.133"}`, ResponseStatus: 200,
+		RequestBody: `{"messaging_type":"RESPONSE","recipient":{"id":"12345"},"message":{"attachment":{"type":"file","payload":{"url":"https://foo.bar/doc.pdf","is_reusable":true}}}}`,
+		SendPrep:    setSendURL},
+	{Label: "ID Error",
+		Text: "ID Error", URN: "facebook:12345",
+		Status:       "E",
+		ResponseBody: `{ "is_error": true }`, ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Error",
+		Text: "Error", URN: "facebook:12345",
+		Status:       "E",
+		ResponseBody: `{"error":{"message":"The parameter recipient is required","code":100}}`, ResponseStatus: 403,
+		SendPrep: setSendURL},
+}
+
+var taggedSendTestCases = []ChannelSendTestCase{
+	{Label: "Tagged Send",
+		Text: "Simple Message", URN: "facebook:12345",
+		Status: "W", ExternalID: "mid.133",
+		ResponseBody: `{"recipient_id":"12345","message_id":"mid.133"}`, ResponseStatus: 200,
+		RequestBody: `{"messaging_type":"MESSAGE_TAG","tag":"ACCOUNT_UPDATE","recipient":{"id":"12345"},"message":{"text":"Simple Message"}}`,
+		SendPrep:    setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "FB", "1234", "",
+		map[string]interface{}{courier.ConfigAuthToken: "a123"})
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+
+	var taggedChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "FB", "1234", "",
+		map[string]interface{}{courier.ConfigAuthToken: "a123", configMessageTag: "ACCOUNT_UPDATE"})
+	RunChannelSendTestCases(t, taggedChannel, NewHandler(), taggedSendTestCases)
+}
@@ -1,3 +1,213 @@
 package firebase
 
-/* no logs */
+/*
+POST /c/fcm/uuid/register/
+from=cTvNVV1RmLc:APA91bHPPxT&name=Bob
+
+POST /c/fcm/uuid/receive/
+from=cTvNVV1RmLc:APA91bHPPxT&msg=Hello+World&date=2017-06-05T12:30:01.000Z
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	configKey          = "FCM_KEY"
+	configTitle        = "FCM_TITLE"
+	configNotification = "FCM_NOTIFICATION"
+)
+
+var sendURL = "https://fcm.googleapis.com/fcm/send"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Firebase Cloud Messaging handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("FCM"), "Firebase Cloud Messaging")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddReceiveMsgRoute(h, "POST", "receive", h.ReceiveMessage)
+	if err != nil {
+		return err
+	}
+
+	return s.AddChannelRoute(h, "POST", "register", h.RegisterContact)
+}
+
+type fcmRegister struct {
+	From string `validate:"required" name:"from"`
+	Name string `name:"name"`
+}
+
+type fcmRegisterResponse struct {
+	ContactUUID courier.ContactUUID `json:"contact_uuid"`
+	URN         courier.URN         `json:"urn"`
+}
+
+// RegisterContact is our HTTP handler function for apps registering their FCM token, we create or look up the
+// contact for that token and return the URN they will be known by
+func (h *handler) RegisterContact(channel courier.Channel, w http.ResponseWriter, r *http.Request) error {
+	fcmRegister := &fcmRegister{}
+	err := handlers.DecodeAndValidateForm(fcmRegister, r)
+	if err != nil {
+		return err
+	}
+
+	urn, err := courier.NewURNFromParts(courier.FCMScheme, fcmRegister.From, "")
+	if err != nil {
+		return err
+	}
+
+	contact, err := h.Backend().GetContact(channel, urn, "", fcmRegister.Name)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(&fcmRegisterResponse{contact.UUID(), urn})
+}
+
+type fcmMessage struct {
+	From string `validate:"required" name:"from"`
+	Msg  string `validate:"required" name:"msg"`
+	Name string `name:"name"`
+	Date string `name:"date"`
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	// get our params
+	fcmMsg := &fcmMessage{}
+	err := handlers.DecodeAndValidateForm(fcmMsg, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// if we have a date, parse it
+	date := time.Now()
+	if fcmMsg.Date != "" {
+		date, err = time.Parse(time.RFC3339Nano, fcmMsg.Date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date format, must be RFC 3339")
+		}
+	}
+
+	// create our URN
+	urn, err := courier.NewURNFromParts(courier.FCMScheme, fcmMsg.From, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// build our msg
+	msg := h.Backend().NewIncomingMsg(channel, urn, fcmMsg.Msg).WithReceivedOn(date.UTC()).WithContactName(fcmMsg.Name)
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, courier.WriteReceiveSuccess(w, r, msg)
+}
+
+type fcmData struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Message   string `json:"message"`
+	MessageID int64  `json:"message_id"`
+}
+
+type fcmNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+type fcmPayload struct {
+	To               string           `json:"to"`
+	Priority         string           `json:"priority"`
+	Data             fcmData          `json:"data"`
+	Notification     *fcmNotification `json:"notification,omitempty"`
+	ContentAvailable bool             `json:"content_available"`
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	fcmKey := msg.Channel().StringConfigForKey(configKey, "")
+	if fcmKey == "" {
+		return nil, fmt.Errorf("no FCM_KEY set for FCM channel")
+	}
+
+	title := msg.Channel().StringConfigForKey(configTitle, "")
+	text := courier.GetTextAndAttachments(msg)
+
+	payload := &fcmPayload{
+		To:       msg.URN().Path(),
+		Priority: "high",
+		Data: fcmData{
+			Type:      "rapidpro",
+			Title:     title,
+			Message:   text,
+			MessageID: msg.ID().Int64,
+		},
+	}
+
+	// if this channel wants notifications, include one so the message is displayed even when the app isn't running,
+	// otherwise we send a data only message which the app is responsible for displaying
+	notification, _ := msg.Channel().ConfigForKey(configNotification, false).(bool)
+	if notification {
+		payload.Notification = &fcmNotification{Title: title, Body: text}
+		payload.ContentAvailable = true
+	}
+
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("key=%s", fcmKey))
+	rr, err := utils.MakeHTTPRequest(req)
+
+	// record our status and log
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+	status.AddLog(log)
+	if err != nil {
+		return status, nil
+	}
+
+	// FCM returns a 200 even when the message couldn't be delivered, check our success count
+	success, err := jsonparser.GetInt(rr.Body, "success")
+	if err != nil || success != 1 {
+		fcmError, _ := jsonparser.GetString(rr.Body, "results", "[0]", "error")
+		log.WithError("Message Send Error", errors.Errorf("received non-success response: '%s'", fcmError))
+		return status, nil
+	}
+
+	externalID, err := jsonparser.GetString(rr.Body, "results", "[0]", "message_id")
+	if err == nil {
+		status.SetExternalID(externalID)
+	}
+
+	status.SetStatus(courier.MsgWired)
+	return status, nil
+}
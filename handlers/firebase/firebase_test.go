@@ -0,0 +1,144 @@
+package firebase
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/config"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "FCM", "1234", "", map[string]interface{}{configKey: "FCMKey"}),
+}
+
+var (
+	receiveURL  = "/c/fcm/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+	registerURL = "/c/fcm/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/register/"
+
+	receiveValid       = "from=cTvNVV1RmLc:APA91bHPPxT&msg=Hello+World&name=Bob&date=2017-06-05T12:30:01.000Z"
+	receiveNoDate      = "from=cTvNVV1RmLc:APA91bHPPxT&msg=Hello+World"
+	receiveInvalidDate = "from=cTvNVV1RmLc:APA91bHPPxT&msg=Hello+World&date=20170605"
+	receiveMissingFrom = "msg=Hello+World"
+	receiveMissingMsg  = "from=cTvNVV1RmLc:APA91bHPPxT"
+
+	registerValid       = "from=cTvNVV1RmLc:APA91bHPPxT&name=Bob"
+	registerMissingFrom = "name=Bob"
+)
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid", URL: receiveURL, Data: receiveValid, Status: 200, Response: "Message Accepted",
+		Text: Sp("Hello World"), URN: Sp("fcm:cTvNVV1RmLc:APA91bHPPxT"), Date: Tp(time.Date(2017, 6, 5, 12, 30, 1, 0, time.UTC))},
+	{Label: "Receive No Date", URL: receiveURL, Data: receiveNoDate, Status: 200, Response: "Message Accepted",
+		Text: Sp("Hello World"), URN: Sp("fcm:cTvNVV1RmLc:APA91bHPPxT")},
+	{Label: "Receive Invalid Date", URL: receiveURL, Data: receiveInvalidDate, Status: 400, Response: "invalid date format"},
+	{Label: "Receive Missing From", URL: receiveURL, Data: receiveMissingFrom, Status: 400, Response: "field 'from' required"},
+	{Label: "Receive Missing Msg", URL: receiveURL, Data: receiveMissingMsg, Status: 400, Response: "field 'msg' required"},
+
+	{Label: "Register Valid", URL: registerURL, Data: registerValid, Status: 200, Response: `"urn":"fcm:cTvNVV1RmLc:APA91bHPPxT"`},
+	{Label: "Register Missing From", URL: registerURL, Data: registerMissingFrom, Status: 400, Response: "field 'from' required"},
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func TestRegisterContact(t *testing.T) {
+	mb := courier.NewMockBackend()
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	register := func() *fcmRegisterResponse {
+		r := httptest.NewRequest(http.MethodPost, registerURL, strings.NewReader(registerValid))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+
+		err := h.RegisterContact(testChannels[0], w, r)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		response := &fcmRegisterResponse{}
+		err = json.Unmarshal(w.Body.Bytes(), response)
+		require.NoError(t, err)
+		return response
+	}
+
+	// registering creates our contact
+	response := register()
+	assert.Equal(t, courier.URN("fcm:cTvNVV1RmLc:APA91bHPPxT"), response.URN)
+
+	contact, err := mb.GetContact(testChannels[0], response.URN, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, contact.UUID(), response.ContactUUID)
+
+	// registering again returns the same contact
+	assert.Equal(t, contact.UUID(), register().ContactUUID)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	sendURL = server.URL
+}
+
+var dataSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "fcm:cTvNVV1RmLc:APA91bHPPxT",
+		Status: "W", ExternalID: "0:1497024040",
+		ResponseBody: `{"multicast_id":123,"success":1,"failure":0,"results":[{"message_id":"0:1497024040"}]}`, ResponseStatus: 200,
+		Headers:     map[string]string{"Authorization": "key=FCMKey", "Content-Type": "application/json"},
+		RequestBody: `{"to":"cTvNVV1RmLc:APA91bHPPxT","priority":"high","data":{"type":"rapidpro","title":"FCMTitle","message":"Simple Message","message_id":10},"content_available":false}`,
+		SendPrep:    setSendURL},
+	{Label: "Send Attachment",
+		Text: "My pic!", URN: "fcm:cTvNVV1RmLc:APA91bHPPxT", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status: "W", ExternalID: "0:1497024040",
+		ResponseBody: `{"multicast_id":123,"success":1,"failure":0,"results":[{"message_id":"0:1497024040"}]}`, ResponseStatus: 200,
+		RequestBody: `{"to":"cTvNVV1RmLc:APA91bHPPxT","priority":"high","data":{"type":"rapidpro","title":"FCMTitle","message":"My pic!\nhttps://foo.bar/image.jpg","message_id":10},"content_available":false}`,
+		SendPrep:    setSendURL},
+	{Label: "Not Registered",
+		Text: "Error", URN: "fcm:cTvNVV1RmLc:APA91bHPPxT",
+		Status:       "E",
+		ResponseBody: `{"multicast_id":123,"success":0,"failure":1,"results":[{"error":"NotRegistered"}]}`, ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Error Sending",
+		Text: "Error", URN: "fcm:cTvNVV1RmLc:APA91bHPPxT",
+		Status:       "E",
+		ResponseBody: `Unauthorized`, ResponseStatus: 401,
+		SendPrep: setSendURL},
+}
+
+var notificationSendTestCases = []ChannelSendTestCase{
+	{Label: "Notification Send",
+		Text: "Simple Message", URN: "fcm:cTvNVV1RmLc:APA91bHPPxT",
+		Status: "W", ExternalID: "0:1497024040",
+		ResponseBody: `{"multicast_id":123,"success":1,"failure":0,"results":[{"message_id":"0:1497024040"}]}`, ResponseStatus: 200,
+		RequestBody: `{"to":"cTvNVV1RmLc:APA91bHPPxT","priority":"high","data":{"type":"rapidpro","title":"FCMTitle","message":"Simple Message","message_id":10},"notification":{"title":"FCMTitle","body":"Simple Message"},"content_available":true}`,
+		SendPrep:    setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var dataChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "FCM", "1234", "",
+		map[string]interface{}{
+			configKey:   "FCMKey",
+			configTitle: "FCMTitle",
+		})
+	RunChannelSendTestCases(t, dataChannel, NewHandler(), dataSendTestCases)
+
+	var notificationChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "FCM", "1234", "",
+		map[string]interface{}{
+			configKey:          "FCMKey",
+			configTitle:        "FCMTitle",
+			configNotification: true,
+		})
+	RunChannelSendTestCases(t, notificationChannel, NewHandler(), notificationSendTestCases)
+}
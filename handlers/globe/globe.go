@@ -1,3 +1,270 @@
 package globe
 
-/* no logs */
+/*
+POST /c/gl/uuid/receive/
+{"inboundSMSMessageList":{"inboundSMSMessage":[{"dateTime":"Fri Nov 22 2013 12:12:13 GMT+0000 (UTC)","destinationAddress":"tel:21581234","messageId":"5a1b2c3d","message":"Hello","resourceURL":null,"senderAddress":"tel:9171234567"}],"numberOfMessagesInThisBatch":1,"resourceURL":null,"totalNumberOfPendingMessages":null}}
+
+GET /c/gl/uuid/subscribe/?access_token=1ixLbltjWkzwqLMXT-8UF-UQeKRma0hOOWFA6o91oXw&subscriber_number=9171234567
+
+POST /c/gl/uuid/subscribe/
+{"unsubscribed":{"subscriber_number":"9171234567","access_token":"1ixLbltjWkzwqLMXT-8UF-UQeKRma0hOOWFA6o91oXw","time_stamp":"2014-10-19T12:00:00"}}
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+)
+
+const (
+	configAppID      = "app_id"
+	configAppSecret  = "app_secret"
+	configPassphrase = "passphrase"
+)
+
+// Globe only accepts messages up to this length
+const maxMsgLength = 160
+
+var sendURL = "https://devapi.globelabs.com.ph/smsmessaging/v1/outbound/%s/requests"
+
+// Globe includes a trailing time zone name in parentheses which varies and we don't need to parse
+var timezoneNameRegex = regexp.MustCompile(`\s*\(.*\)$`)
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Globe Labs handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("GL"), "Globe Labs")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddReceiveMsgRoute(h, "POST", "receive", h.ReceiveMessage)
+	if err != nil {
+		return err
+	}
+
+	// Globe sends subscriptions and unsubscriptions to the same redirect URI, subscriptions as a GET and
+	// unsubscriptions as a POST
+	err = s.AddChannelRoute(h, "GET", "subscribe", h.Subscribe)
+	if err != nil {
+		return err
+	}
+
+	return s.AddChannelRoute(h, "POST", "subscribe", h.Unsubscribe)
+}
+
+// {
+//   "inboundSMSMessageList":{
+//     "inboundSMSMessage":[{
+//       "dateTime":"Fri Nov 22 2013 12:12:13 GMT+0000 (UTC)",
+//       "destinationAddress":"tel:21581234",
+//       "messageId":"5a1b2c3d",
+//       "message":"Hello",
+//       "resourceURL":null,
+//       "senderAddress":"tel:9171234567"
+//     }],
+//     "numberOfMessagesInThisBatch":1,
+//     "resourceURL":null,
+//     "totalNumberOfPendingMessages":null
+//   }
+// }
+type glEnvelope struct {
+	InboundSMSMessageList struct {
+		InboundSMSMessage []struct {
+			DateTime           string `json:"dateTime"`
+			DestinationAddress string `json:"destinationAddress"`
+			MessageID          string `json:"messageId"`
+			Message            string `json:"message"`
+			SenderAddress      string `json:"senderAddress"  validate:"required"`
+		} `json:"inboundSMSMessage"  validate:"required,dive"`
+	} `json:"inboundSMSMessageList"`
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages, Globe may batch more than one
+// message in a single request
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	payload := &glEnvelope{}
+	err := handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]courier.Msg, 0, len(payload.InboundSMSMessageList.InboundSMSMessage))
+	for _, glMsg := range payload.InboundSMSMessageList.InboundSMSMessage {
+		date, err := parseDateTime(glMsg.DateTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date format: %s", glMsg.DateTime)
+		}
+
+		// create our URN
+		urn := courier.NewTelURNForChannel(strings.TrimPrefix(glMsg.SenderAddress, "tel:"), channel)
+
+		// build our msg
+		msg := h.Backend().NewIncomingMsg(channel, urn, glMsg.Message).WithReceivedOn(date)
+		if glMsg.MessageID != "" {
+			msg.WithExternalID(glMsg.MessageID)
+		}
+
+		err = h.Backend().WriteMsg(msg)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, courier.WriteEventsSuccess(w, r, msgs, nil)
+}
+
+type glSubscribe struct {
+	AccessToken      string `validate:"required" name:"access_token"`
+	SubscriberNumber string `validate:"required" name:"subscriber_number"`
+}
+
+type glSubscribeResponse struct {
+	ContactUUID courier.ContactUUID `json:"contact_uuid"`
+	URN         courier.URN         `json:"urn"`
+}
+
+// Subscribe is our HTTP handler function for subscribers opting in to receive messages from us, we save the access
+// token Globe gives us for them on their URN
+func (h *handler) Subscribe(channel courier.Channel, w http.ResponseWriter, r *http.Request) error {
+	glSubscribe := &glSubscribe{}
+	err := handlers.DecodeAndValidateQueryParams(glSubscribe, r)
+	if err != nil {
+		return err
+	}
+
+	urn := courier.NewTelURNForChannel(glSubscribe.SubscriberNumber, channel)
+	contact, err := h.Backend().GetContact(channel, urn, glSubscribe.AccessToken, "")
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(&glSubscribeResponse{contact.UUID(), urn})
+}
+
+type glUnsubscribe struct {
+	Unsubscribed struct {
+		SubscriberNumber string `json:"subscriber_number"  validate:"required"`
+		AccessToken      string `json:"access_token"`
+		TimeStamp        string `json:"time_stamp"`
+	} `json:"unsubscribed"`
+}
+
+type glUnsubscribeResponse struct {
+	URN     courier.URN `json:"urn"`
+	Stopped bool        `json:"stopped"`
+}
+
+// Unsubscribe is our HTTP handler function for subscribers opting out, we stop their contact
+func (h *handler) Unsubscribe(channel courier.Channel, w http.ResponseWriter, r *http.Request) error {
+	payload := &glUnsubscribe{}
+	err := handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return err
+	}
+
+	urn := courier.NewTelURNForChannel(payload.Unsubscribed.SubscriberNumber, channel)
+	err = h.Backend().StopContact(channel, urn)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(&glUnsubscribeResponse{urn, true})
+}
+
+// parseDateTime parses the JavaScript style dates Globe sends us such as "Fri Nov 22 2013 12:12:13 GMT+0000 (UTC)"
+func parseDateTime(dateTime string) (time.Time, error) {
+	if dateTime == "" {
+		return time.Now().UTC(), nil
+	}
+
+	date, err := time.Parse("Mon Jan 02 2006 15:04:05 GMT-0700", timezoneNameRegex.ReplaceAllString(dateTime, ""))
+	if err != nil {
+		return date, err
+	}
+	return date.UTC(), nil
+}
+
+type glOutgoing struct {
+	Address    string `json:"address"`
+	Message    string `json:"message"`
+	Passphrase string `json:"passphrase"`
+	AppID      string `json:"app_id"`
+	AppSecret  string `json:"app_secret"`
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	appID := msg.Channel().StringConfigForKey(configAppID, "")
+	if appID == "" {
+		return nil, fmt.Errorf("no app_id set for GL channel")
+	}
+
+	appSecret := msg.Channel().StringConfigForKey(configAppSecret, "")
+	if appSecret == "" {
+		return nil, fmt.Errorf("no app_secret set for GL channel")
+	}
+
+	passphrase := msg.Channel().StringConfigForKey(configPassphrase, "")
+	if passphrase == "" {
+		return nil, fmt.Errorf("no passphrase set for GL channel")
+	}
+
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	for _, part := range handlers.SplitMsg(courier.GetTextAndAttachments(msg), maxMsgLength) {
+		payload := &glOutgoing{
+			Address:    strings.TrimPrefix(msg.URN().Path(), "+"),
+			Message:    part,
+			Passphrase: passphrase,
+			AppID:      appID,
+			AppSecret:  appSecret,
+		}
+		body, _ := json.Marshal(payload)
+
+		// subscribers who opted in gave us an access token which Globe requires for sending to them
+		msgURL := fmt.Sprintf(sendURL, msg.Channel().Address())
+		if msg.URNAuth() != "" {
+			msgURL = fmt.Sprintf("%s?access_token=%s", msgURL, url.QueryEscape(msg.URNAuth()))
+		}
+
+		req, err := http.NewRequest(http.MethodPost, msgURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		rr, err := utils.MakeHTTPRequest(req)
+
+		// record our log
+		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+		status.AddLog(log)
+		if err != nil {
+			return status, nil
+		}
+	}
+
+	status.SetStatus(courier.MsgWired)
+	return status, nil
+}
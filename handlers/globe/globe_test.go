@@ -0,0 +1,177 @@
+package globe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/config"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "GL", "21581234", "PH", nil),
+}
+
+var (
+	receiveURL   = "/c/gl/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+	subscribeURL = "/c/gl/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/subscribe/"
+)
+
+var validMsg = `{
+	"inboundSMSMessageList":{
+		"inboundSMSMessage":[{
+			"dateTime":"Fri Nov 22 2013 12:12:13 GMT+0000 (UTC)",
+			"destinationAddress":"tel:21581234",
+			"messageId":"5a1b2c3d",
+			"message":"Hello",
+			"resourceURL":null,
+			"senderAddress":"tel:9171234567"
+		}],
+		"numberOfMessagesInThisBatch":1,
+		"resourceURL":null,
+		"totalNumberOfPendingMessages":null
+	}
+}`
+
+var multipleMsgs = `{
+	"inboundSMSMessageList":{
+		"inboundSMSMessage":[{
+			"dateTime":"Fri Nov 22 2013 12:12:13 GMT+0000 (UTC)",
+			"destinationAddress":"tel:21581234",
+			"messageId":"5a1b2c3d",
+			"message":"First",
+			"senderAddress":"tel:9171234567"
+		}, {
+			"dateTime":"Fri Nov 22 2013 20:12:14 GMT+0800 (PHT)",
+			"destinationAddress":"tel:21581234",
+			"messageId":"5a1b2c3e",
+			"message":"Second",
+			"senderAddress":"tel:9171234568"
+		}],
+		"numberOfMessagesInThisBatch":2
+	}
+}`
+
+var invalidDate = `{
+	"inboundSMSMessageList":{
+		"inboundSMSMessage":[{
+			"dateTime":"2013-11-22",
+			"messageId":"5a1b2c3d",
+			"message":"Hello",
+			"senderAddress":"tel:9171234567"
+		}]
+	}
+}`
+
+var missingSender = `{
+	"inboundSMSMessageList":{
+		"inboundSMSMessage":[{
+			"dateTime":"Fri Nov 22 2013 12:12:13 GMT+0000 (UTC)",
+			"messageId":"5a1b2c3d",
+			"message":"Hello"
+		}]
+	}
+}`
+
+var unsubscribe = `{"unsubscribed":{"subscriber_number":"9171234567","access_token":"1ixLbltjWkzwqLMXT","time_stamp":"2014-10-19T12:00:00"}}`
+
+var unsubscribeMissingNumber = `{"unsubscribed":{"access_token":"1ixLbltjWkzwqLMXT","time_stamp":"2014-10-19T12:00:00"}}`
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid", URL: receiveURL, Data: validMsg, Status: 200, Response: "Events Handled",
+		Text: Sp("Hello"), URN: Sp("tel:+639171234567"), External: Sp("5a1b2c3d"), Date: Tp(time.Date(2013, 11, 22, 12, 12, 13, 0, time.UTC))},
+	{Label: "Receive Multiple", URL: receiveURL, Data: multipleMsgs, Status: 200, Response: `"text":"First"`,
+		Text: Sp("Second"), URN: Sp("tel:+639171234568"), External: Sp("5a1b2c3e"), Date: Tp(time.Date(2013, 11, 22, 12, 12, 14, 0, time.UTC))},
+	{Label: "Receive Invalid Date", URL: receiveURL, Data: invalidDate, Status: 400, Response: "invalid date format"},
+	{Label: "Receive Missing Sender", URL: receiveURL, Data: missingSender, Status: 400, Response: "Field validation for 'SenderAddress' failed"},
+	{Label: "Receive Invalid JSON", URL: receiveURL, Data: "not json", Status: 400, Response: "unable to parse request JSON"},
+
+	{Label: "Subscribe Valid", URL: subscribeURL + "?access_token=1ixLbltjWkzwqLMXT&subscriber_number=9171234567", Status: 200,
+		Response: `"urn":"tel:+639171234567"`},
+	{Label: "Subscribe Missing Token", URL: subscribeURL + "?subscriber_number=9171234567", Status: 400, Response: "field 'accesstoken' required"},
+	{Label: "Unsubscribe Valid", URL: subscribeURL, Data: unsubscribe, Status: 200, Response: `"stopped":true`},
+	{Label: "Unsubscribe Missing Number", URL: subscribeURL, Data: unsubscribeMissingNumber, Status: 400, Response: "Field validation for 'SubscriberNumber' failed"},
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func TestSubscriptions(t *testing.T) {
+	mb := courier.NewMockBackend()
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	// subscribing saves the access token for our subscriber
+	r := httptest.NewRequest(http.MethodGet, subscribeURL+"?access_token=1ixLbltjWkzwqLMXT&subscriber_number=9171234567", nil)
+	err := h.Subscribe(testChannels[0], httptest.NewRecorder(), r)
+	require.NoError(t, err)
+	assert.Equal(t, "1ixLbltjWkzwqLMXT", mb.GetContactURNAuth(courier.URN("tel:+639171234567")))
+
+	// unsubscribing stops them
+	r = httptest.NewRequest(http.MethodPost, subscribeURL, strings.NewReader(unsubscribe))
+	err = h.Unsubscribe(testChannels[0], httptest.NewRecorder(), r)
+	require.NoError(t, err)
+	assert.Equal(t, courier.URN("tel:+639171234567"), mb.GetLastStoppedContact())
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	sendURL = server.URL + "/%s"
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "tel:+639171234567",
+		Status:       "W",
+		ResponseBody: `{"outboundSMSMessageRequest":{"address":"tel:+639171234567"}}`, ResponseStatus: 201,
+		Headers:     map[string]string{"Content-Type": "application/json"},
+		RequestBody: `{"address":"639171234567","message":"Simple Message","passphrase":"Passphrase","app_id":"AppID","app_secret":"AppSecret"}`,
+		SendPrep:    setSendURL},
+	{Label: "Subscriber Send",
+		Text: "Simple Message", URN: "tel:+639171234567", URNAuth: "1ixLbltjWkzwqLMXT",
+		Status:       "W",
+		ResponseBody: `{}`, ResponseStatus: 201,
+		URLParams:   map[string]string{"access_token": "1ixLbltjWkzwqLMXT"},
+		RequestBody: `{"address":"639171234567","message":"Simple Message","passphrase":"Passphrase","app_id":"AppID","app_secret":"AppSecret"}`,
+		SendPrep:    setSendURL},
+	{Label: "Send Attachment",
+		Text: "My pic!", URN: "tel:+639171234567", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status:       "W",
+		ResponseBody: `{}`, ResponseStatus: 201,
+		RequestBody: `{"address":"639171234567","message":"My pic!\nhttps://foo.bar/image.jpg","passphrase":"Passphrase","app_id":"AppID","app_secret":"AppSecret"}`,
+		SendPrep:    setSendURL},
+	{Label: "Long Send",
+		Text:         "This is a longer message than 160 characters and will cause us to split it into two separate parts, isn't that right but it is even longer than before I say, I need to see this cut",
+		URN:          "tel:+639171234567",
+		Status:       "W",
+		ResponseBody: `{}`, ResponseStatus: 201,
+		RequestBody: `{"address":"639171234567","message":"need to see this cut","passphrase":"Passphrase","app_id":"AppID","app_secret":"AppSecret"}`,
+		SendPrep:    setSendURL},
+	{Label: "Error Sending",
+		Text: "Error Message", URN: "tel:+639171234567",
+		Status:       "E",
+		ResponseBody: `{"error":"Invalid passphrase"}`, ResponseStatus: 401,
+		SendPrep: setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "GL", "21581234", "PH",
+		map[string]interface{}{
+			configAppID:      "AppID",
+			configAppSecret:  "AppSecret",
+			configPassphrase: "Passphrase",
+		})
+
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+}
@@ -6,3 +6,223 @@ GET /handlers/hcnx/status/uuid?push_id=1164711372&status=6&to=%2B33611441111&ret
 POST /handlers/hcnx/receive/uuid?FROM=+33644961111
 ID=1164708294&FROM=%2B33644961111&TO=36105&MESSAGE=Msg&VALIDITY_DATE=2017-05-03T21%3A13%3A13&GET_STATUS=0&CLIENT=LEANCONTACTFAST&CLASS_TYPE=0&RECEPTION_DATE=2017-05-02T21%3A13%3A13&TO_OP_ID=20810&INITIAL_OP_ID=20810&STATUS=POSTING_30179_1410&EMAIL=&BINARY=0&PARAM=%7C%7C%7C%7CP223%2F03%2F03&USER_DATA=LEANCONTACTFAST&USER_DATA_2=jours+pas+r%E9gl%E9&BULK_ID=0&MO_ID=0&APPLICATION_ID=0&ACCOUNT_ID=39&GW_MESSAGE_ID=0&READ_STATUS=0&TARIFF=0&REQUEST_ID=33609002123&TAC=%28null%29&REASON=2017-05-02+23%3A13%3A13&FORMAT=&MVNO=&ORIG_ID=1164708215&ORIG_MESSAGE=Msg&RET_ID=123456&ORIG_DATE=2017-05-02T21%3A11%3A44
 */
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+)
+
+var sendURL = "https://highpushfastapi-v2.hcnx.eu/api"
+
+var pushIDRegex = regexp.MustCompile(`^[0-9]+$`)
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new HighConnection handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("HX"), "High Connection")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddReceiveMsgRoute(h, "POST", "receive", h.ReceiveMessage)
+	if err != nil {
+		return err
+	}
+
+	return s.AddUpdateStatusRoute(h, "GET", "status", h.StatusMessage)
+}
+
+type hxMessage struct {
+	ID            string `validate:"required" name:"ID"`
+	From          string `validate:"required" name:"FROM"`
+	To            string `name:"TO"`
+	Message       string `name:"MESSAGE"`
+	ReceptionDate string `name:"RECEPTION_DATE"`
+}
+
+type hxStatus struct {
+	RetID  courier.MsgID `name:"ret_id"`
+	PushID string        `name:"push_id"`
+	Status int           `validate:"required" name:"status"`
+}
+
+var hxStatusMapping = map[int]courier.MsgStatusValue{
+	2:  courier.MsgFailed,
+	4:  courier.MsgSent,
+	6:  courier.MsgDelivered,
+	11: courier.MsgFailed,
+	12: courier.MsgFailed,
+	13: courier.MsgFailed,
+	14: courier.MsgFailed,
+	15: courier.MsgFailed,
+	16: courier.MsgFailed,
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	// get our params
+	hxMsg := &hxMessage{}
+	err := handlers.DecodeAndValidateForm(hxMsg, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// HighConnection also puts an unescaped FROM in the query string, which loses its +, prefer the one in the body
+	from := hxMsg.From
+	if r.PostForm.Get("FROM") != "" {
+		from = r.PostForm.Get("FROM")
+	}
+
+	// reception dates are in local French time
+	date := time.Now().UTC()
+	if hxMsg.ReceptionDate != "" {
+		loc, err := time.LoadLocation("Europe/Paris")
+		if err != nil {
+			return nil, err
+		}
+
+		date, err = time.ParseInLocation("2006-01-02T15:04:05", hxMsg.ReceptionDate, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RECEPTION_DATE: %s", hxMsg.ReceptionDate)
+		}
+		date = date.UTC()
+	}
+
+	// create our URN
+	urn := courier.NewTelURNForChannel(from, channel)
+
+	// HighConnection posts ISO-8859-1 encoded text unless told otherwise, convert it to UTF-8
+	text := hxMsg.Message
+	if isLatin1Charset(r.Header.Get("Content-Type")) {
+		text = utils.DecodeLatin1([]byte(hxMsg.Message))
+	}
+
+	// build our msg
+	msg := h.Backend().NewIncomingMsg(channel, urn, text).WithExternalID(hxMsg.ID).WithReceivedOn(date)
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, courier.WriteReceiveSuccess(w, r, msg)
+}
+
+// isLatin1Charset returns whether the passed in content type declares an ISO-8859-1 charset or doesn't declare one at all
+func isLatin1Charset(contentType string) bool {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+
+	switch strings.ToLower(params["charset"]) {
+	case "", "iso-8859-1", "latin1":
+		return true
+	default:
+		return false
+	}
+}
+
+// StatusMessage is our HTTP handler function for status updates
+func (h *handler) StatusMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.MsgStatus, error) {
+	// get our params
+	hxStatus := &hxStatus{}
+	err := handlers.DecodeAndValidateQueryParams(hxStatus, r)
+	if err != nil {
+		return nil, err
+	}
+
+	msgStatus, found := hxStatusMapping[hxStatus.Status]
+	if !found {
+		return nil, fmt.Errorf("unknown status '%d', must be one of 2, 4, 6, 11, 12, 13, 14, 15 or 16", hxStatus.Status)
+	}
+
+	// ret_id is our msg id which we pass when sending, fall back to their push id if it's missing
+	var status courier.MsgStatus
+	if hxStatus.RetID.Valid {
+		status = h.Backend().NewMsgStatusForID(channel, hxStatus.RetID, msgStatus)
+	} else if hxStatus.PushID != "" {
+		status = h.Backend().NewMsgStatusForExternalID(channel, hxStatus.PushID, msgStatus)
+	} else {
+		return nil, fmt.Errorf("missing ret_id or push_id")
+	}
+
+	// write our status
+	err = h.Backend().WriteMsgStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.MsgStatus{status}, courier.WriteStatusSuccess(w, r, status)
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
+	if username == "" {
+		return nil, fmt.Errorf("no username set for HX channel")
+	}
+
+	password := msg.Channel().StringConfigForKey(courier.ConfigPassword, "")
+	if password == "" {
+		return nil, fmt.Errorf("no password set for HX channel")
+	}
+
+	statusURL := fmt.Sprintf("%s/c/hx/%s/status", h.Server().Config().BaseURL, msg.Channel().UUID())
+	receiveURL := fmt.Sprintf("%s/c/hx/%s/receive", h.Server().Config().BaseURL, msg.Channel().UUID())
+
+	// build our request, ret_id is passed back to us in status reports
+	form := url.Values{
+		"accountid":  []string{username},
+		"password":   []string{password},
+		"text":       []string{courier.GetTextAndAttachments(msg)},
+		"to":         []string{msg.URN().Path()},
+		"ret_id":     []string{msg.ID().String()},
+		"datacoding": []string{"8"},
+		"ret_url":    []string{statusURL},
+		"ret_mo_url": []string{receiveURL},
+	}
+
+	msgURL, _ := url.Parse(sendURL)
+	msgURL.RawQuery = form.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, msgURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	rr, err := utils.MakeHTTPRequest(req)
+
+	// record our status and log
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	status.AddLog(courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err))
+	if err != nil {
+		return status, nil
+	}
+
+	// we are replied to with the push id of our message, which status reports without a ret_id refer to
+	pushID := strings.TrimSpace(string(rr.Body))
+	if pushIDRegex.MatchString(pushID) {
+		status.SetExternalID(pushID)
+	}
+
+	status.SetStatus(courier.MsgWired)
+	return status, nil
+}
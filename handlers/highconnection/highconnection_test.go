@@ -0,0 +1,110 @@
+package highconnection
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "HX", "2020", "FR", nil),
+}
+
+var (
+	receiveURL = "/c/hx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+	statusURL  = "/c/hx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status/"
+
+	receiveValid = "ID=1164708294&FROM=%2B33644961111&TO=36105&MESSAGE=Msg&RECEPTION_DATE=2017-05-02T21%3A13%3A13&USER_DATA_2=jours+pas+r%E9gl%E9"
+	receiveLatin = "ID=1164708294&FROM=%2B33644961111&TO=36105&MESSAGE=jours+pas+r%E9gl%E9&RECEPTION_DATE=2017-05-02T21%3A13%3A13"
+	receiveUTF8  = "ID=1164708294&FROM=%2B33644961111&TO=36105&MESSAGE=jours+pas+r%C3%A9gl%C3%A9&RECEPTION_DATE=2017-05-02T21%3A13%3A13"
+	receiveNoID  = "FROM=%2B33644961111&TO=36105&MESSAGE=Msg"
+	receiveDate  = "ID=1164708294&FROM=%2B33644961111&TO=36105&MESSAGE=Msg&RECEPTION_DATE=20170502"
+
+	statusRetID     = statusURL + "?push_id=1164711372&status=6&to=%2B33611441111&ret_id=12345&text=Msg"
+	statusPushID    = statusURL + "?push_id=1164711372&status=2&to=%2B33611441111&text=Msg"
+	statusNoID      = statusURL + "?status=6&to=%2B33611441111&text=Msg"
+	statusUnknown   = statusURL + "?push_id=1164711372&status=7&to=%2B33611441111&ret_id=12345&text=Msg"
+	statusNoStatus  = statusURL + "?push_id=1164711372&to=%2B33611441111&ret_id=12345&text=Msg"
+	statusSentRetID = statusURL + "?push_id=1164711372&status=4&ret_id=12345"
+)
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid Message", URL: receiveURL + "?FROM=+33644961111", Data: receiveValid, Status: 200, Response: "Accepted",
+		Text: Sp("Msg"), URN: Sp("tel:+33644961111"), External: Sp("1164708294"), Date: Tp(time.Date(2017, 5, 2, 19, 13, 13, 0, time.UTC))},
+	{Label: "Receive Unescaped Query FROM", URL: receiveURL + "?FROM=+33699999999", Data: receiveValid, Status: 200, Response: "Accepted",
+		Text: Sp("Msg"), URN: Sp("tel:+33644961111"), External: Sp("1164708294")},
+	{Label: "Receive Latin-1 Message", URL: receiveURL, Data: receiveLatin, Status: 200, Response: "Accepted",
+		Text: Sp("jours pas réglé"), URN: Sp("tel:+33644961111")},
+	{Label: "Receive UTF-8 Message", URL: receiveURL, Data: receiveUTF8, Status: 200, Response: "Accepted",
+		Text: Sp("jours pas réglé"), URN: Sp("tel:+33644961111"), PrepRequest: setUTF8Charset},
+	{Label: "Receive Missing ID", URL: receiveURL, Data: receiveNoID, Status: 400, Response: "field 'id' required"},
+	{Label: "Receive Invalid Date", URL: receiveURL, Data: receiveDate, Status: 400, Response: "invalid RECEPTION_DATE"},
+
+	{Label: "Status Ret ID", URL: statusRetID, Status: 200, Response: `"msg_id":12345`},
+	{Label: "Status Sent", URL: statusSentRetID, Status: 200, Response: `"status":"S"`},
+	{Label: "Status Push ID", URL: statusPushID, Status: 200, Response: `"external_id":"1164711372"`},
+	{Label: "Status Missing IDs", URL: statusNoID, Status: 400, Response: "missing ret_id or push_id"},
+	{Label: "Status Unknown", URL: statusUnknown, Status: 400, Response: "unknown status '7'"},
+	{Label: "Status Missing Status", URL: statusNoStatus, Status: 400, Response: "field 'status' required"},
+}
+
+// setUTF8Charset declares that the request body is UTF-8 encoded
+func setUTF8Charset(r *http.Request) {
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	sendURL = server.URL
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "tel:+33611441111",
+		Status: "W", ExternalID: "1164711372",
+		ResponseBody: "1164711372", ResponseStatus: 200,
+		URLParams: map[string]string{"accountid": "Username", "password": "Password", "text": "Simple Message", "to": "+33611441111",
+			"ret_id": "10", "datacoding": "8",
+			"ret_url":    "http://courier.test/c/hx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status",
+			"ret_mo_url": "http://courier.test/c/hx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive"},
+		SendPrep: setSendURL},
+	{Label: "Unicode Send",
+		Text: "Unicode ☺", URN: "tel:+33611441111",
+		Status:       "W",
+		ResponseBody: "", ResponseStatus: 200,
+		URLParams: map[string]string{"text": "Unicode ☺"},
+		SendPrep:  setSendURL},
+	{Label: "Send Attachment",
+		Text: "My pic!", URN: "tel:+33611441111", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status:       "W",
+		ResponseBody: "", ResponseStatus: 200,
+		URLParams: map[string]string{"text": "My pic!\nhttps://foo.bar/image.jpg"},
+		SendPrep:  setSendURL},
+	{Label: "Error Sending",
+		Text: "Error Message", URN: "tel:+33611441111",
+		Status:       "E",
+		ResponseBody: `Error`, ResponseStatus: 403,
+		SendPrep: setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "HX", "2020", "FR",
+		map[string]interface{}{
+			courier.ConfigUsername: "Username",
+			courier.ConfigPassword: "Password",
+		})
+
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+}
@@ -1,3 +1,27 @@
 package hub9
 
-/* no logs */
+/*
+GET /handlers/hub9/received/uuid?userid=testusr&password=test&original=6289881134560&sendto=6289881131111&messageid=99123635&message=Test+Hub9
+*/
+
+import (
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers/dart"
+)
+
+var sendURL = "http://175.103.48.29:28078/testing/smsmt.php"
+
+// Hub9 delivery reports use DartMedia's codes, the only one documented being 10 for messages delivered to the
+// handset, which is also the only one RapidPro's Hub9 integration treats as a success. Anything else is a failure.
+var statusMapping = map[int]courier.MsgStatusValue{
+	10: courier.MsgDelivered,
+}
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+// NewHandler returns a new Hub9 handler, Hub9 uses the same protocol as DartMedia
+func NewHandler() courier.ChannelHandler {
+	return dart.NewDartHandler(courier.ChannelType("H9"), "Hub9", sendURL, statusMapping)
+}
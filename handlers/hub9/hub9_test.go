@@ -0,0 +1,77 @@
+package hub9
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "H9", "2020", "ID", nil),
+}
+
+var (
+	receiveURL = "/c/h9/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/received/"
+	statusURL  = "/c/h9/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/delivered/"
+
+	receiveValid = receiveURL + "?userid=testusr&password=test&original=6289881134560&sendto=2020&messageid=99123635" +
+		"&message=Test+Hub9&date=20170503131559"
+	receiveMissingFrom = receiveURL + "?userid=testusr&password=test&sendto=2020&messageid=99123635&message=Test+Hub9"
+
+	statusDelivered = statusURL + "?messageid=12345&status=10"
+	statusZero      = statusURL + "?messageid=12345&status=0"
+	statusFailed    = statusURL + "?messageid=12345&status=21"
+	statusInvalidID = statusURL + "?messageid=abc&status=10"
+)
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid", URL: receiveValid, Status: 200, Response: "000",
+		Text: Sp("Test Hub9"), URN: Sp("tel:+6289881134560"), External: Sp("99123635"), Date: Tp(time.Date(2017, 5, 3, 6, 15, 59, 0, time.UTC))},
+	{Label: "Receive Missing Original", URL: receiveMissingFrom, Status: 400, Response: "field 'original' required"},
+
+	{Label: "Status Delivered", URL: statusDelivered, Status: 200, Response: "000"},
+	{Label: "Status Zero", URL: statusZero, Status: 200, Response: "000"},
+	{Label: "Status Failed", URL: statusFailed, Status: 200, Response: "000"},
+	{Label: "Status Invalid ID", URL: statusInvalidID, Status: 400, Response: "invalid messageid: abc"},
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	channel.(*courier.MockChannel).SetConfig(courier.ConfigSendURL, server.URL)
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "tel:+6289881134560",
+		Status:       "W",
+		ResponseBody: "000", ResponseStatus: 200,
+		URLParams: map[string]string{"userid": "Username", "password": "Password", "original": "2020", "sendto": "6289881134560",
+			"messageid": "10", "message": "Simple Message", "dcs": "0", "udhl": "0"},
+		SendPrep: setSendURL},
+	{Label: "Error Code",
+		Text: "Error Message", URN: "tel:+6289881134560",
+		Status:       "E",
+		ResponseBody: "107", ResponseStatus: 200,
+		SendPrep: setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "H9", "2020", "ID",
+		map[string]interface{}{
+			courier.ConfigUsername: "Username",
+			courier.ConfigPassword: "Password",
+		})
+
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+}
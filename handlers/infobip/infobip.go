@@ -1,3 +1,276 @@
 package infobip
 
-/* no logs */
+/*
+POST /c/ib/uuid/receive/
+{"results":[{"messageId":"817790313235066447","from":"385916242493","to":"385921004026","text":"QUIZ Correct answer is Paris","cleanText":"Correct answer is Paris","keyword":"QUIZ","receivedAt":"2016-10-06T09:28:39.220+0000","smsCount":1,"price":{"pricePerMessage":0,"currency":"EUR"},"callbackData":"callbackData"}],"messageCount":1,"pendingMessageCount":0}
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+var sendURL = "https://api.infobip.com/sms/1/text/advanced"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Infobip handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("IB"), "Infobip")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddReceiveMsgRoute(h, "POST", "receive", h.ReceiveMessage)
+	if err != nil {
+		return err
+	}
+
+	return s.AddUpdateStatusRoute(h, "POST", "delivered", h.StatusMessage)
+}
+
+// {
+//   "results": [{
+//     "messageId": "817790313235066447",
+//     "from": "385916242493",
+//     "to": "385921004026",
+//     "text": "QUIZ Correct answer is Paris",
+//     "cleanText": "Correct answer is Paris",
+//     "keyword": "QUIZ",
+//     "receivedAt": "2016-10-06T09:28:39.220+0000",
+//     "smsCount": 1
+//   }],
+//   "messageCount": 1,
+//   "pendingMessageCount": 0
+// }
+type ibMOEnvelope struct {
+	Results []struct {
+		MessageID  string `json:"messageId"`
+		From       string `json:"from"        validate:"required"`
+		Text       string `json:"text"`
+		ReceivedAt string `json:"receivedAt"`
+	} `json:"results"       validate:"required,dive"`
+	MessageCount        int `json:"messageCount"`
+	PendingMessageCount int `json:"pendingMessageCount"`
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages, Infobip may send us more than one
+// message per request
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	payload := &ibMOEnvelope{}
+	err := handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]courier.Msg, 0, len(payload.Results))
+	for _, ibMsg := range payload.Results {
+		date := time.Now().UTC()
+		if ibMsg.ReceivedAt != "" {
+			date, err = time.Parse("2006-01-02T15:04:05.999-0700", ibMsg.ReceivedAt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid receivedAt: %s", ibMsg.ReceivedAt)
+			}
+		}
+
+		// create our URN
+		urn := courier.NewTelURNForChannel(ibMsg.From, channel)
+
+		// build our msg
+		msg := h.Backend().NewIncomingMsg(channel, urn, ibMsg.Text).WithExternalID(ibMsg.MessageID).WithReceivedOn(date.UTC())
+
+		err = h.Backend().WriteMsg(msg)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	if len(msgs) == 0 {
+		return nil, courier.WriteIgnored(w, r, "Ignoring request, no messages")
+	}
+
+	return msgs, courier.WriteEventsSuccess(w, r, msgs, nil)
+}
+
+// {
+//   "results": [{
+//     "bulkId": "",
+//     "messageId": "12345",
+//     "to": "2347030767144",
+//     "sentAt": "2016-08-29T12:08:11.550+0000",
+//     "doneAt": "2016-08-29T12:08:14.200+0000",
+//     "smsCount": 1,
+//     "status": {
+//       "groupId": 3,
+//       "groupName": "DELIVERED",
+//       "id": 5,
+//       "name": "DELIVERED_TO_HANDSET",
+//       "description": "Message delivered to handset"
+//     },
+//     "callbackData": "10"
+//   }]
+// }
+type ibStatusEnvelope struct {
+	Results []struct {
+		MessageID string `json:"messageId"`
+		Status    struct {
+			GroupName string `json:"groupName"`
+			Name      string `json:"name"`
+		} `json:"status"`
+		CallbackData string `json:"callbackData"`
+	} `json:"results"  validate:"required"`
+}
+
+var ibStatusMapping = map[string]courier.MsgStatusValue{
+	"PENDING":       courier.MsgSent,
+	"DELIVERED":     courier.MsgDelivered,
+	"EXPIRED":       courier.MsgFailed,
+	"REJECTED":      courier.MsgFailed,
+	"UNDELIVERABLE": courier.MsgFailed,
+}
+
+// StatusMessage is our HTTP handler function for delivery reports, these may also be batched
+func (h *handler) StatusMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.MsgStatus, error) {
+	payload := &ibStatusEnvelope{}
+	err := handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// a batch may contain reports we can't use, those are skipped so they don't fail the rest
+	statuses := make([]courier.MsgStatus, 0, len(payload.Results))
+	for _, ibStatus := range payload.Results {
+		msgStatus, found := ibStatusMapping[ibStatus.Status.GroupName]
+		if !found {
+			continue
+		}
+
+		// our callback data is the id of the msg we sent, fall back to their message id if it is missing
+		var status courier.MsgStatus
+		msgID, err := strconv.ParseInt(ibStatus.CallbackData, 10, 64)
+		if err == nil {
+			status = h.Backend().NewMsgStatusForID(channel, courier.NewMsgID(msgID), msgStatus)
+		} else if ibStatus.MessageID != "" {
+			status = h.Backend().NewMsgStatusForExternalID(channel, ibStatus.MessageID, msgStatus)
+		} else {
+			continue
+		}
+
+		err = h.Backend().WriteMsgStatus(status)
+		if err == courier.ErrMsgNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+
+	if len(statuses) == 0 {
+		return nil, courier.WriteIgnored(w, r, "Ignoring request, no known statuses")
+	}
+
+	return statuses, courier.WriteEventsSuccess(w, r, nil, statuses)
+}
+
+type ibDestination struct {
+	To        string `json:"to"`
+	MessageID string `json:"messageId"`
+}
+
+type ibMessage struct {
+	From               string          `json:"from"`
+	Destinations       []ibDestination `json:"destinations"`
+	Text               string          `json:"text"`
+	NotifyContentType  string          `json:"notifyContentType"`
+	IntermediateReport bool            `json:"intermediateReport"`
+	NotifyURL          string          `json:"notifyUrl"`
+	CallbackData       string          `json:"callbackData"`
+}
+
+type ibOutgoing struct {
+	Messages []ibMessage `json:"messages"`
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
+	if username == "" {
+		return nil, fmt.Errorf("no username set for IB channel")
+	}
+
+	password := msg.Channel().StringConfigForKey(courier.ConfigPassword, "")
+	if password == "" {
+		return nil, fmt.Errorf("no password set for IB channel")
+	}
+
+	statusURL := fmt.Sprintf("%s/c/ib/%s/delivered", h.Server().Config().BaseURL, msg.Channel().UUID())
+
+	// our msg id is passed as the callback data so it is included in all delivery reports
+	payload := &ibOutgoing{
+		Messages: []ibMessage{{
+			From:               strings.TrimPrefix(msg.Channel().Address(), "+"),
+			Destinations:       []ibDestination{{To: strings.TrimPrefix(msg.URN().Path(), "+"), MessageID: msg.ID().String()}},
+			Text:               courier.GetTextAndAttachments(msg),
+			NotifyContentType:  "application/json",
+			IntermediateReport: true,
+			NotifyURL:          statusURL,
+			CallbackData:       msg.ID().String(),
+		}},
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(username, password)
+	rr, err := utils.MakeHTTPRequest(req)
+
+	// record our status and log
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+	status.AddLog(log)
+	if err != nil {
+		return status, nil
+	}
+
+	// messages which were accepted are in the PENDING group, anything else was rejected
+	groupName, _ := jsonparser.GetString(rr.Body, "messages", "[0]", "status", "groupName")
+	if groupName != "PENDING" {
+		description, _ := jsonparser.GetString(rr.Body, "messages", "[0]", "status", "description")
+		log.WithError("Message Send Error", errors.Errorf("received non-pending status '%s': %s", groupName, description))
+		return status, nil
+	}
+
+	externalID, err := jsonparser.GetString(rr.Body, "messages", "[0]", "messageId")
+	if err != nil || externalID == "" {
+		log.WithError("Message Send Error", errors.Errorf("unable to get messageId from body"))
+		return status, nil
+	}
+
+	status.SetStatus(courier.MsgWired)
+	status.SetExternalID(externalID)
+	return status, nil
+}
@@ -4,3 +4,215 @@ package jasmin
 POST /handlers/jasmin/receive/uuid/
 priority=0&from=22672561111&origin-connector=telmob3350&coding=0&content=Msg&to=3350&id=13d63077-f090-4060-82e5-d0d34d896e3b
 */
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/gsm7"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+// Jasmin retries any callback which isn't acknowledged with exactly this body
+const ackResponse = "ACK/Jasmin"
+
+var successRegex = regexp.MustCompile(`^Success "(.+)"$`)
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Jasmin handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("JS"), "Jasmin")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddReceiveMsgRoute(h, "POST", "receive", h.ReceiveMessage)
+	if err != nil {
+		return err
+	}
+
+	return s.AddUpdateStatusRoute(h, "POST", "status", h.StatusMessage)
+}
+
+type jsMessage struct {
+	ID      string `validate:"required" name:"id"`
+	From    string `validate:"required" name:"from"`
+	To      string `validate:"required" name:"to"`
+	Content string `name:"content"`
+	Coding  string `name:"coding"`
+}
+
+type jsStatus struct {
+	ID            string `validate:"required" name:"id"`
+	MessageStatus string `validate:"required" name:"message_status"`
+	Level         int    `name:"level"`
+}
+
+var jsStatusMapping = map[string]courier.MsgStatusValue{
+	"ESME_ROK": courier.MsgSent,
+	"ACCEPTD":  courier.MsgSent,
+	"ENROUTE":  courier.MsgSent,
+	"DELIVRD":  courier.MsgDelivered,
+	"EXPIRED":  courier.MsgFailed,
+	"DELETED":  courier.MsgFailed,
+	"UNDELIV":  courier.MsgFailed,
+	"REJECTD":  courier.MsgFailed,
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	// get our params
+	jsMsg := &jsMessage{}
+	err := handlers.DecodeAndValidateForm(jsMsg, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// content is passed through as the raw bytes of the SMS, decode it according to its data coding
+	text := jsMsg.Content
+	switch jsMsg.Coding {
+	case "3":
+		text = utils.DecodeLatin1([]byte(jsMsg.Content))
+	case "8":
+		text, err = utils.DecodeUTF16BE([]byte(jsMsg.Content))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// create our URN
+	urn := courier.NewTelURNForChannel(jsMsg.From, channel)
+
+	// build our msg
+	msg := h.Backend().NewIncomingMsg(channel, urn, text).WithExternalID(jsMsg.ID)
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, writeAck(w)
+}
+
+// StatusMessage is our HTTP handler function for status updates
+func (h *handler) StatusMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.MsgStatus, error) {
+	// get our params
+	jsStatus := &jsStatus{}
+	err := handlers.DecodeAndValidateForm(jsStatus, r)
+	if err != nil {
+		return nil, err
+	}
+
+	msgStatus, found := jsStatusMapping[jsStatus.MessageStatus]
+	if !found {
+		// any other SMPP error from the SMSC means the message was never accepted, anything else (such as
+		// UNKNOWN) is acknowledged so Jasmin doesn't retry, but otherwise ignored
+		if !strings.HasPrefix(jsStatus.MessageStatus, "ESME_") {
+			return nil, writeAck(w)
+		}
+		msgStatus = courier.MsgFailed
+	}
+
+	// write our status
+	status := h.Backend().NewMsgStatusForExternalID(channel, jsStatus.ID, msgStatus)
+	err = h.Backend().WriteMsgStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.MsgStatus{status}, writeAck(w)
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
+	if username == "" {
+		return nil, fmt.Errorf("no username set for JS channel")
+	}
+
+	password := msg.Channel().StringConfigForKey(courier.ConfigPassword, "")
+	if password == "" {
+		return nil, fmt.Errorf("no password set for JS channel")
+	}
+
+	sendURL := msg.Channel().StringConfigForKey(courier.ConfigSendURL, "")
+	if sendURL == "" {
+		return nil, fmt.Errorf("no send url set for JS channel")
+	}
+
+	dlrURL := fmt.Sprintf("%s/c/js/%s/status", h.Server().Config().BaseURL, msg.Channel().UUID())
+
+	// build our request, we ask for delivery reports from both the SMSC and the handset
+	form := url.Values{
+		"username":   []string{username},
+		"password":   []string{password},
+		"from":       []string{strings.TrimPrefix(msg.Channel().Address(), "+")},
+		"to":         []string{strings.TrimPrefix(msg.URN().Path(), "+")},
+		"dlr":        []string{"yes"},
+		"dlr-url":    []string{dlrURL},
+		"dlr-level":  []string{"3"},
+		"dlr-method": []string{http.MethodPost},
+	}
+
+	// send as GSM7 if we can, otherwise send UCS2 as hex encoded UTF-16BE
+	text := gsm7.ReplaceNonGSM7Chars(courier.GetTextAndAttachments(msg))
+	if gsm7.IsGSM7(text) {
+		form["coding"] = []string{"0"}
+		form["content"] = []string{text}
+	} else {
+		form["coding"] = []string{"8"}
+		form["hex-content"] = []string{hex.EncodeToString(utils.EncodeUTF16BE(courier.GetTextAndAttachments(msg)))}
+	}
+
+	msgURL, err := url.Parse(sendURL)
+	if err != nil {
+		return nil, err
+	}
+	msgURL.RawQuery = form.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, msgURL.String(), nil)
+	rr, err := utils.MakeHTTPRequest(req)
+
+	// record our status and log
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+	status.AddLog(log)
+	if err != nil {
+		return status, nil
+	}
+
+	// a successful send looks like: Success "07033084-5cfd-4812-90a4-e4d24ffb6e3d"
+	match := successRegex.FindStringSubmatch(strings.TrimSpace(string(rr.Body)))
+	if match == nil {
+		log.WithError("Message Send Error", errors.Errorf("unexpected response from Jasmin: %s", rr.Body))
+		return status, nil
+	}
+
+	status.SetStatus(courier.MsgWired)
+	status.SetExternalID(match[1])
+
+	return status, nil
+}
+
+func writeAck(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	_, err := fmt.Fprint(w, ackResponse)
+	return err
+}
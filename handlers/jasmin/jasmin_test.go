@@ -0,0 +1,111 @@
+package jasmin
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "JS", "2020", "US", nil),
+}
+
+var (
+	receiveURL = "/c/js/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+	statusURL  = "/c/js/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status/"
+
+	receiveValid   = "priority=0&from=22672561111&origin-connector=telmob3350&coding=0&content=Msg&to=3350&id=13d63077-f090-4060-82e5-d0d34d896e3b"
+	receiveUCS2    = "priority=0&from=22672561111&origin-connector=telmob3350&coding=8&content=%00H%00i%00+%26%3A&to=3350&id=13d63077-f090-4060-82e5-d0d34d896e3b"
+	receiveBadUCS2 = "priority=0&from=22672561111&origin-connector=telmob3350&coding=8&content=%00H%00&to=3350&id=13d63077-f090-4060-82e5-d0d34d896e3b"
+	receiveNoFrom  = "priority=0&origin-connector=telmob3350&coding=0&content=Msg&to=3350&id=13d63077-f090-4060-82e5-d0d34d896e3b"
+	receiveNoID    = "priority=0&from=22672561111&coding=0&content=Msg&to=3350"
+
+	statusDelivered = "id=external1&message_status=DELIVRD&level=2"
+	statusSent      = "id=external1&message_status=ESME_ROK&level=1"
+	statusRejected  = "id=external1&message_status=ESME_RINVDSTADR&level=1"
+	statusUndeliv   = "id=external1&message_status=UNDELIV&level=2"
+	statusUnknown   = "id=external1&message_status=UNKNOWN&level=2"
+	statusNoID      = "message_status=DELIVRD&level=2"
+)
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid", URL: receiveURL, Data: receiveValid, Status: 200, Response: "ACK/Jasmin",
+		Text: Sp("Msg"), URN: Sp("tel:+22672561111"), External: Sp("13d63077-f090-4060-82e5-d0d34d896e3b")},
+	{Label: "Receive UCS2", URL: receiveURL, Data: receiveUCS2, Status: 200, Response: "ACK/Jasmin",
+		Text: Sp("Hi ☺"), URN: Sp("tel:+22672561111"), External: Sp("13d63077-f090-4060-82e5-d0d34d896e3b")},
+	{Label: "Receive Invalid UCS2", URL: receiveURL, Data: receiveBadUCS2, Status: 400, Response: "even number of bytes"},
+	{Label: "Receive Missing From", URL: receiveURL, Data: receiveNoFrom, Status: 400, Response: "field 'from' required"},
+	{Label: "Receive Missing ID", URL: receiveURL, Data: receiveNoID, Status: 400, Response: "field 'id' required"},
+
+	{Label: "Status Delivered", URL: statusURL, Data: statusDelivered, Status: 200, Response: "ACK/Jasmin"},
+	{Label: "Status Sent", URL: statusURL, Data: statusSent, Status: 200, Response: "ACK/Jasmin"},
+	{Label: "Status Rejected", URL: statusURL, Data: statusRejected, Status: 200, Response: "ACK/Jasmin"},
+	{Label: "Status Undelivered", URL: statusURL, Data: statusUndeliv, Status: 200, Response: "ACK/Jasmin"},
+	{Label: "Status Unknown", URL: statusURL, Data: statusUnknown, Status: 200, Response: "ACK/Jasmin"},
+	{Label: "Status Missing ID", URL: statusURL, Data: statusNoID, Status: 400, Response: "field 'id' required"},
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	channel.(*courier.MockChannel).SetConfig(courier.ConfigSendURL, server.URL)
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "tel:+250788383383",
+		Status: "W", ExternalID: "07033084-5cfd-4812-90a4-e4d24ffb6e3d",
+		ResponseBody: `Success "07033084-5cfd-4812-90a4-e4d24ffb6e3d"`, ResponseStatus: 200,
+		URLParams: map[string]string{"username": "Username", "password": "Password", "from": "2020", "to": "250788383383",
+			"content": "Simple Message", "coding": "0", "dlr": "yes", "dlr-level": "3", "dlr-method": "POST",
+			"dlr-url": "http://courier.test/c/js/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status"},
+		SendPrep: setSendURL},
+	{Label: "Smart Encoding",
+		Text: "Fancy “Smart” Quotes", URN: "tel:+250788383383",
+		Status: "W", ExternalID: "07033084-5cfd-4812-90a4-e4d24ffb6e3d",
+		ResponseBody: `Success "07033084-5cfd-4812-90a4-e4d24ffb6e3d"`, ResponseStatus: 200,
+		URLParams: map[string]string{"content": `Fancy "Smart" Quotes`, "coding": "0"},
+		SendPrep:  setSendURL},
+	{Label: "Unicode Send",
+		Text: "☺", URN: "tel:+250788383383",
+		Status: "W", ExternalID: "07033084-5cfd-4812-90a4-e4d24ffb6e3d",
+		ResponseBody: `Success "07033084-5cfd-4812-90a4-e4d24ffb6e3d"`, ResponseStatus: 200,
+		URLParams: map[string]string{"hex-content": "263a", "coding": "8", "content": ""},
+		SendPrep:  setSendURL},
+	{Label: "Send Attachment",
+		Text: "My pic!", URN: "tel:+250788383383", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status: "W", ExternalID: "07033084-5cfd-4812-90a4-e4d24ffb6e3d",
+		ResponseBody: `Success "07033084-5cfd-4812-90a4-e4d24ffb6e3d"`, ResponseStatus: 200,
+		URLParams: map[string]string{"content": "My pic!\nhttps://foo.bar/image.jpg"},
+		SendPrep:  setSendURL},
+	{Label: "No Route",
+		Text: "No Route", URN: "tel:+250788383383",
+		Status:       "E",
+		ResponseBody: `Error "No route found"`, ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Error Sending",
+		Text: "Error Message", URN: "tel:+250788383383",
+		Status:       "E",
+		ResponseBody: `Error "Authentication failure"`, ResponseStatus: 403,
+		SendPrep: setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "JS", "2020", "US",
+		map[string]interface{}{
+			courier.ConfigUsername: "Username",
+			courier.ConfigPassword: "Password",
+			courier.ConfigSendURL:  "http://example.com/send",
+		})
+
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+}
@@ -1,3 +1,212 @@
 package junebug
 
-/* no logs */
+/*
+POST /c/jn/uuid/inbound/
+{"channel_data":{},"from":"+250788383383","channel_id":"test","timestamp":"2017-01-01 00:00:00.00","content":"hello","to":"1234","reply_to":null,"message_id":"external-id"}
+
+POST /c/jn/uuid/event/
+{"event_type":"submitted","message_id":"external-id","channel-id":"test","timestamp":"2017-01-01 00:00:00+0000","event_details":{}}
+*/
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+// the config key for the secret Junebug sends us in its Authorization header, if set
+const configSecret = "secret"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Junebug handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("JN"), "Junebug")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddReceiveMsgRoute(h, "POST", "inbound", h.ReceiveMessage)
+	if err != nil {
+		return err
+	}
+
+	return s.AddUpdateStatusRoute(h, "POST", "event", h.StatusMessage)
+}
+
+type jnMessage struct {
+	From      string `json:"from"        validate:"required"`
+	To        string `json:"to"`
+	Content   string `json:"content"`
+	MessageID string `json:"message_id"  validate:"required"`
+	Timestamp string `json:"timestamp"`
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	err := h.validateSecret(channel, r)
+	if err != nil {
+		return nil, err
+	}
+
+	jnMsg := &jnMessage{}
+	err = handlers.DecodeAndValidateJSON(jnMsg, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// timestamps are in UTC
+	date := time.Now().UTC()
+	if jnMsg.Timestamp != "" {
+		date, err = time.Parse("2006-01-02 15:04:05.999999", jnMsg.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp: %s", jnMsg.Timestamp)
+		}
+	}
+
+	// create our URN
+	urn := courier.NewTelURNForChannel(jnMsg.From, channel)
+
+	// build our msg
+	msg := h.Backend().NewIncomingMsg(channel, urn, jnMsg.Content).WithExternalID(jnMsg.MessageID).WithReceivedOn(date)
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, courier.WriteReceiveSuccess(w, r, msg)
+}
+
+type jnEvent struct {
+	EventType string `json:"event_type"  validate:"required"`
+	MessageID string `json:"message_id"  validate:"required"`
+}
+
+var jnStatusMapping = map[string]courier.MsgStatusValue{
+	"submitted":          courier.MsgSent,
+	"delivery_succeeded": courier.MsgDelivered,
+	"delivery_failed":    courier.MsgFailed,
+	"rejected":           courier.MsgFailed,
+}
+
+// StatusMessage is our HTTP handler function for events about messages we sent
+func (h *handler) StatusMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.MsgStatus, error) {
+	err := h.validateSecret(channel, r)
+	if err != nil {
+		return nil, err
+	}
+
+	jnEvent := &jnEvent{}
+	err = handlers.DecodeAndValidateJSON(jnEvent, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// events we don't know about are acknowledged but ignored
+	msgStatus, found := jnStatusMapping[jnEvent.EventType]
+	if !found {
+		return nil, courier.WriteIgnored(w, r, fmt.Sprintf("Ignoring unknown event_type '%s'", jnEvent.EventType))
+	}
+
+	// events reference the id Junebug gave the message, which is our external id
+	status := h.Backend().NewMsgStatusForExternalID(channel, jnEvent.MessageID, msgStatus)
+	err = h.Backend().WriteMsgStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.MsgStatus{status}, courier.WriteStatusSuccess(w, r, status)
+}
+
+// validateSecret checks the Authorization header of the request against our secret, if we have one
+func (h *handler) validateSecret(channel courier.Channel, r *http.Request) error {
+	secret := channel.StringConfigForKey(configSecret, "")
+	if secret == "" {
+		return nil
+	}
+
+	expected := fmt.Sprintf("Token %s", secret)
+	actual := r.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+		return fmt.Errorf("invalid Authorization header")
+	}
+	return nil
+}
+
+type jnOutgoing struct {
+	To             string `json:"to"`
+	From           string `json:"from"`
+	Content        string `json:"content"`
+	EventURL       string `json:"event_url"`
+	EventAuthToken string `json:"event_auth_token,omitempty"`
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	sendURL := msg.Channel().StringConfigForKey(courier.ConfigSendURL, "")
+	if sendURL == "" {
+		return nil, fmt.Errorf("no send url set for JN channel")
+	}
+
+	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
+	if username == "" {
+		return nil, fmt.Errorf("no username set for JN channel")
+	}
+
+	password := msg.Channel().StringConfigForKey(courier.ConfigPassword, "")
+	if password == "" {
+		return nil, fmt.Errorf("no password set for JN channel")
+	}
+
+	// Junebug will send our secret back to us with each event
+	payload := &jnOutgoing{
+		To:             msg.URN().Path(),
+		From:           msg.Channel().Address(),
+		Content:        courier.GetTextAndAttachments(msg),
+		EventURL:       fmt.Sprintf("%s/c/jn/%s/event", h.Server().Config().BaseURL, msg.Channel().UUID()),
+		EventAuthToken: msg.Channel().StringConfigForKey(configSecret, ""),
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(username, password)
+	rr, err := utils.MakeHTTPRequest(req)
+
+	// record our status and log
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+	status.AddLog(log)
+	if err != nil {
+		return status, nil
+	}
+
+	externalID, err := jsonparser.GetString(rr.Body, "result", "id")
+	if err != nil || externalID == "" {
+		log.WithError("Message Send Error", errors.Errorf("unable to get result.id from body"))
+		return status, nil
+	}
+
+	status.SetStatus(courier.MsgWired)
+	status.SetExternalID(externalID)
+	return status, nil
+}
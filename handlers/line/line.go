@@ -1,3 +1,299 @@
 package line
 
-/* no logs */
+/*
+POST /c/ln/uuid/receive/
+{"events":[{"replyToken":"nHuyWiB7yP5Zw52FIkcQobQuGDXCTA","type":"message","timestamp":1462629479859,"source":{"type":"user","userId":"U4af4980629"},"message":{"id":"325708","type":"text","text":"Hello, world"}}]}
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+)
+
+// the config key for the channel secret used to sign incoming requests
+const configSecret = "secret"
+
+const lineSignatureHeader = "X-Line-Signature"
+
+// LINE allows at most this many message objects in a single push request
+const maxMsgsPerPush = 5
+
+var (
+	sendURL    = "https://api.line.me/v2/bot/message/push"
+	contentURL = "https://api.line.me/v2/bot/message/%s/content"
+	stickerURL = "https://stickershop.line-scdn.net/stickershop/v1/sticker/%s/android/sticker.png"
+)
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new LINE handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("LN"), "Line")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	return s.AddReceiveMsgRoute(h, "POST", "receive", h.ReceiveMessage)
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages, LINE batches events for many users in
+// a single request
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	err := h.validateSignature(channel, r)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &lineEnvelope{}
+	err = handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]courier.Msg, 0, len(payload.Events))
+
+	for _, event := range payload.Events {
+		// we only care about messages sent directly to us by users
+		if event.Type != "message" || event.Message == nil || event.Source.UserID == "" {
+			continue
+		}
+
+		urn, err := courier.NewURNFromParts(courier.LineScheme, event.Source.UserID, "")
+		if err != nil {
+			return nil, err
+		}
+
+		date := time.Unix(0, event.Timestamp*int64(time.Millisecond)).UTC()
+		text := ""
+		attachment := ""
+
+		switch event.Message.Type {
+		case "text":
+			text = event.Message.Text
+
+		case "image":
+			// images sent from an external provider can be fetched directly, otherwise they are only
+			// available via the content API
+			if event.Message.ContentProvider != nil && event.Message.ContentProvider.OriginalContentURL != "" {
+				attachment = event.Message.ContentProvider.OriginalContentURL
+			} else {
+				attachment = fmt.Sprintf(contentURL, event.Message.ID)
+			}
+
+		case "location":
+			text = fmt.Sprintf("%f,%f", event.Message.Latitude, event.Message.Longitude)
+			attachment = fmt.Sprintf("geo:%f,%f", event.Message.Latitude, event.Message.Longitude)
+
+		case "sticker":
+			attachment = fmt.Sprintf(stickerURL, event.Message.StickerID)
+
+		default:
+			// video, audio and file messages aren't something we deal with yet
+			continue
+		}
+
+		msg := h.Backend().NewIncomingMsg(channel, urn, text).WithExternalID(event.Message.ID).WithReceivedOn(date)
+		if attachment != "" {
+			msg.WithAttachment(attachment)
+		}
+
+		err = h.Backend().WriteMsg(msg)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	if len(msgs) == 0 {
+		return nil, courier.WriteIgnored(w, r, "Ignoring request, no message events")
+	}
+
+	return msgs, courier.WriteEventsSuccess(w, r, msgs, nil)
+}
+
+// see https://developers.line.me/en/docs/messaging-api/reference/#signature-validation
+func (h *handler) validateSignature(channel courier.Channel, r *http.Request) error {
+	actual := r.Header.Get(lineSignatureHeader)
+	if actual == "" {
+		return fmt.Errorf("missing request signature")
+	}
+
+	secret := channel.StringConfigForKey(configSecret, "")
+	if secret == "" {
+		return fmt.Errorf("invalid or missing channel secret in config")
+	}
+
+	// read our body, we put it back afterwards so it can be decoded
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 100000))
+	r.Body.Close()
+	if err != nil {
+		return fmt.Errorf("unable to read request body: %s", err)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	expected := calculateSignature(secret, body)
+
+	// compare signatures in way that isn't sensitive to a timing attack
+	if !hmac.Equal([]byte(expected), []byte(actual)) {
+		return fmt.Errorf("invalid request signature")
+	}
+	return nil
+}
+
+// calculateSignature returns the signature LINE sends for the passed in body, the base64 encoded HMAC-SHA256
+func calculateSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// BuildDownloadMediaRequest builds the request to fetch the passed in media URL, media fetched from the content API
+// needs our channel access token
+func (h *handler) BuildDownloadMediaRequest(channel courier.Channel, mediaURL string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// media from external providers and stickers are fetched without authentication
+	if strings.HasPrefix(mediaURL, strings.Split(contentURL, "%s")[0]) {
+		accessToken := channel.StringConfigForKey(courier.ConfigAuthToken, "")
+		if accessToken == "" {
+			return nil, fmt.Errorf("missing access token for LN channel")
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	}
+
+	return req, nil
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	accessToken := msg.Channel().StringConfigForKey(courier.ConfigAuthToken, "")
+	if accessToken == "" {
+		return nil, fmt.Errorf("missing access token for LN channel")
+	}
+
+	// the status that will be written for this message
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+
+	// build up all the message objects we need to send, text first then each attachment
+	parts := make([]*lineOutgoingMessage, 0, len(msg.Attachments())+1)
+	if msg.Text() != "" {
+		parts = append(parts, &lineOutgoingMessage{Type: "text", Text: msg.Text()})
+	}
+	for _, attachment := range msg.Attachments() {
+		mediaType, mediaURL := courier.SplitAttachment(attachment)
+
+		switch mediaType {
+		case "image/jpeg", "image/png":
+			parts = append(parts, &lineOutgoingMessage{Type: "image", OriginalContentURL: mediaURL, PreviewImageURL: mediaURL})
+		default:
+			// LINE needs a preview image for videos and the duration of audio, which we don't have, so anything
+			// else is sent as a link
+			parts = append(parts, &lineOutgoingMessage{Type: "text", Text: mediaURL})
+		}
+	}
+
+	for i := 0; i < len(parts); i += maxMsgsPerPush {
+		end := i + maxMsgsPerPush
+		if end > len(parts) {
+			end = len(parts)
+		}
+
+		payload := &lineOutgoing{To: msg.URN().Path(), Messages: parts[i:end]}
+		body, _ := json.Marshal(payload)
+		req, err := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+		rr, err := utils.MakeHTTPRequest(req)
+
+		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+		status.AddLog(log)
+		if err != nil {
+			return status, nil
+		}
+	}
+
+	status.SetStatus(courier.MsgWired)
+	return status, nil
+}
+
+type lineOutgoingMessage struct {
+	Type               string `json:"type"`
+	Text               string `json:"text,omitempty"`
+	OriginalContentURL string `json:"originalContentUrl,omitempty"`
+	PreviewImageURL    string `json:"previewImageUrl,omitempty"`
+}
+
+type lineOutgoing struct {
+	To       string                 `json:"to"`
+	Messages []*lineOutgoingMessage `json:"messages"`
+}
+
+// {
+//   "events": [{
+//     "replyToken": "nHuyWiB7yP5Zw52FIkcQobQuGDXCTA",
+//     "type": "message",
+//     "timestamp": 1462629479859,
+//     "source": {
+//       "type": "user",
+//       "userId": "U4af4980629..."
+//     },
+//     "message": {
+//       "id": "325708",
+//       "type": "text",
+//       "text": "Hello, world"
+//     }
+//   }]
+// }
+type lineEnvelope struct {
+	Events []struct {
+		ReplyToken string `json:"replyToken"`
+		Type       string `json:"type"`
+		Timestamp  int64  `json:"timestamp"`
+		Source     struct {
+			Type   string `json:"type"`
+			UserID string `json:"userId"`
+		} `json:"source"`
+		Message *struct {
+			ID              string  `json:"id"`
+			Type            string  `json:"type"`
+			Text            string  `json:"text"`
+			Title           string  `json:"title"`
+			Address         string  `json:"address"`
+			Latitude        float64 `json:"latitude"`
+			Longitude       float64 `json:"longitude"`
+			PackageID       string  `json:"packageId"`
+			StickerID       string  `json:"stickerId"`
+			ContentProvider *struct {
+				Type               string `json:"type"`
+				OriginalContentURL string `json:"originalContentUrl"`
+			} `json:"contentProvider"`
+		} `json:"message"`
+	} `json:"events"`
+}
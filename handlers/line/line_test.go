@@ -0,0 +1,201 @@
+package line
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "LN", "2020", "US", map[string]interface{}{configSecret: "Secret"}),
+}
+
+var receiveURL = "/c/ln/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+
+var textMsg = `{
+	"events": [{
+		"replyToken": "nHuyWiB7yP5Zw52FIkcQobQuGDXCTA",
+		"type": "message",
+		"timestamp": 1459991487970,
+		"source": {"type": "user", "userId": "uabcdefghij"},
+		"message": {"id": "100001", "type": "text", "text": "Hello, world"}
+	}]
+}`
+
+var stickerMsg = `{
+	"events": [{
+		"replyToken": "nHuyWiB7yP5Zw52FIkcQobQuGDXCTA",
+		"type": "message",
+		"timestamp": 1459991487970,
+		"source": {"type": "user", "userId": "uabcdefghij"},
+		"message": {"id": "100002", "type": "sticker", "packageId": "1", "stickerId": "1"}
+	}]
+}`
+
+var imageMsg = `{
+	"events": [{
+		"replyToken": "nHuyWiB7yP5Zw52FIkcQobQuGDXCTA",
+		"type": "message",
+		"timestamp": 1459991487970,
+		"source": {"type": "user", "userId": "uabcdefghij"},
+		"message": {"id": "100003", "type": "image", "contentProvider": {"type": "line"}}
+	}]
+}`
+
+var externalImageMsg = `{
+	"events": [{
+		"replyToken": "nHuyWiB7yP5Zw52FIkcQobQuGDXCTA",
+		"type": "message",
+		"timestamp": 1459991487970,
+		"source": {"type": "user", "userId": "uabcdefghij"},
+		"message": {"id": "100004", "type": "image", "contentProvider": {"type": "external", "originalContentUrl": "https://foo.bar/image.jpg"}}
+	}]
+}`
+
+var locationMsg = `{
+	"events": [{
+		"replyToken": "nHuyWiB7yP5Zw52FIkcQobQuGDXCTA",
+		"type": "message",
+		"timestamp": 1459991487970,
+		"source": {"type": "user", "userId": "uabcdefghij"},
+		"message": {"id": "100005", "type": "location", "title": "my location", "address": "Japan", "latitude": 35.65910807942215, "longitude": 139.70372892916203}
+	}]
+}`
+
+var followEvent = `{
+	"events": [{
+		"replyToken": "nHuyWiB7yP5Zw52FIkcQobQuGDXCTA",
+		"type": "follow",
+		"timestamp": 1459991487970,
+		"source": {"type": "user", "userId": "uabcdefghij"}
+	}]
+}`
+
+var groupMsg = `{
+	"events": [{
+		"replyToken": "nHuyWiB7yP5Zw52FIkcQobQuGDXCTA",
+		"type": "message",
+		"timestamp": 1459991487970,
+		"source": {"type": "group", "groupId": "Ca56f94637c"},
+		"message": {"id": "100006", "type": "text", "text": "Hello, group"}
+	}]
+}`
+
+var invalidJSON = `{"events": [}`
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid Message", URL: receiveURL, Data: textMsg, Status: 200, Response: "Events Handled",
+		Text: Sp("Hello, world"), URN: Sp("line:uabcdefghij"), External: Sp("100001"), Date: Tp(time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC)),
+		PrepRequest: addValidSignature},
+	{Label: "Receive Sticker", URL: receiveURL, Data: stickerMsg, Status: 200, Response: "Events Handled",
+		Text: Sp(""), Attachment: Sp("https://stickershop.line-scdn.net/stickershop/v1/sticker/1/android/sticker.png"), PrepRequest: addValidSignature},
+	{Label: "Receive Image", URL: receiveURL, Data: imageMsg, Status: 200, Response: "Events Handled",
+		Text: Sp(""), Attachment: Sp("https://api.line.me/v2/bot/message/100003/content"), PrepRequest: addValidSignature},
+	{Label: "Receive External Image", URL: receiveURL, Data: externalImageMsg, Status: 200, Response: "Events Handled",
+		Text: Sp(""), Attachment: Sp("https://foo.bar/image.jpg"), PrepRequest: addValidSignature},
+	{Label: "Receive Location", URL: receiveURL, Data: locationMsg, Status: 200, Response: "Events Handled",
+		Text: Sp("35.659108,139.703729"), Attachment: Sp("geo:35.659108,139.703729"), PrepRequest: addValidSignature},
+	{Label: "Receive Follow", URL: receiveURL, Data: followEvent, Status: 200, Response: "Ignoring request", PrepRequest: addValidSignature},
+	{Label: "Receive Group Message", URL: receiveURL, Data: groupMsg, Status: 200, Response: "Ignoring request", PrepRequest: addValidSignature},
+	{Label: "Receive Invalid JSON", URL: receiveURL, Data: invalidJSON, Status: 400, Response: "unable to parse request JSON", PrepRequest: addValidSignature},
+	{Label: "Receive Invalid Signature", URL: receiveURL, Data: textMsg, Status: 400, Response: "invalid request signature", PrepRequest: addInvalidSignature},
+	{Label: "Receive Missing Signature", URL: receiveURL, Data: textMsg, Status: 400, Response: "missing request signature"},
+}
+
+func addValidSignature(r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.Header.Set(lineSignatureHeader, calculateSignature("Secret", body))
+}
+
+func addInvalidSignature(r *http.Request) {
+	r.Header.Set(lineSignatureHeader, "invalidsig")
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func TestBuildDownloadMediaRequest(t *testing.T) {
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "LN", "2020", "US",
+		map[string]interface{}{
+			courier.ConfigAuthToken: "AccessToken",
+			configSecret:            "Secret",
+		})
+	h := NewHandler().(*handler)
+
+	// media from the content API needs our access token
+	req, err := h.BuildDownloadMediaRequest(channel, "https://api.line.me/v2/bot/message/100002/content")
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer AccessToken", req.Header.Get("Authorization"))
+
+	// other media doesn't
+	req, err = h.BuildDownloadMediaRequest(channel, "https://foo.bar/image.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, "", req.Header.Get("Authorization"))
+
+	// can't fetch content without an access token
+	_, err = h.BuildDownloadMediaRequest(testChannels[0], "https://api.line.me/v2/bot/message/100002/content")
+	assert.Error(t, err)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	sendURL = server.URL
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "line:uabcdefghij",
+		Status:       "W",
+		ResponseBody: `{}`, ResponseStatus: 200,
+		Headers:     map[string]string{"Content-Type": "application/json", "Authorization": "Bearer AccessToken"},
+		RequestBody: `{"to":"uabcdefghij","messages":[{"type":"text","text":"Simple Message"}]}`,
+		SendPrep:    setSendURL},
+	{Label: "Send Attachments",
+		Text: "My media", URN: "line:uabcdefghij",
+		Attachments:  []string{"image/jpeg:https://foo.bar/image.jpg", "video/mp4:https://foo.bar/video.mp4", "audio/mp3:https://foo.bar/audio.mp3", "application/pdf:https://foo.bar/doc.pdf"},
+		Status:       "W",
+		ResponseBody: `{}`, ResponseStatus: 200,
+		RequestBody: `{"to":"uabcdefghij","messages":[{"type":"text","text":"My media"},` +
+			`{"type":"image","originalContentUrl":"https://foo.bar/image.jpg","previewImageUrl":"https://foo.bar/image.jpg"},` +
+			`{"type":"text","text":"https://foo.bar/video.mp4"},` +
+			`{"type":"text","text":"https://foo.bar/audio.mp3"},` +
+			`{"type":"text","text":"https://foo.bar/doc.pdf"}]}`,
+		SendPrep: setSendURL},
+	{Label: "Send Many Attachments",
+		Text: "", URN: "line:uabcdefghij",
+		Attachments: []string{"image/jpeg:https://foo.bar/1.jpg", "image/jpeg:https://foo.bar/2.jpg", "image/jpeg:https://foo.bar/3.jpg",
+			"image/jpeg:https://foo.bar/4.jpg", "image/jpeg:https://foo.bar/5.jpg", "image/jpeg:https://foo.bar/6.jpg"},
+		Status:       "W",
+		ResponseBody: `{}`, ResponseStatus: 200,
+		RequestBody: `{"to":"uabcdefghij","messages":[{"type":"image","originalContentUrl":"https://foo.bar/6.jpg","previewImageUrl":"https://foo.bar/6.jpg"}]}`,
+		SendPrep:    setSendURL},
+	{Label: "Error Sending",
+		Text: "Error Message", URN: "line:uabcdefghij",
+		Status:       "E",
+		ResponseBody: `{"message":"The request body has 2 error(s)"}`, ResponseStatus: 400,
+		SendPrep: setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "LN", "2020", "US",
+		map[string]interface{}{
+			courier.ConfigAuthToken: "AccessToken",
+			configSecret:            "Secret",
+		})
+
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+}
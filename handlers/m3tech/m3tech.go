@@ -3,3 +3,159 @@ package m3tech
 /*
 POST /api/v1/m3tech/received/uuid?from=+923023281111&text=Msg
 */
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/gsm7"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxGSMLength     = 160
+	maxUnicodeLength = 70
+
+	msgTypeGSM     = "0"
+	msgTypeUnicode = "7"
+)
+
+var sendURL = "https://secure.m3techservice.com/GenericServiceRestAPI/api/SendSMS"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new M3Tech handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("M3"), "M3Tech")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	return s.AddReceiveMsgRoute(h, "POST", "received", h.ReceiveMessage)
+}
+
+type m3Message struct {
+	From string `validate:"required" name:"from"`
+	Text string `name:"text"`
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	// get our params
+	m3Msg := &m3Message{}
+	err := handlers.DecodeAndValidateForm(m3Msg, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// create our URN
+	urn := courier.NewTelURNForChannel(m3Msg.From, channel)
+
+	// build our msg
+	msg := h.Backend().NewIncomingMsg(channel, urn, m3Msg.Text)
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, courier.WriteReceiveSuccess(w, r, msg)
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
+	if username == "" {
+		return nil, fmt.Errorf("no username set for M3 channel")
+	}
+
+	password := msg.Channel().StringConfigForKey(courier.ConfigPassword, "")
+	if password == "" {
+		return nil, fmt.Errorf("no password set for M3 channel")
+	}
+
+	// send as GSM if we can, otherwise as unicode which has a shorter part length
+	text := gsm7.ReplaceNonGSM7Chars(courier.GetTextAndAttachments(msg))
+	msgType := msgTypeGSM
+	maxLength := maxGSMLength
+	if !gsm7.IsGSM7(text) {
+		text = courier.GetTextAndAttachments(msg)
+		msgType = msgTypeUnicode
+		maxLength = maxUnicodeLength
+	}
+
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	for _, part := range handlers.SplitMsg(text, maxLength) {
+		form := url.Values{
+			"AuthKey":     []string{"m3-Tech"},
+			"UserId":      []string{username},
+			"Password":    []string{password},
+			"MobileNo":    []string{strings.TrimPrefix(msg.URN().Path(), "+")},
+			"MsgId":       []string{msg.ID().String()},
+			"SMS":         []string{part},
+			"MsgHeader":   []string{strings.TrimPrefix(msg.Channel().Address(), "+")},
+			"MsgType":     []string{msgType},
+			"HandsetPort": []string{"0"},
+			"SMSChannel":  []string{"0"},
+			"Telco":       []string{"0"},
+		}
+
+		msgURL, _ := url.Parse(sendURL)
+		msgURL.RawQuery = form.Encode()
+
+		req, err := http.NewRequest(http.MethodGet, msgURL.String(), nil)
+		rr, err := utils.MakeHTTPRequest(req)
+
+		// record our log
+		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+		status.AddLog(log)
+		if err != nil {
+			return status, nil
+		}
+
+		// a successful response has a response code of 0
+		code, err := parseResponseCode(rr.Body)
+		if err != nil {
+			log.WithError("Message Send Error", err)
+			return status, nil
+		}
+		if code != "0" {
+			log.WithError("Message Send Error", errors.Errorf("received error response code: %s", code))
+			return status, nil
+		}
+	}
+
+	status.SetStatus(courier.MsgWired)
+	return status, nil
+}
+
+// parseResponseCode pulls the response code out of an M3Tech response, which is a JSON array such as
+// [{"Response":"0"}] that may or may not be wrapped in an XML string element
+func parseResponseCode(body []byte) (string, error) {
+	start := bytes.IndexByte(body, '[')
+	end := bytes.LastIndexByte(body, ']')
+	if start < 0 || end < start {
+		return "", errors.Errorf("unable to find response in body")
+	}
+
+	code, err := jsonparser.GetString(body[start:end+1], "[0]", "Response")
+	if err != nil {
+		return "", errors.Errorf("unable to parse response code from body")
+	}
+	return code, nil
+}
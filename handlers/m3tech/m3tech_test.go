@@ -0,0 +1,107 @@
+package m3tech
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "M3", "2020", "PK", nil),
+}
+
+var (
+	receiveURL = "/c/m3/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/received/"
+
+	receiveValid  = receiveURL + "?from=+923161909799&text=hello+world"
+	receiveNoFrom = receiveURL + "?text=hello+world"
+	receiveInBody = "from=%2B923161909799&text=hello+body"
+)
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid", URL: receiveValid, Data: " ", Status: 200, Response: "Accepted",
+		Text: Sp("hello world"), URN: Sp("tel:+923161909799")},
+	{Label: "Receive Form Body", URL: receiveURL, Data: receiveInBody, Status: 200, Response: "Accepted",
+		Text: Sp("hello body"), URN: Sp("tel:+923161909799")},
+	{Label: "Receive Missing From", URL: receiveNoFrom, Data: " ", Status: 400, Response: "field 'from' required"},
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	sendURL = server.URL
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "tel:+923161909799",
+		Status:       "W",
+		ResponseBody: `[{"Response":"0"}]`, ResponseStatus: 200,
+		URLParams: map[string]string{"AuthKey": "m3-Tech", "UserId": "Username", "Password": "Password", "MobileNo": "923161909799",
+			"MsgId": "10", "SMS": "Simple Message", "MsgHeader": "2020", "MsgType": "0", "HandsetPort": "0", "SMSChannel": "0", "Telco": "0"},
+		SendPrep: setSendURL},
+	{Label: "XML Wrapped Response",
+		Text: "Simple Message", URN: "tel:+923161909799",
+		Status:       "W",
+		ResponseBody: `<?xml version="1.0" encoding="utf-8"?><string xmlns="http://tempuri.org/">[{"Response":"0"}]</string>`, ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Unicode Send",
+		Text: "☺", URN: "tel:+923161909799",
+		Status:       "W",
+		ResponseBody: `[{"Response":"0"}]`, ResponseStatus: 200,
+		URLParams: map[string]string{"SMS": "☺", "MsgType": "7"},
+		SendPrep:  setSendURL},
+	{Label: "Smart Encoding",
+		Text: "Fancy “Smart” Quotes", URN: "tel:+923161909799",
+		Status:       "W",
+		ResponseBody: `[{"Response":"0"}]`, ResponseStatus: 200,
+		URLParams: map[string]string{"SMS": `Fancy "Smart" Quotes`, "MsgType": "0"},
+		SendPrep:  setSendURL},
+	{Label: "Long Unicode Send",
+		Text:         "☺ This is a unicode message longer than seventy characters, so it has to be split into two parts",
+		URN:          "tel:+923161909799",
+		Status:       "W",
+		ResponseBody: `[{"Response":"0"}]`, ResponseStatus: 200,
+		URLParams: map[string]string{"SMS": "to be split into two parts", "MsgType": "7"},
+		SendPrep:  setSendURL},
+	{Label: "Send Attachment",
+		Text: "My pic!", URN: "tel:+923161909799", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status:       "W",
+		ResponseBody: `[{"Response":"0"}]`, ResponseStatus: 200,
+		URLParams: map[string]string{"SMS": "My pic!\nhttps://foo.bar/image.jpg"},
+		SendPrep:  setSendURL},
+	{Label: "Error Code",
+		Text: "Error Message", URN: "tel:+923161909799",
+		Status:       "E",
+		ResponseBody: `[{"Response":"101"}]`, ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Invalid Response",
+		Text: "Error Message", URN: "tel:+923161909799",
+		Status:       "E",
+		ResponseBody: `Server Error`, ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Error Sending",
+		Text: "Error Message", URN: "tel:+923161909799",
+		Status:       "E",
+		ResponseBody: `[{"Response":"0"}]`, ResponseStatus: 403,
+		SendPrep: setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "M3", "2020", "PK",
+		map[string]interface{}{
+			courier.ConfigUsername: "Username",
+			courier.ConfigPassword: "Password",
+		})
+
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+}
@@ -3,3 +3,234 @@ package macrokiosk
 /*
 GET /handlers/macrokiosk/receive/uuid?from=60124361111&text=Msg&time=2017-05-03%2010:37:40&msgid=26645777571&shortcode=62000&telcoid=4
 */
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/gsm7"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const configSenderID = "macrokiosk_sender_id"
+const configServiceID = "macrokiosk_service_id"
+
+// Macrokiosk expects this body in response to every callback
+const ackResponse = "-1"
+
+const (
+	typeASCII   = "0"
+	typeUnicode = "5"
+)
+
+var sendURL = "https://www.etracker.cc/bulksms/send"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Macrokiosk handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("MK"), "Macrokiosk")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddReceiveMsgRoute(h, "GET", "receive", h.ReceiveMessage)
+	if err != nil {
+		return err
+	}
+
+	return s.AddUpdateStatusRoute(h, "GET", "status", h.StatusMessage)
+}
+
+type mkMessage struct {
+	MsgID     string `validate:"required" name:"msgid"`
+	From      string `validate:"required" name:"from"`
+	Shortcode string `validate:"required" name:"shortcode"`
+	TelcoID   string `name:"telcoid"`
+	Text      string `name:"text"`
+	Time      string `validate:"required" name:"time"`
+}
+
+type mkStatus struct {
+	MsgID  string `validate:"required" name:"msgid"`
+	Status string `validate:"required" name:"status"`
+}
+
+var mkStatusMapping = map[string]courier.MsgStatusValue{
+	"ACCEPTED":    courier.MsgSent,
+	"PROCESSING":  courier.MsgSent,
+	"DELIVERED":   courier.MsgDelivered,
+	"UNDELIVERED": courier.MsgFailed,
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	// get our params
+	mkMsg := &mkMessage{}
+	err := handlers.DecodeAndValidateQueryParams(mkMsg, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// messages can be sent to longcodes or keyword aliases of our shortcode, we accept those but note which
+	if strings.TrimPrefix(mkMsg.Shortcode, "+") != strings.TrimPrefix(channel.Address(), "+") {
+		logrus.WithField("channel_uuid", channel.UUID()).WithField("shortcode", mkMsg.Shortcode).WithField("telcoid", mkMsg.TelcoID).Info("received message for shortcode other than channel address")
+	}
+
+	// times are in Malaysian time
+	loc, err := time.LoadLocation("Asia/Kuala_Lumpur")
+	if err != nil {
+		return nil, err
+	}
+	date, err := time.ParseInLocation("2006-01-02 15:04:05", mkMsg.Time, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time: %s", mkMsg.Time)
+	}
+
+	// create our URN
+	urn := courier.NewTelURNForChannel(mkMsg.From, channel)
+
+	// build our msg
+	msg := h.Backend().NewIncomingMsg(channel, urn, mkMsg.Text).WithExternalID(mkMsg.MsgID).WithReceivedOn(date.UTC())
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, writeAck(w)
+}
+
+// StatusMessage is our HTTP handler function for status updates
+func (h *handler) StatusMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.MsgStatus, error) {
+	// get our params
+	mkStatus := &mkStatus{}
+	err := handlers.DecodeAndValidateQueryParams(mkStatus, r)
+	if err != nil {
+		return nil, err
+	}
+
+	msgStatus, found := mkStatusMapping[strings.ToUpper(mkStatus.Status)]
+	if !found {
+		return nil, fmt.Errorf("unknown status '%s', must be one of 'ACCEPTED', 'PROCESSING', 'DELIVERED' or 'UNDELIVERED'", mkStatus.Status)
+	}
+
+	// write our status
+	status := h.Backend().NewMsgStatusForExternalID(channel, mkStatus.MsgID, msgStatus)
+	err = h.Backend().WriteMsgStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.MsgStatus{status}, writeAck(w)
+}
+
+type mkOutgoing struct {
+	User   string `json:"user"`
+	Pass   string `json:"pass"`
+	To     string `json:"to"`
+	Text   string `json:"text"`
+	From   string `json:"from"`
+	ServID string `json:"servid"`
+	Type   string `json:"type"`
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
+	if username == "" {
+		return nil, fmt.Errorf("no username set for MK channel")
+	}
+
+	password := msg.Channel().StringConfigForKey(courier.ConfigPassword, "")
+	if password == "" {
+		return nil, fmt.Errorf("no password set for MK channel")
+	}
+
+	serviceID := msg.Channel().StringConfigForKey(configServiceID, "")
+	if serviceID == "" {
+		return nil, fmt.Errorf("no service id set for MK channel")
+	}
+
+	// we send from our sender id if we have one, otherwise from our address
+	senderID := msg.Channel().StringConfigForKey(configSenderID, msg.Channel().Address())
+
+	payload := &mkOutgoing{
+		User:   username,
+		Pass:   password,
+		To:     strings.TrimPrefix(msg.URN().Path(), "+"),
+		From:   senderID,
+		ServID: serviceID,
+	}
+
+	// send as ASCII if we can, otherwise as unicode which Macrokiosk wants as hex encoded UCS2
+	text := gsm7.ReplaceNonGSM7Chars(courier.GetTextAndAttachments(msg))
+	if isASCII(text) {
+		payload.Type = typeASCII
+		payload.Text = text
+	} else {
+		payload.Type = typeUnicode
+		payload.Text = strings.ToUpper(hex.EncodeToString(utils.EncodeUTF16BE(courier.GetTextAndAttachments(msg))))
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	rr, err := utils.MakeHTTPRequest(req)
+
+	// record our status and log
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+	status.AddLog(log)
+	if err != nil {
+		return status, nil
+	}
+
+	externalID, err := jsonparser.GetString(rr.Body, "MsgID")
+	if err != nil || externalID == "" {
+		log.WithError("Message Send Error", errors.Errorf("unable to get MsgID from body"))
+		return status, nil
+	}
+
+	status.SetStatus(courier.MsgWired)
+	status.SetExternalID(externalID)
+
+	return status, nil
+}
+
+// isASCII returns whether the passed in text only contains ASCII characters
+func isASCII(text string) bool {
+	for _, r := range text {
+		if r > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+func writeAck(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	_, err := fmt.Fprint(w, ackResponse)
+	return err
+}
@@ -0,0 +1,126 @@
+package macrokiosk
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "MK", "62000", "MY", nil),
+}
+
+var (
+	receiveURL = "/c/mk/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+	statusURL  = "/c/mk/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status/"
+
+	receiveValid        = receiveURL + "?from=60124361111&text=Msg&time=2017-05-03%2010:37:40&msgid=26645777571&shortcode=62000&telcoid=4"
+	receiveMissingFrom  = receiveURL + "?text=Msg&time=2017-05-03%2010:37:40&msgid=26645777571&shortcode=62000&telcoid=4"
+	receiveInvalidTime  = receiveURL + "?from=60124361111&text=Msg&time=20170503&msgid=26645777571&shortcode=62000&telcoid=4"
+	receiveNoShortcode  = receiveURL + "?from=60124361111&text=Msg&time=2017-05-03%2010:37:40&msgid=26645777571&telcoid=4"
+	receiveWrongCode    = receiveURL + "?from=60124361111&text=Msg&time=2017-05-03%2010:37:40&msgid=26645777571&shortcode=63000&telcoid=4"
+	statusDelivered     = statusURL + "?msgid=id1234&status=DELIVERED"
+	statusAccepted      = statusURL + "?msgid=id1234&status=ACCEPTED"
+	statusUndelivered   = statusURL + "?msgid=id1234&status=UNDELIVERED"
+	statusUnknown       = statusURL + "?msgid=id1234&status=UNKNOWN"
+	statusMissingStatus = statusURL + "?msgid=id1234"
+)
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid", URL: receiveValid, Status: 200, Response: "-1",
+		Text: Sp("Msg"), URN: Sp("tel:+60124361111"), External: Sp("26645777571"), Date: Tp(time.Date(2017, 5, 3, 2, 37, 40, 0, time.UTC))},
+	{Label: "Receive Missing From", URL: receiveMissingFrom, Status: 400, Response: "field 'from' required"},
+	{Label: "Receive Invalid Time", URL: receiveInvalidTime, Status: 400, Response: "invalid time: 20170503"},
+
+	{Label: "Receive Missing Shortcode", URL: receiveNoShortcode, Status: 400, Response: "field 'shortcode' required"},
+	{Label: "Receive Other Shortcode", URL: receiveWrongCode, Status: 200, Response: "-1",
+		Text: Sp("Msg"), URN: Sp("tel:+60124361111"), External: Sp("26645777571")},
+
+	{Label: "Status Delivered", URL: statusDelivered, Status: 200, Response: "-1"},
+	{Label: "Status Accepted", URL: statusAccepted, Status: 200, Response: "-1"},
+	{Label: "Status Undelivered", URL: statusUndelivered, Status: 200, Response: "-1"},
+	{Label: "Status Unknown", URL: statusUnknown, Status: 400, Response: "unknown status 'UNKNOWN'"},
+	{Label: "Status Missing Status", URL: statusMissingStatus, Status: 400, Response: "field 'status' required"},
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	sendURL = server.URL
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "tel:+60124361111",
+		Status: "W", ExternalID: "abc123",
+		ResponseBody: `{"MsgID":"abc123","Status":"200"}`, ResponseStatus: 200,
+		Headers:     map[string]string{"Content-Type": "application/json"},
+		RequestBody: `{"user":"Username","pass":"Password","to":"60124361111","text":"Simple Message","from":"macro","servid":"service-id","type":"0"}`,
+		SendPrep:    setSendURL},
+	{Label: "Unicode Send",
+		Text: "Hi ☺", URN: "tel:+60124361111",
+		Status: "W", ExternalID: "abc123",
+		ResponseBody: `{"MsgID":"abc123","Status":"200"}`, ResponseStatus: 200,
+		RequestBody: `{"user":"Username","pass":"Password","to":"60124361111","text":"004800690020263A","from":"macro","servid":"service-id","type":"5"}`,
+		SendPrep:    setSendURL},
+	{Label: "Non ASCII GSM7 Send",
+		Text: "Café", URN: "tel:+60124361111",
+		Status: "W", ExternalID: "abc123",
+		ResponseBody: `{"MsgID":"abc123","Status":"200"}`, ResponseStatus: 200,
+		RequestBody: `{"user":"Username","pass":"Password","to":"60124361111","text":"00430061006600E9","from":"macro","servid":"service-id","type":"5"}`,
+		SendPrep:    setSendURL},
+	{Label: "Send Attachment",
+		Text: "My pic!", URN: "tel:+60124361111", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status: "W", ExternalID: "abc123",
+		ResponseBody: `{"MsgID":"abc123","Status":"200"}`, ResponseStatus: 200,
+		RequestBody: `{"user":"Username","pass":"Password","to":"60124361111","text":"My pic!\nhttps://foo.bar/image.jpg","from":"macro","servid":"service-id","type":"0"}`,
+		SendPrep:    setSendURL},
+	{Label: "No External ID",
+		Text: "No External ID", URN: "tel:+60124361111",
+		Status:       "E",
+		ResponseBody: `{"Status":"200"}`, ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Error Sending",
+		Text: "Error Message", URN: "tel:+60124361111",
+		Status:       "E",
+		ResponseBody: `{"Status":"403"}`, ResponseStatus: 403,
+		SendPrep: setSendURL},
+}
+
+var addressSendTestCases = []ChannelSendTestCase{
+	{Label: "Address As Sender",
+		Text: "Simple Message", URN: "tel:+60124361111",
+		Status: "W", ExternalID: "abc123",
+		ResponseBody: `{"MsgID":"abc123","Status":"200"}`, ResponseStatus: 200,
+		RequestBody: `{"user":"Username","pass":"Password","to":"60124361111","text":"Simple Message","from":"62000","servid":"service-id","type":"0"}`,
+		SendPrep:    setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "MK", "62000", "MY",
+		map[string]interface{}{
+			courier.ConfigUsername: "Username",
+			courier.ConfigPassword: "Password",
+			configSenderID:         "macro",
+			configServiceID:        "service-id",
+		})
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+
+	var addressChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "MK", "62000", "MY",
+		map[string]interface{}{
+			courier.ConfigUsername: "Username",
+			courier.ConfigPassword: "Password",
+			configServiceID:        "service-id",
+		})
+	RunChannelSendTestCases(t, addressChannel, NewHandler(), addressSendTestCases)
+}
@@ -1,3 +1,204 @@
 package mblox
 
-/* no logs */
+/*
+POST /c/mb/uuid/receive/
+{"id":"OzQ5UqIOdoY8","from":"12067799294","to":"18444651185","body":"MO","type":"mo_text","received_at":"2016-03-30T19:33:06.643Z"}
+
+POST /c/mb/uuid/receive/
+{"batch_id":"4nQCc1T6Dg-R-zHX","status":"Delivered","code":0,"recipient":"12067799294","type":"recipient_delivery_report_sms","at":"2016-03-30T19:33:06.643Z"}
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	typeMOText         = "mo_text"
+	typeDeliveryReport = "recipient_delivery_report_sms"
+)
+
+var sendURL = "https://api.mblox.com/xms/v1/%s/batches"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Mblox handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("MB"), "Mblox")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+
+	// Mblox posts both incoming messages and delivery reports to the same URL
+	return s.AddReceiveMsgAndStatusRoute(h, "POST", "receive", h.ReceiveEvent)
+}
+
+// mbEvent is either an incoming message or a delivery report, depending on its type
+type mbEvent struct {
+	Type string `json:"type"  validate:"required"`
+
+	// delivery report fields
+	BatchID   string `json:"batch_id"`
+	Status    string `json:"status"`
+	Recipient string `json:"recipient"`
+
+	// incoming message fields
+	ID         string `json:"id"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Body       string `json:"body"`
+	ReceivedAt string `json:"received_at"`
+}
+
+var mbStatusMapping = map[string]courier.MsgStatusValue{
+	"Queued":     courier.MsgSent,
+	"Dispatched": courier.MsgSent,
+	"Delivered":  courier.MsgDelivered,
+	"Aborted":    courier.MsgFailed,
+	"Rejected":   courier.MsgFailed,
+	"Failed":     courier.MsgFailed,
+	"Expired":    courier.MsgFailed,
+}
+
+// ReceiveEvent is our HTTP handler function for incoming messages and delivery reports
+func (h *handler) ReceiveEvent(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, []courier.MsgStatus, error) {
+	payload := &mbEvent{}
+	err := handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch payload.Type {
+	case typeDeliveryReport:
+		statuses, err := h.receiveStatus(channel, w, r, payload)
+		return nil, statuses, err
+
+	case typeMOText:
+		msgs, err := h.receiveMessage(channel, w, r, payload)
+		return msgs, nil, err
+
+	default:
+		return nil, nil, courier.WriteIgnored(w, r, fmt.Sprintf("Ignoring request, unknown type '%s'", payload.Type))
+	}
+}
+
+// receiveStatus writes the status for the passed in delivery report
+func (h *handler) receiveStatus(channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *mbEvent) ([]courier.MsgStatus, error) {
+	if payload.BatchID == "" || payload.Status == "" {
+		return nil, fmt.Errorf("missing one of 'batch_id' or 'status' in request body")
+	}
+
+	msgStatus, found := mbStatusMapping[payload.Status]
+	if !found {
+		return nil, fmt.Errorf("unknown status '%s'", payload.Status)
+	}
+
+	// we send each message as its own batch, so the batch id is our external id
+	status := h.Backend().NewMsgStatusForExternalID(channel, payload.BatchID, msgStatus)
+	err := h.Backend().WriteMsgStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.MsgStatus{status}, courier.WriteStatusSuccess(w, r, status)
+}
+
+// receiveMessage writes the passed in incoming message
+func (h *handler) receiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *mbEvent) ([]courier.Msg, error) {
+	if payload.ID == "" || payload.From == "" || payload.To == "" || payload.ReceivedAt == "" {
+		return nil, fmt.Errorf("missing one of 'id', 'from', 'to' or 'received_at' in request body")
+	}
+
+	date, err := time.Parse(time.RFC3339Nano, payload.ReceivedAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid received_at: %s", payload.ReceivedAt)
+	}
+
+	// create our URN
+	urn := courier.NewTelURNForChannel(payload.From, channel)
+
+	// build our msg
+	msg := h.Backend().NewIncomingMsg(channel, urn, payload.Body).WithExternalID(payload.ID).WithReceivedOn(date.UTC())
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, courier.WriteReceiveSuccess(w, r, msg)
+}
+
+type mbOutgoing struct {
+	From           string   `json:"from"`
+	To             []string `json:"to"`
+	Body           string   `json:"body"`
+	DeliveryReport string   `json:"delivery_report"`
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
+	if username == "" {
+		return nil, fmt.Errorf("no username set for MB channel")
+	}
+
+	password := msg.Channel().StringConfigForKey(courier.ConfigPassword, "")
+	if password == "" {
+		return nil, fmt.Errorf("no password set for MB channel")
+	}
+
+	payload := &mbOutgoing{
+		From:           strings.TrimPrefix(msg.Channel().Address(), "+"),
+		To:             []string{strings.TrimPrefix(msg.URN().Path(), "+")},
+		Body:           courier.GetTextAndAttachments(msg),
+		DeliveryReport: "per_recipient",
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(sendURL, username), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", password))
+	rr, err := utils.MakeHTTPRequest(req)
+
+	// record our status and log
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+	status.AddLog(log)
+	if err != nil {
+		return status, nil
+	}
+
+	// the id of our batch is what delivery reports will reference
+	externalID, err := jsonparser.GetString(rr.Body, "id")
+	if err != nil || externalID == "" {
+		log.WithError("Message Send Error", errors.Errorf("unable to get id from body"))
+		return status, nil
+	}
+
+	status.SetStatus(courier.MsgWired)
+	status.SetExternalID(externalID)
+	return status, nil
+}
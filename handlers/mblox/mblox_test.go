@@ -0,0 +1,148 @@
+package mblox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/config"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "MB", "2020", "US", nil),
+}
+
+var receiveURL = "/c/mb/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+
+var validMsg = `{
+	"id": "OzQ5UqIOdoY8",
+	"from": "12067799294",
+	"to": "18444651185",
+	"body": "Hello World",
+	"type": "mo_text",
+	"received_at": "2016-03-30T19:33:06.643Z"
+}`
+
+var invalidDate = `{
+	"id": "OzQ5UqIOdoY8",
+	"from": "12067799294",
+	"to": "18444651185",
+	"body": "Hello World",
+	"type": "mo_text",
+	"received_at": "20160330"
+}`
+
+var missingFrom = `{
+	"id": "OzQ5UqIOdoY8",
+	"to": "18444651185",
+	"body": "Hello World",
+	"type": "mo_text",
+	"received_at": "2016-03-30T19:33:06.643Z"
+}`
+
+var statusDelivered = `{
+	"batch_id": "12345",
+	"status": "Delivered",
+	"code": 0,
+	"recipient": "12067799294",
+	"type": "recipient_delivery_report_sms",
+	"at": "2016-03-30T19:33:06.643Z"
+}`
+
+var statusDispatched = `{"batch_id": "12345", "status": "Dispatched", "code": 0, "recipient": "12067799294", "type": "recipient_delivery_report_sms"}`
+var statusRejected = `{"batch_id": "12345", "status": "Rejected", "code": 400, "recipient": "12067799294", "type": "recipient_delivery_report_sms"}`
+var statusUnknown = `{"batch_id": "12345", "status": "Unknown", "code": 0, "recipient": "12067799294", "type": "recipient_delivery_report_sms"}`
+var statusMissingBatch = `{"status": "Delivered", "code": 0, "recipient": "12067799294", "type": "recipient_delivery_report_sms"}`
+var unknownType = `{"batch_id": "12345", "status": "Delivered", "type": "recipient_delivery_report_mms"}`
+var missingType = `{"batch_id": "12345", "status": "Delivered"}`
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid", URL: receiveURL, Data: validMsg, Status: 200, Response: "Message Accepted",
+		Text: Sp("Hello World"), URN: Sp("tel:+12067799294"), External: Sp("OzQ5UqIOdoY8"), Date: Tp(time.Date(2016, 3, 30, 19, 33, 06, 643000000, time.UTC))},
+	{Label: "Receive Invalid Date", URL: receiveURL, Data: invalidDate, Status: 400, Response: "invalid received_at: 20160330"},
+	{Label: "Receive Missing From", URL: receiveURL, Data: missingFrom, Status: 400, Response: "missing one of 'id', 'from', 'to' or 'received_at'"},
+
+	{Label: "Status Delivered", URL: receiveURL, Data: statusDelivered, Status: 200, Response: `"status":"D"`},
+	{Label: "Status Dispatched", URL: receiveURL, Data: statusDispatched, Status: 200, Response: `"status":"S"`},
+	{Label: "Status Rejected", URL: receiveURL, Data: statusRejected, Status: 200, Response: `"status":"F"`},
+	{Label: "Status Unknown", URL: receiveURL, Data: statusUnknown, Status: 400, Response: "unknown status 'Unknown'"},
+	{Label: "Status Missing Batch ID", URL: receiveURL, Data: statusMissingBatch, Status: 400, Response: "missing one of 'batch_id' or 'status'"},
+
+	{Label: "Unknown Type", URL: receiveURL, Data: unknownType, Status: 200, Response: "Ignoring request, unknown type 'recipient_delivery_report_mms'"},
+	{Label: "Missing Type", URL: receiveURL, Data: missingType, Status: 400, Response: "Field validation for 'Type' failed"},
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func TestStatusEvents(t *testing.T) {
+	mb := courier.NewMockBackend()
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	// delivery reports should be returned as statuses so they aren't logged as failed receives
+	r := httptest.NewRequest(http.MethodPost, receiveURL, strings.NewReader(statusDelivered))
+	msgs, statuses, err := h.ReceiveEvent(testChannels[0], httptest.NewRecorder(), r)
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+	require.Equal(t, 1, len(statuses))
+	assert.Equal(t, "12345", statuses[0].ExternalID())
+	assert.Equal(t, courier.MsgDelivered, statuses[0].Status())
+
+	status, err := mb.GetLastMsgStatus()
+	require.NoError(t, err)
+	assert.Equal(t, statuses[0], status)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	sendURL = server.URL + "?username=%s"
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "tel:+12067799294",
+		Status: "W", ExternalID: "OzYDlvf3SQVc",
+		ResponseBody: `{"id":"OzYDlvf3SQVc"}`, ResponseStatus: 201,
+		URLParams:   map[string]string{"username": "Username"},
+		Headers:     map[string]string{"Content-Type": "application/json", "Authorization": "Bearer Password"},
+		RequestBody: `{"from":"2020","to":["12067799294"],"body":"Simple Message","delivery_report":"per_recipient"}`,
+		SendPrep:    setSendURL},
+	{Label: "Send Attachment",
+		Text: "My pic!", URN: "tel:+12067799294", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status: "W", ExternalID: "OzYDlvf3SQVc",
+		ResponseBody: `{"id":"OzYDlvf3SQVc"}`, ResponseStatus: 201,
+		RequestBody: `{"from":"2020","to":["12067799294"],"body":"My pic!\nhttps://foo.bar/image.jpg","delivery_report":"per_recipient"}`,
+		SendPrep:    setSendURL},
+	{Label: "No External ID",
+		Text: "No External ID", URN: "tel:+12067799294",
+		Status:       "E",
+		ResponseBody: `{}`, ResponseStatus: 201,
+		SendPrep: setSendURL},
+	{Label: "Error Sending",
+		Text: "Error Message", URN: "tel:+12067799294",
+		Status:       "E",
+		ResponseBody: `{"code":"unauthorized","text":"Invalid token"}`, ResponseStatus: 401,
+		SendPrep: setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "MB", "2020", "US",
+		map[string]interface{}{
+			courier.ConfigUsername: "Username",
+			courier.ConfigPassword: "Password",
+		})
+
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+}
@@ -4,3 +4,373 @@ package nexmo
 GET /handlers/nexmo/status/uuid/?msisdn=4527631111&to=Tak&network-code=23820&messageId=0C0000002EEBDA56&price=0.01820000&status=delivered&scts=1705021324&err-code=0&message-timestamp=2017-05-02+11%3A24%3A03
 GET /handlers/nexmo/receive/uuid/?msisdn=15862151111&to=12812581111&messageId=0B0000004B65F62F&text=Msg&type=text&keyword=Keyword&message-timestamp=2017-05-01+21%3A52%3A49
 */
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/gsm7"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const configAPISecret = "api_secret"
+
+// how long we hold on to the parts of a concatenated message waiting for the rest to arrive
+const concatTimeout = time.Minute * 10
+
+var sendURL = "https://rest.nexmo.com/sms/json"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+	parts *partStore
+}
+
+// NewHandler returns a new Nexmo handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("NX"), "Nexmo"), newPartStore()}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+
+	// periodically write out any concatenated messages whose remaining parts never arrived, and write out everything
+	// we're still waiting on when we stop so that no parts are lost
+	s.WaitGroup().Add(1)
+	go func() {
+		defer s.WaitGroup().Done()
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				h.writePartialMsgs(h.parts.expire(time.Now()))
+			case <-s.StopChan():
+				h.writePartialMsgs(h.parts.flush())
+				return
+			}
+		}
+	}()
+
+	err := s.AddReceiveMsgRoute(h, "GET", "receive", h.ReceiveMessage)
+	if err != nil {
+		return err
+	}
+
+	return s.AddUpdateStatusRoute(h, "GET", "status", h.StatusMessage)
+}
+
+type nxMessage struct {
+	From        string `validate:"required" name:"msisdn"`
+	To          string `validate:"required" name:"to"`
+	MessageID   string `validate:"required" name:"messageId"`
+	Text        string `name:"text"`
+	Timestamp   string `name:"message-timestamp"`
+	Concat      bool   `name:"concat"`
+	ConcatRef   string `name:"concat-ref"`
+	ConcatTotal int    `name:"concat-total"`
+	ConcatPart  int    `name:"concat-part"`
+}
+
+type nxStatus struct {
+	MessageID string `validate:"required" name:"messageId"`
+	Status    string `validate:"required" name:"status"`
+	ErrCode   int    `name:"err-code"`
+	ClientRef string `name:"client-ref"`
+}
+
+var nxStatusMapping = map[string]courier.MsgStatusValue{
+	"accepted":  courier.MsgSent,
+	"buffered":  courier.MsgSent,
+	"delivered": courier.MsgDelivered,
+	"expired":   courier.MsgFailed,
+	"failed":    courier.MsgFailed,
+	"rejected":  courier.MsgFailed,
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	// get our params
+	nxMsg := &nxMessage{}
+	err := handlers.DecodeAndValidateQueryParams(nxMsg, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// create our date from the timestamp, this is in UTC
+	date := time.Now().UTC()
+	if nxMsg.Timestamp != "" {
+		date, err = time.Parse("2006-01-02 15:04:05", nxMsg.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message-timestamp: %s", nxMsg.Timestamp)
+		}
+	}
+
+	// create our URN
+	urn := courier.NewTelURNForChannel(nxMsg.From, channel)
+
+	text := nxMsg.Text
+	externalID := nxMsg.MessageID
+
+	// long messages arrive in parts, hold on to them until we have them all
+	if nxMsg.Concat {
+		if nxMsg.ConcatRef == "" || nxMsg.ConcatTotal < 1 || nxMsg.ConcatPart < 1 || nxMsg.ConcatPart > nxMsg.ConcatTotal {
+			return nil, fmt.Errorf("invalid concat parameters: ref '%s' part %d of %d", nxMsg.ConcatRef, nxMsg.ConcatPart, nxMsg.ConcatTotal)
+		}
+
+		key := fmt.Sprintf("%s:%s:%s", channel.UUID(), nxMsg.From, nxMsg.ConcatRef)
+		pending, complete := h.parts.add(key, channel, urn, date, nxMsg.ConcatPart, nxMsg.ConcatTotal, nxMsg.Text, nxMsg.MessageID)
+		if !complete {
+			return nil, courier.WriteIgnored(w, r, fmt.Sprintf("Stored part %d of %d, waiting for remaining parts", nxMsg.ConcatPart, nxMsg.ConcatTotal))
+		}
+		text, externalID = pending.text()
+	}
+
+	// build our msg
+	msg := h.Backend().NewIncomingMsg(channel, urn, text).WithExternalID(externalID).WithReceivedOn(date)
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, courier.WriteReceiveSuccess(w, r, msg)
+}
+
+// writePartialMsgs writes the text we did receive for the passed in concatenated messages which won't be getting
+// their remaining parts, rather than losing them
+func (h *handler) writePartialMsgs(partial []*pendingMsg) {
+	for _, pending := range partial {
+		text, externalID := pending.text()
+		msg := h.Backend().NewIncomingMsg(pending.channel, pending.urn, text).WithExternalID(externalID).WithReceivedOn(pending.receivedOn)
+
+		err := h.Backend().WriteMsg(msg)
+		if err != nil {
+			logrus.WithError(err).WithField("channel_uuid", pending.channel.UUID()).Error("error writing partial concatenated message")
+		}
+	}
+}
+
+// StatusMessage is our HTTP handler function for status updates
+func (h *handler) StatusMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.MsgStatus, error) {
+	// get our params
+	nxStatus := &nxStatus{}
+	err := handlers.DecodeAndValidateQueryParams(nxStatus, r)
+	if err != nil {
+		return nil, err
+	}
+
+	msgStatus, found := nxStatusMapping[nxStatus.Status]
+	if !found {
+		return nil, courier.WriteIgnored(w, r, fmt.Sprintf("Ignoring unknown status '%s'", nxStatus.Status))
+	}
+
+	// Nexmo gives each part of a long message its own id, so we correlate using the client ref we sent, which
+	// is our msg id, falling back to the message id for the first part
+	var status courier.MsgStatus
+	msgID, err := strconv.ParseInt(nxStatus.ClientRef, 10, 64)
+	if err == nil {
+		status = h.Backend().NewMsgStatusForID(channel, courier.NewMsgID(msgID), msgStatus)
+	} else {
+		status = h.Backend().NewMsgStatusForExternalID(channel, nxStatus.MessageID, msgStatus)
+	}
+
+	// write our status
+	err = h.Backend().WriteMsgStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.MsgStatus{status}, courier.WriteStatusSuccess(w, r, status)
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	apiKey := msg.Channel().StringConfigForKey(courier.ConfigAPIKey, "")
+	if apiKey == "" {
+		return nil, fmt.Errorf("no API key set for NX channel")
+	}
+
+	apiSecret := msg.Channel().StringConfigForKey(configAPISecret, "")
+	if apiSecret == "" {
+		return nil, fmt.Errorf("no API secret set for NX channel")
+	}
+
+	callbackURL := fmt.Sprintf("%s/c/nx/%s/status", h.Server().Config().BaseURL, msg.Channel().UUID())
+
+	// try to send as GSM7, falling back to unicode if we have to
+	text := gsm7.ReplaceNonGSM7Chars(courier.GetTextAndAttachments(msg))
+	textType := "text"
+	if !gsm7.IsGSM7(text) {
+		text = courier.GetTextAndAttachments(msg)
+		textType = "unicode"
+	}
+
+	// build our request, our msg id is passed as the client ref so it is included in all status reports
+	form := url.Values{
+		"api_key":           []string{apiKey},
+		"api_secret":        []string{apiSecret},
+		"from":              []string{strings.TrimPrefix(msg.Channel().Address(), "+")},
+		"to":                []string{strings.TrimPrefix(msg.URN().Path(), "+")},
+		"text":              []string{text},
+		"type":              []string{textType},
+		"status-report-req": []string{"1"},
+		"callback":          []string{callbackURL},
+		"client-ref":        []string{msg.ID().String()},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sendURL, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr, err := utils.MakeHTTPRequest(req)
+
+	// record our status and log
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+	status.AddLog(log)
+	if err != nil {
+		return status, nil
+	}
+
+	// Nexmo splits long messages and reports on each part, any part not being accepted is an error
+	externalID := ""
+	_, err = jsonparser.ArrayEach(rr.Body, func(value []byte, dataType jsonparser.ValueType, offset int, _ error) {
+		partStatus, _ := jsonparser.GetString(value, "status")
+		if partStatus != "0" {
+			errorText, _ := jsonparser.GetString(value, "error-text")
+			log.WithError("Message Send Error", errors.Errorf("received error status '%s' from Nexmo: %s", partStatus, errorText))
+			return
+		}
+		if externalID == "" {
+			externalID, _ = jsonparser.GetString(value, "message-id")
+		}
+	}, "messages")
+
+	if err != nil {
+		log.WithError("Message Send Error", errors.Errorf("unable to parse messages from response"))
+		return status, nil
+	}
+	if log.Error != "" || externalID == "" {
+		return status, nil
+	}
+
+	status.SetStatus(courier.MsgWired)
+	status.SetExternalID(externalID)
+
+	return status, nil
+}
+
+//-----------------------------------------------------------------------------
+// Concatenated message part storage
+//-----------------------------------------------------------------------------
+
+// partStore holds the parts of concatenated incoming messages until they have all arrived. Note that parts are held
+// in memory, so all the parts of a message must be received by the same courier instance.
+type partStore struct {
+	mutex   sync.Mutex
+	pending map[string]*pendingMsg
+}
+
+type pendingMsg struct {
+	channel    courier.Channel
+	urn        courier.URN
+	receivedOn time.Time
+	total      int
+	parts      map[int]string
+	ids        map[int]string
+	createdOn  time.Time
+}
+
+func newPartStore() *partStore {
+	return &partStore{pending: make(map[string]*pendingMsg)}
+}
+
+// add stores the passed in part, returning the pending message if all its parts are now present
+func (s *partStore) add(key string, channel courier.Channel, urn courier.URN, receivedOn time.Time, part int, total int, text string, id string) (*pendingMsg, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pending, found := s.pending[key]
+	if !found {
+		pending = &pendingMsg{
+			channel:    channel,
+			urn:        urn,
+			receivedOn: receivedOn,
+			total:      total,
+			parts:      make(map[int]string),
+			ids:        make(map[int]string),
+			createdOn:  time.Now(),
+		}
+		s.pending[key] = pending
+	}
+	pending.parts[part] = text
+	pending.ids[part] = id
+
+	if len(pending.parts) < pending.total {
+		return nil, false
+	}
+
+	delete(s.pending, key)
+	return pending, true
+}
+
+// expire removes and returns all the messages whose parts haven't all arrived before our timeout
+func (s *partStore) expire(now time.Time) []*pendingMsg {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	expired := make([]*pendingMsg, 0)
+	for k, p := range s.pending {
+		if now.Sub(p.createdOn) > concatTimeout {
+			delete(s.pending, k)
+			expired = append(expired, p)
+		}
+	}
+	return expired
+}
+
+// flush removes and returns all the messages we are still waiting on parts for
+func (s *partStore) flush() []*pendingMsg {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	flushed := make([]*pendingMsg, 0, len(s.pending))
+	for _, p := range s.pending {
+		flushed = append(flushed, p)
+	}
+	s.pending = make(map[string]*pendingMsg)
+	return flushed
+}
+
+// text returns the text of the parts we have, joined in order, and the id of the first of them
+func (p *pendingMsg) text() (string, string) {
+	numbers := make([]int, 0, len(p.parts))
+	for n := range p.parts {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	var buf bytes.Buffer
+	for _, n := range numbers {
+		buf.WriteString(p.parts[n])
+	}
+	return buf.String(), p.ids[numbers[0]]
+}
@@ -0,0 +1,180 @@
+package nexmo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/config"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "NX", "2020", "US", map[string]interface{}{
+		courier.ConfigAPIKey: "nexmo-api-key",
+		configAPISecret:      "nexmo-api-secret",
+	}),
+}
+
+var (
+	receiveURL = "/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+	statusURL  = "/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status/"
+
+	receiveValid     = receiveURL + "?msisdn=2349067554729&to=2020&messageId=external1&text=Join&type=text&message-timestamp=2017-05-01+21%3A52%3A49"
+	receiveMissingTo = receiveURL + "?msisdn=2349067554729&messageId=external1&text=Join"
+	receiveBadDate   = receiveURL + "?msisdn=2349067554729&to=2020&messageId=external1&text=Join&message-timestamp=20170501"
+
+	receivePart2   = receiveURL + "?msisdn=2349067554729&to=2020&messageId=part2&text=World&concat=true&concat-ref=ab&concat-total=2&concat-part=2"
+	receivePart1   = receiveURL + "?msisdn=2349067554729&to=2020&messageId=part1&text=Hello+&concat=true&concat-ref=ab&concat-total=2&concat-part=1"
+	receiveBadPart = receiveURL + "?msisdn=2349067554729&to=2020&messageId=part3&text=Oops&concat=true&concat-ref=ab&concat-total=2&concat-part=3"
+
+	statusDelivered = statusURL + "?msisdn=2349067554729&to=2020&messageId=external1&status=delivered&err-code=0"
+	statusFailed    = statusURL + "?msisdn=2349067554729&to=2020&messageId=external1&status=failed&err-code=6"
+	statusPart      = statusURL + "?msisdn=2349067554729&to=2020&messageId=external2&status=delivered&err-code=0&client-ref=12345"
+	statusUnknown   = statusURL + "?msisdn=2349067554729&to=2020&messageId=external1&status=unknown&err-code=99"
+	statusMissingID = statusURL + "?msisdn=2349067554729&to=2020&status=delivered"
+)
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid Message", URL: receiveValid, Status: 200, Response: "Accepted",
+		Text: Sp("Join"), URN: Sp("tel:+2349067554729"), External: Sp("external1"), Date: Tp(time.Date(2017, 5, 1, 21, 52, 49, 0, time.UTC))},
+	{Label: "Receive Missing To", URL: receiveMissingTo, Status: 400, Response: "field 'to' required"},
+	{Label: "Receive Invalid Date", URL: receiveBadDate, Status: 400, Response: "invalid message-timestamp"},
+	{Label: "Receive Concat Part", URL: receivePart2, Status: 200, Response: "Stored part 2 of 2"},
+	{Label: "Receive Concat Complete", URL: receivePart1, Status: 200, Response: "Accepted",
+		Text: Sp("Hello World"), URN: Sp("tel:+2349067554729"), External: Sp("part1")},
+	{Label: "Receive Concat Invalid Part", URL: receiveBadPart, Status: 400, Response: "invalid concat parameters"},
+
+	{Label: "Status Delivered", URL: statusDelivered, Status: 200, Response: `"status":"D"`},
+	{Label: "Status Failed", URL: statusFailed, Status: 200, Response: `"status":"F"`},
+	{Label: "Status Client Ref", URL: statusPart, Status: 200, Response: `"msg_id":12345`},
+	{Label: "Status Unknown", URL: statusUnknown, Status: 200, Response: "Ignoring unknown status"},
+	{Label: "Status Missing ID", URL: statusMissingID, Status: 400, Response: "field 'messageid' required"},
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func TestExpiredParts(t *testing.T) {
+	mb := courier.NewMockBackend()
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	// only the first part of our message arrives
+	msgs, err := h.ReceiveMessage(testChannels[0], httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, receivePart1, nil))
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+
+	// nothing written before our timeout
+	h.writePartialMsgs(h.parts.expire(time.Now()))
+	_, err = mb.GetLastQueueMsg()
+	assert.Error(t, err)
+
+	// but once we're past it, we write what we have
+	h.writePartialMsgs(h.parts.expire(time.Now().Add(concatTimeout + time.Second)))
+	msg, err := mb.GetLastQueueMsg()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello ", msg.Text())
+	assert.Equal(t, "part1", msg.ExternalID())
+	assert.Equal(t, courier.URN("tel:+2349067554729"), msg.URN())
+
+	// and they aren't written again
+	mb.ClearQueueMsgs()
+	h.writePartialMsgs(h.parts.expire(time.Now().Add(concatTimeout + time.Second)))
+	_, err = mb.GetLastQueueMsg()
+	assert.Error(t, err)
+}
+
+func TestStopWritesParts(t *testing.T) {
+	mb := courier.NewMockBackend()
+	s := courier.NewServer(config.NewTest(), mb)
+	h := NewHandler().(*handler)
+	h.Initialize(s)
+
+	msgs, err := h.ReceiveMessage(testChannels[0], httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, receivePart1, nil))
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+
+	// stopping writes out what we have rather than losing it
+	close(s.StopChan())
+	s.WaitGroup().Wait()
+
+	msg, err := mb.GetLastQueueMsg()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello ", msg.Text())
+	assert.Equal(t, "part1", msg.ExternalID())
+	assert.Empty(t, h.parts.flush())
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	sendURL = server.URL
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "tel:+250788383383",
+		Status: "W", ExternalID: "1002",
+		ResponseBody: `{"message-count":"1","messages":[{"status":"0","message-id":"1002"}]}`, ResponseStatus: 200,
+		PostParams: map[string]string{"text": "Simple Message", "to": "250788383383", "from": "2020", "type": "text",
+			"api_key": "nexmo-api-key", "api_secret": "nexmo-api-secret", "status-report-req": "1", "client-ref": "10",
+			"callback": "http://courier.test/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status"},
+		SendPrep: setSendURL},
+	{Label: "Unicode Send",
+		Text: "Unicode ☺", URN: "tel:+250788383383",
+		Status: "W", ExternalID: "1002",
+		ResponseBody: `{"message-count":"1","messages":[{"status":"0","message-id":"1002"}]}`, ResponseStatus: 200,
+		PostParams: map[string]string{"text": "Unicode ☺", "type": "unicode"},
+		SendPrep:   setSendURL},
+	{Label: "Smart Encoding",
+		Text: "Fancy “Smart” Quotes", URN: "tel:+250788383383",
+		Status: "W", ExternalID: "1002",
+		ResponseBody: `{"message-count":"1","messages":[{"status":"0","message-id":"1002"}]}`, ResponseStatus: 200,
+		PostParams: map[string]string{"text": `Fancy "Smart" Quotes`, "type": "text"},
+		SendPrep:   setSendURL},
+	{Label: "Multipart Send",
+		Text: "Long Message", URN: "tel:+250788383383",
+		Status: "W", ExternalID: "1002",
+		ResponseBody: `{"message-count":"2","messages":[{"status":"0","message-id":"1002"},{"status":"0","message-id":"1003"}]}`, ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Send Attachment",
+		Text: "My pic!", URN: "tel:+250788383383", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status: "W", ExternalID: "1002",
+		ResponseBody: `{"message-count":"1","messages":[{"status":"0","message-id":"1002"}]}`, ResponseStatus: 200,
+		PostParams: map[string]string{"text": "My pic!\nhttps://foo.bar/image.jpg"},
+		SendPrep:   setSendURL},
+	{Label: "Part Error",
+		Text: "Error Part", URN: "tel:+250788383383",
+		Status:       "E",
+		ResponseBody: `{"message-count":"2","messages":[{"status":"0","message-id":"1002"},{"status":"4","error-text":"Bad Credentials"}]}`, ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Invalid Body",
+		Text: "Error Message", URN: "tel:+250788383383",
+		Status:       "E",
+		ResponseBody: `not json`, ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Error Sending",
+		Text: "Error Message", URN: "tel:+250788383383",
+		Status:       "E",
+		ResponseBody: `Error`, ResponseStatus: 401,
+		SendPrep: setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "NX", "2020", "US",
+		map[string]interface{}{
+			courier.ConfigAPIKey: "nexmo-api-key",
+			configAPISecret:      "nexmo-api-secret",
+		})
+
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+}
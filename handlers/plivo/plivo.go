@@ -7,3 +7,258 @@ Status=delivered&From=13342031111&ParentMessageUUID=83876bdb-2033-4876-bfaf-0ff8
 POST /api/v1/plivo/receive/uuid
 To=4759440448&From=4795961111&TotalRate=0&Units=1&Text=Msg&TotalAmount=0&Type=sms&MessageUUID=7a242edc-2f57-11e7-98c9-06ab0bf64327
 */
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+const configPlivoAuthID = "PLIVO_AUTH_ID"
+const configPlivoAuthToken = "PLIVO_AUTH_TOKEN"
+
+const plivoSignatureHeader = "X-Plivo-Signature"
+
+var sendURL = "https://api.plivo.com/v1/Account"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Plivo handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("PL"), "Plivo")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddReceiveMsgRoute(h, "POST", "receive", h.ReceiveMessage)
+	if err != nil {
+		return err
+	}
+
+	return s.AddUpdateStatusRoute(h, "POST", "status", h.StatusMessage)
+}
+
+type plMessage struct {
+	MessageUUID string `validate:"required"`
+	From        string `validate:"required"`
+	To          string `validate:"required"`
+	Text        string
+}
+
+type plStatus struct {
+	MessageUUID       string `validate:"required"`
+	ParentMessageUUID string
+	Status            string `validate:"required"`
+	ErrorCode         string
+}
+
+var plStatusMapping = map[string]courier.MsgStatusValue{
+	"queued":      courier.MsgWired,
+	"sent":        courier.MsgSent,
+	"delivered":   courier.MsgDelivered,
+	"undelivered": courier.MsgFailed,
+	"failed":      courier.MsgFailed,
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	err := h.validateSignature(channel, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// get our params
+	plMsg := &plMessage{}
+	err = handlers.DecodeAndValidateForm(plMsg, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// create our URN
+	urn := courier.NewTelURNForChannel(plMsg.From, channel)
+
+	// build our msg
+	msg := h.Backend().NewIncomingMsg(channel, urn, plMsg.Text).WithExternalID(plMsg.MessageUUID)
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, courier.WriteReceiveSuccess(w, r, msg)
+}
+
+// StatusMessage is our HTTP handler function for status updates
+func (h *handler) StatusMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.MsgStatus, error) {
+	err := h.validateSignature(channel, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// get our params
+	plStatus := &plStatus{}
+	err = handlers.DecodeAndValidateForm(plStatus, r)
+	if err != nil {
+		return nil, err
+	}
+
+	msgStatus, found := plStatusMapping[plStatus.Status]
+	if !found {
+		return nil, fmt.Errorf("unknown status '%s', must be one of 'queued', 'sent', 'delivered', 'undelivered' or 'failed'", plStatus.Status)
+	}
+
+	// multipart messages report a status for each part, the uuid of the parent part is the one we saved as our
+	// external id. Other parts can only tell us that the message as a whole failed.
+	externalID := plStatus.MessageUUID
+	if plStatus.ParentMessageUUID != "" && plStatus.ParentMessageUUID != plStatus.MessageUUID {
+		if msgStatus != courier.MsgFailed {
+			return nil, courier.WriteIgnored(w, r, "Ignoring status for non-parent message part")
+		}
+		externalID = plStatus.ParentMessageUUID
+	}
+
+	// write our status
+	status := h.Backend().NewMsgStatusForExternalID(channel, externalID, msgStatus)
+	err = h.Backend().WriteMsgStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.MsgStatus{status}, courier.WriteStatusSuccess(w, r, status)
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	authID := msg.Channel().StringConfigForKey(configPlivoAuthID, "")
+	if authID == "" {
+		return nil, fmt.Errorf("missing auth id for PL channel")
+	}
+
+	authToken := msg.Channel().StringConfigForKey(configPlivoAuthToken, "")
+	if authToken == "" {
+		return nil, fmt.Errorf("missing auth token for PL channel")
+	}
+
+	// build our callback URL
+	callbackURL := fmt.Sprintf("%s/c/pl/%s/status", h.Server().Config().BaseURL, msg.Channel().UUID())
+
+	payload := &plOutgoing{
+		Source:      strings.TrimPrefix(msg.Channel().Address(), "+"),
+		Destination: strings.TrimPrefix(msg.URN().Path(), "+"),
+		Text:        courier.GetTextAndAttachments(msg),
+		URL:         callbackURL,
+		Method:      http.MethodPost,
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/%s/Message/", sendURL, authID), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(authID, authToken)
+	rr, err := utils.MakeHTTPRequest(req)
+
+	// record our status and log
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+	status.AddLog(log)
+	if err != nil {
+		return status, nil
+	}
+
+	// long messages are split into multiple parts, the first uuid is the one statuses are tracked against
+	externalID, err := jsonparser.GetString(rr.Body, "message_uuid", "[0]")
+	if err != nil {
+		log.WithError("Message Send Error", errors.Errorf("unable to get message_uuid from body"))
+		return status, nil
+	}
+
+	status.SetStatus(courier.MsgWired)
+	status.SetExternalID(externalID)
+
+	return status, nil
+}
+
+type plOutgoing struct {
+	Source      string `json:"src"`
+	Destination string `json:"dst"`
+	Text        string `json:"text"`
+	URL         string `json:"url"`
+	Method      string `json:"method"`
+}
+
+// see https://www.plivo.com/docs/xml/request/#validation
+func (h *handler) validateSignature(channel courier.Channel, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	authToken := channel.StringConfigForKey(configPlivoAuthToken, "")
+	if authToken == "" {
+		return fmt.Errorf("invalid or missing auth token in config")
+	}
+
+	actual := r.Header.Get(plivoSignatureHeader)
+	if actual == "" {
+		return fmt.Errorf("missing request signature")
+	}
+
+	url := fmt.Sprintf("%s%s", h.Server().Config().BaseURL, r.URL.RequestURI())
+	expected := plCalculateSignature(url, r.PostForm, authToken)
+
+	// compare signatures in way that isn't sensitive to a timing attack
+	if !hmac.Equal(expected, []byte(actual)) {
+		return fmt.Errorf("invalid request signature")
+	}
+	return nil
+}
+
+// see https://www.plivo.com/docs/xml/request/#validation
+func plCalculateSignature(url string, form url.Values, authToken string) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString(url)
+
+	keys := make(sort.StringSlice, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	keys.Sort()
+
+	for _, k := range keys {
+		buffer.WriteString(k)
+		for _, v := range form[k] {
+			buffer.WriteString(v)
+		}
+	}
+
+	// hash with SHA1
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write(buffer.Bytes())
+	hash := mac.Sum(nil)
+
+	// encode with Base64
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(hash)))
+	base64.StdEncoding.Encode(encoded, hash)
+
+	return encoded
+}
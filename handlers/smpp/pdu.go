@@ -0,0 +1,549 @@
+package smpp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/nyaruka/courier/gsm7"
+)
+
+// The subset of SMPP v3.4 we speak, see http://opensmpp.org/specs/SMPP_v3_4_Issue1_2.pdf
+const (
+	genericNack         uint32 = 0x80000000
+	bindTransceiver     uint32 = 0x00000009
+	bindTransceiverResp uint32 = 0x80000009
+	submitSM            uint32 = 0x00000004
+	submitSMResp        uint32 = 0x80000004
+	deliverSM           uint32 = 0x00000005
+	deliverSMResp       uint32 = 0x80000005
+	unbind              uint32 = 0x00000006
+	unbindResp          uint32 = 0x80000006
+	enquireLink         uint32 = 0x00000015
+	enquireLinkResp     uint32 = 0x80000015
+)
+
+var commandNames = map[uint32]string{
+	genericNack:         "generic_nack",
+	bindTransceiver:     "bind_transceiver",
+	bindTransceiverResp: "bind_transceiver_resp",
+	submitSM:            "submit_sm",
+	submitSMResp:        "submit_sm_resp",
+	deliverSM:           "deliver_sm",
+	deliverSMResp:       "deliver_sm_resp",
+	unbind:              "unbind",
+	unbindResp:          "unbind_resp",
+	enquireLink:         "enquire_link",
+	enquireLinkResp:     "enquire_link_resp",
+}
+
+// command statuses we send or need to recognize
+const (
+	statusOK              uint32 = 0x00000000
+	statusInvalidCommand  uint32 = 0x00000003
+	statusAlreadyBound    uint32 = 0x00000005
+	statusBindFailed      uint32 = 0x0000000D
+	statusThrottled       uint32 = 0x00000058
+	statusTemporaryAppErr uint32 = 0x00000064
+)
+
+// the version of the protocol we bind as
+const interfaceVersion = 0x34
+
+// type of number and numbering plan indicators for addresses
+const (
+	tonUnknown       byte = 0x00
+	tonInternational byte = 0x01
+	tonAlphanumeric  byte = 0x05
+	npiUnknown       byte = 0x00
+	npiISDN          byte = 0x01
+)
+
+// the data codings we send with and decode
+const (
+	codingDefault byte = 0x00
+	codingIA5     byte = 0x01
+	codingLatin1  byte = 0x03
+	codingUCS2    byte = 0x08
+)
+
+// esm_class bits
+const (
+	esmClassReceiptMask byte = 0x3C
+	esmClassReceipt     byte = 0x04
+	esmClassUDHI        byte = 0x40
+)
+
+// optional parameters we read from deliver_sm
+const (
+	tlvReceiptedMessageID uint16 = 0x001E
+	tlvMessagePayload     uint16 = 0x0424
+	tlvMessageState       uint16 = 0x0427
+)
+
+// we never expect a PDU bigger than this, anything that claims to be is garbage
+const maxPDULength = 64 * 1024
+
+// pdu is a single SMPP protocol data unit, the header fields plus the raw body which is encoded and decoded by the
+// functions for each command below
+type pdu struct {
+	commandID uint32
+	status    uint32
+	sequence  uint32
+	body      []byte
+}
+
+// isResponse returns whether this PDU is a response to a request
+func (p *pdu) isResponse() bool {
+	return p.commandID&genericNack != 0
+}
+
+// String returns a readable version of this PDU for our channel logs
+func (p *pdu) String() string {
+	name, found := commandNames[p.commandID]
+	if !found {
+		name = fmt.Sprintf("0x%08X", p.commandID)
+	}
+	desc := fmt.Sprintf("%s sequence=%d status=0x%08X", name, p.sequence, p.status)
+
+	switch p.commandID {
+	case submitSM, deliverSM:
+		sm, err := p.shortMessage()
+		if err == nil {
+			desc = fmt.Sprintf("%s\n%s", desc, sm)
+		}
+	case submitSMResp, bindTransceiverResp:
+		desc = fmt.Sprintf("%s id=%s", desc, p.messageID())
+	}
+	return desc
+}
+
+// readPDU reads the next PDU from the passed in reader
+func readPDU(r io.Reader) (*pdu, error) {
+	header := make([]byte, 16)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length < 16 || length > maxPDULength {
+		return nil, fmt.Errorf("invalid PDU length: %d", length)
+	}
+
+	p := &pdu{
+		commandID: binary.BigEndian.Uint32(header[4:8]),
+		status:    binary.BigEndian.Uint32(header[8:12]),
+		sequence:  binary.BigEndian.Uint32(header[12:16]),
+		body:      make([]byte, length-16),
+	}
+	_, err = io.ReadFull(r, p.body)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// bytes returns the wire format of this PDU
+func (p *pdu) bytes() []byte {
+	b := make([]byte, 16, 16+len(p.body))
+	binary.BigEndian.PutUint32(b[0:4], uint32(16+len(p.body)))
+	binary.BigEndian.PutUint32(b[4:8], p.commandID)
+	binary.BigEndian.PutUint32(b[8:12], p.status)
+	binary.BigEndian.PutUint32(b[12:16], p.sequence)
+	return append(b, p.body...)
+}
+
+// newResponse returns the response to this PDU with the passed in status and body
+func (p *pdu) newResponse(status uint32, body []byte) *pdu {
+	commandID := p.commandID | genericNack
+	if p.isResponse() {
+		commandID = genericNack
+	}
+	return &pdu{commandID: commandID, status: status, sequence: p.sequence, body: body}
+}
+
+// newBindTransceiver returns a bind_transceiver PDU with the passed in credentials
+func newBindTransceiver(systemID string, password string, systemType string) *pdu {
+	w := &bodyWriter{}
+	w.cString(systemID)
+	w.cString(password)
+	w.cString(systemType)
+	w.byte(interfaceVersion)
+	w.byte(tonUnknown)
+	w.byte(npiUnknown)
+	w.cString("")
+	return &pdu{commandID: bindTransceiver, body: w.Bytes()}
+}
+
+// bindCredentials returns the system id and password of a bind_transceiver PDU
+func (p *pdu) bindCredentials() (string, string) {
+	r := &bodyReader{body: p.body}
+	return r.cString(), r.cString()
+}
+
+// messageID returns the message or system id of a response PDU, which is all their bodies contain
+func (p *pdu) messageID() string {
+	r := &bodyReader{body: p.body}
+	return r.cString()
+}
+
+// messageIDBody returns the body of a response PDU which carries the passed in message or system id
+func messageIDBody(messageID string) []byte {
+	w := &bodyWriter{}
+	w.cString(messageID)
+	return w.Bytes()
+}
+
+// shortMessage is the body of submit_sm and deliver_sm PDUs, which share a format
+type shortMessage struct {
+	serviceType        string
+	sourceTON          byte
+	sourceNPI          byte
+	source             string
+	destTON            byte
+	destNPI            byte
+	dest               string
+	esmClass           byte
+	protocolID         byte
+	priority           byte
+	registeredDelivery byte
+	dataCoding         byte
+	message            []byte
+	tlvs               map[uint16][]byte
+}
+
+// String returns a readable version of this short message for our channel logs
+func (sm *shortMessage) String() string {
+	return fmt.Sprintf("source=%s dest=%s esm_class=0x%02X data_coding=0x%02X registered_delivery=%d\nshort_message=%s",
+		sm.source, sm.dest, sm.esmClass, sm.dataCoding, sm.registeredDelivery, sm.text())
+}
+
+// newSubmitSM returns a submit_sm PDU for the passed in short message
+func newSubmitSM(sm *shortMessage) *pdu {
+	return &pdu{commandID: submitSM, body: sm.bytes()}
+}
+
+// bytes returns the wire format of this short message, any message longer than short_message can hold is sent in
+// the message_payload parameter instead
+func (sm *shortMessage) bytes() []byte {
+	w := &bodyWriter{}
+	w.cString(sm.serviceType)
+	w.byte(sm.sourceTON)
+	w.byte(sm.sourceNPI)
+	w.cString(sm.source)
+	w.byte(sm.destTON)
+	w.byte(sm.destNPI)
+	w.cString(sm.dest)
+	w.byte(sm.esmClass)
+	w.byte(sm.protocolID)
+	w.byte(sm.priority)
+	w.cString("") // schedule_delivery_time
+	w.cString("") // validity_period
+	w.byte(sm.registeredDelivery)
+	w.byte(0) // replace_if_present_flag
+	w.byte(sm.dataCoding)
+	w.byte(0) // sm_default_msg_id
+
+	if len(sm.message) <= 254 {
+		w.byte(byte(len(sm.message)))
+		w.Write(sm.message)
+	} else {
+		w.byte(0)
+		w.tlv(tlvMessagePayload, sm.message)
+	}
+
+	for tag, value := range sm.tlvs {
+		w.tlv(tag, value)
+	}
+	return w.Bytes()
+}
+
+// shortMessage decodes the body of a submit_sm or deliver_sm PDU
+func (p *pdu) shortMessage() (*shortMessage, error) {
+	r := &bodyReader{body: p.body}
+	sm := &shortMessage{tlvs: make(map[uint16][]byte)}
+	sm.serviceType = r.cString()
+	sm.sourceTON = r.byte()
+	sm.sourceNPI = r.byte()
+	sm.source = r.cString()
+	sm.destTON = r.byte()
+	sm.destNPI = r.byte()
+	sm.dest = r.cString()
+	sm.esmClass = r.byte()
+	sm.protocolID = r.byte()
+	sm.priority = r.byte()
+	r.cString() // schedule_delivery_time
+	r.cString() // validity_period
+	sm.registeredDelivery = r.byte()
+	r.byte() // replace_if_present_flag
+	sm.dataCoding = r.byte()
+	r.byte() // sm_default_msg_id
+	sm.message = r.bytes(int(r.byte()))
+
+	for r.err == nil && r.remaining() > 0 {
+		tag, value := r.tlv()
+		sm.tlvs[tag] = value
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid %s body: %s", commandNames[p.commandID], r.err)
+	}
+
+	// long messages may be sent in the payload parameter instead
+	if len(sm.message) == 0 && len(sm.tlvs[tlvMessagePayload]) > 0 {
+		sm.message = sm.tlvs[tlvMessagePayload]
+	}
+	return sm, nil
+}
+
+// isReceipt returns whether this short message is a delivery receipt rather than a message from a user
+func (sm *shortMessage) isReceipt() bool {
+	return sm.esmClass&esmClassReceiptMask == esmClassReceipt
+}
+
+// udh returns the user data header and the remaining text of this short message, if it has a header
+func (sm *shortMessage) udh() ([]byte, []byte) {
+	if sm.esmClass&esmClassUDHI == 0 || len(sm.message) == 0 {
+		return nil, sm.message
+	}
+
+	length := int(sm.message[0]) + 1
+	if length > len(sm.message) {
+		return nil, sm.message
+	}
+	return sm.message[:length], sm.message[length:]
+}
+
+// concatInfo returns the reference, total number of parts and part number of this short message if it is part of
+// a concatenated message, reading the 8 or 16 bit reference information elements of its header
+func (sm *shortMessage) concatInfo() (int, int, int, bool) {
+	header, _ := sm.udh()
+	for i := 1; i+1 < len(header); {
+		id, length := header[i], int(header[i+1])
+		element := header[i+2:]
+		if length > len(element) {
+			return 0, 0, 0, false
+		}
+		element = element[:length]
+
+		if id == 0x00 && length == 3 {
+			return int(element[0]), int(element[1]), int(element[2]), true
+		}
+		if id == 0x08 && length == 4 {
+			return int(element[0])<<8 | int(element[1]), int(element[2]), int(element[3]), true
+		}
+		i += 2 + length
+	}
+	return 0, 0, 0, false
+}
+
+// text returns the text of this short message, decoded according to its data coding
+func (sm *shortMessage) text() string {
+	_, message := sm.udh()
+	return decodeText(message, sm.dataCoding)
+}
+
+// encodeText encodes the passed in text in the passed in data coding, only GSM7 and UCS2 are used for sending
+func encodeText(text string, dataCoding byte) []byte {
+	if dataCoding == codingUCS2 {
+		units := utf16.Encode([]rune(text))
+		encoded := make([]byte, len(units)*2)
+		for i, unit := range units {
+			binary.BigEndian.PutUint16(encoded[i*2:], unit)
+		}
+		return encoded
+	}
+	return gsm7.Encode(text)
+}
+
+// decodeText decodes the passed in message in the passed in data coding, anything we don't know is treated as Latin-1
+func decodeText(message []byte, dataCoding byte) string {
+	switch dataCoding {
+	case codingDefault:
+		return gsm7.Decode(message)
+	case codingIA5:
+		return string(message)
+	case codingUCS2:
+		units := make([]uint16, len(message)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(message[i*2:])
+		}
+		return string(utf16.Decode(units))
+	default:
+		runes := make([]rune, len(message))
+		for i, b := range message {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+}
+
+// the longest short message we send, in bytes
+const maxMessageLength = 140
+
+// the user data header we prefix each part of a long message with takes up this many bytes
+const concatHeaderLength = 6
+
+// splitMessage splits the passed in encoded message into parts that fit in a short message, prefixing each part with
+// a header so the handset can put them back together. GSM7 escape sequences and UCS2 surrogate pairs aren't split.
+func splitMessage(message []byte, dataCoding byte, reference byte) [][]byte {
+	// GSM7 is sent with one septet per byte but measured against the packed limits
+	max, partMax := maxMessageLength, maxMessageLength-concatHeaderLength
+	if dataCoding == codingDefault {
+		max, partMax = max*8/7, partMax*8/7
+	}
+	if len(message) <= max {
+		return [][]byte{message}
+	}
+
+	chunks := make([][]byte, 0, len(message)/partMax+1)
+	for len(message) > 0 {
+		end := partMax
+		if end >= len(message) {
+			end = len(message)
+		} else if dataCoding == codingDefault && message[end-1] == 0x1B {
+			end--
+		} else if dataCoding == codingUCS2 {
+			if high := binary.BigEndian.Uint16(message[end-2:]); high >= 0xD800 && high < 0xDC00 {
+				end -= 2
+			}
+		}
+		chunks = append(chunks, message[:end])
+		message = message[end:]
+	}
+
+	parts := make([][]byte, len(chunks))
+	for i, chunk := range chunks {
+		header := []byte{concatHeaderLength - 1, 0x00, 0x03, reference, byte(len(chunks)), byte(i + 1)}
+		parts[i] = append(header, chunk...)
+	}
+	return parts
+}
+
+// id:IIIIIIIIII sub:SSS dlvrd:DDD submit date:YYMMDDhhmm done date:YYMMDDhhmm stat:DDDDDDD err:E Text: ...
+var receiptIDRegex = regexp.MustCompile(`(?i)\bid:\s*(\S+)`)
+var receiptStatRegex = regexp.MustCompile(`(?i)\bstat:\s*(\w+)`)
+
+// the message_state values of the receipted message state parameter, by the names they have in receipt text
+var messageStates = map[byte]string{
+	1: "ENROUTE",
+	2: "DELIVRD",
+	3: "EXPIRED",
+	4: "DELETED",
+	5: "UNDELIV",
+	6: "ACCEPTD",
+	7: "UNKNOWN",
+	8: "REJECTD",
+}
+
+// receipt returns the id of the message and its state from a delivery receipt, which SMSCs put in the text of the
+// receipt and may also put in optional parameters
+func (sm *shortMessage) receipt() (string, string, error) {
+	id := strings.TrimRight(string(sm.tlvs[tlvReceiptedMessageID]), "\x00")
+	state := ""
+	if value := sm.tlvs[tlvMessageState]; len(value) == 1 {
+		state = messageStates[value[0]]
+	}
+
+	text := sm.text()
+	if id == "" {
+		if match := receiptIDRegex.FindStringSubmatch(text); match != nil {
+			id = match[1]
+		}
+	}
+	if state == "" {
+		if match := receiptStatRegex.FindStringSubmatch(text); match != nil {
+			state = strings.ToUpper(match[1])
+		}
+	}
+
+	if id == "" || state == "" {
+		return "", "", fmt.Errorf("unable to parse delivery receipt: %s", text)
+	}
+	return id, state, nil
+}
+
+// bodyWriter builds up the body of a PDU
+type bodyWriter struct {
+	bytes.Buffer
+}
+
+func (w *bodyWriter) byte(b byte) {
+	w.WriteByte(b)
+}
+
+func (w *bodyWriter) cString(s string) {
+	w.WriteString(s)
+	w.WriteByte(0)
+}
+
+func (w *bodyWriter) tlv(tag uint16, value []byte) {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint16(header[0:2], tag)
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(value)))
+	w.Write(header)
+	w.Write(value)
+}
+
+// bodyReader reads the fields of a PDU body, the first error encountered is kept and all reads after it return
+// zero values so that callers only need to check for it once they are done
+type bodyReader struct {
+	body []byte
+	pos  int
+	err  error
+}
+
+func (r *bodyReader) remaining() int {
+	return len(r.body) - r.pos
+}
+
+func (r *bodyReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > r.remaining() {
+		r.err = fmt.Errorf("unexpected end of body")
+		return nil
+	}
+	b := r.body[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *bodyReader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *bodyReader) cString() string {
+	if r.err != nil {
+		return ""
+	}
+	end := bytes.IndexByte(r.body[r.pos:], 0)
+	if end < 0 {
+		// response bodies may be left empty rather than carry an empty string
+		if r.remaining() == 0 {
+			return ""
+		}
+		r.err = fmt.Errorf("unterminated string")
+		return ""
+	}
+	s := string(r.body[r.pos : r.pos+end])
+	r.pos += end + 1
+	return s
+}
+
+func (r *bodyReader) tlv() (uint16, []byte) {
+	header := r.bytes(4)
+	if header == nil {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint16(header[0:2]), r.bytes(int(binary.BigEndian.Uint16(header[2:4])))
+}
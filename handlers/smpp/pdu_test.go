@@ -0,0 +1,171 @@
+package smpp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPDURoundTrip(t *testing.T) {
+	sm := &shortMessage{
+		sourceTON:          tonAlphanumeric,
+		source:             "Courier",
+		destTON:            tonInternational,
+		destNPI:            npiISDN,
+		dest:               "250788383383",
+		registeredDelivery: 1,
+		dataCoding:         codingDefault,
+		message:            encodeText("Hello World", codingDefault),
+	}
+	p := newSubmitSM(sm)
+	p.sequence = 12
+
+	read, err := readPDU(bytes.NewReader(p.bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, submitSM, read.commandID)
+	assert.Equal(t, uint32(12), read.sequence)
+	assert.False(t, read.isResponse())
+
+	decoded, err := read.shortMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "Courier", decoded.source)
+	assert.Equal(t, tonAlphanumeric, decoded.sourceTON)
+	assert.Equal(t, "250788383383", decoded.dest)
+	assert.Equal(t, tonInternational, decoded.destTON)
+	assert.Equal(t, npiISDN, decoded.destNPI)
+	assert.Equal(t, byte(1), decoded.registeredDelivery)
+	assert.Equal(t, "Hello World", decoded.text())
+	assert.Contains(t, read.String(), "submit_sm sequence=12")
+
+	resp := read.newResponse(statusOK, messageIDBody("abc123"))
+	assert.Equal(t, submitSMResp, resp.commandID)
+	assert.Equal(t, uint32(12), resp.sequence)
+	assert.True(t, resp.isResponse())
+	assert.Equal(t, "abc123", resp.messageID())
+
+	// responses to responses are generic nacks
+	assert.Equal(t, genericNack, resp.newResponse(statusInvalidCommand, nil).commandID)
+
+	// empty response bodies are ok
+	assert.Equal(t, "", (&pdu{commandID: submitSMResp}).messageID())
+
+	// messages too long for short_message go in the payload parameter
+	sm.message = bytes.Repeat([]byte("a"), 300)
+	decoded, err = newSubmitSM(sm).shortMessage()
+	require.NoError(t, err)
+	assert.Equal(t, sm.message, decoded.message)
+
+	bind := newBindTransceiver("user", "pass", "VMA")
+	systemID, password := bind.bindCredentials()
+	assert.Equal(t, "user", systemID)
+	assert.Equal(t, "pass", password)
+
+	// invalid lengths and truncated PDUs are errors
+	_, err = readPDU(bytes.NewReader([]byte{0, 0, 0, 4, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 1}))
+	assert.Error(t, err)
+	_, err = readPDU(bytes.NewReader(p.bytes()[:20]))
+	assert.Error(t, err)
+
+	_, err = (&pdu{commandID: deliverSM, body: []byte{0, 1, 1}}).shortMessage()
+	assert.Error(t, err)
+}
+
+func TestEncoding(t *testing.T) {
+	assert.Equal(t, []byte{0x00, 0x48, 0x00, 0x69, 0xD8, 0x3D, 0xDE, 0x00}, encodeText("Hi😀", codingUCS2))
+	assert.Equal(t, "Hi😀", decodeText(encodeText("Hi😀", codingUCS2), codingUCS2))
+	assert.Equal(t, "Hi {€}", decodeText(encodeText("Hi {€}", codingDefault), codingDefault))
+	assert.Equal(t, "Hi", decodeText([]byte("Hi"), codingIA5))
+	assert.Equal(t, "café", decodeText([]byte{'c', 'a', 'f', 0xE9}, codingLatin1))
+}
+
+func TestSplitMessage(t *testing.T) {
+	// messages that fit aren't split
+	message := encodeText(strings.Repeat("a", 160), codingDefault)
+	assert.Equal(t, [][]byte{message}, splitMessage(message, codingDefault, 1))
+
+	message = encodeText(strings.Repeat("a", 161), codingDefault)
+	parts := splitMessage(message, codingDefault, 7)
+	require.Equal(t, 2, len(parts))
+	assert.Equal(t, []byte{0x05, 0x00, 0x03, 0x07, 0x02, 0x01}, parts[0][:6])
+	assert.Equal(t, []byte{0x05, 0x00, 0x03, 0x07, 0x02, 0x02}, parts[1][:6])
+	assert.Equal(t, 153, len(parts[0])-6)
+	assert.Equal(t, 8, len(parts[1])-6)
+
+	// escape sequences stay in the same part
+	message = encodeText(strings.Repeat("a", 152)+"€"+strings.Repeat("a", 10), codingDefault)
+	parts = splitMessage(message, codingDefault, 1)
+	require.Equal(t, 2, len(parts))
+	assert.Equal(t, 152, len(parts[0])-6)
+	assert.Equal(t, byte(0x1B), parts[1][6])
+
+	// as do surrogate pairs
+	message = encodeText(strings.Repeat("a", 66)+"😀"+strings.Repeat("a", 10), codingUCS2)
+	parts = splitMessage(message, codingUCS2, 1)
+	require.Equal(t, 2, len(parts))
+	assert.Equal(t, 132, len(parts[0])-6)
+	assert.Equal(t, "😀"+strings.Repeat("a", 10), decodeText(parts[1][6:], codingUCS2))
+
+	message = encodeText(strings.Repeat("☺", 70), codingUCS2)
+	assert.Equal(t, 1, len(splitMessage(message, codingUCS2, 1)))
+	message = encodeText(strings.Repeat("☺", 71), codingUCS2)
+	assert.Equal(t, 2, len(splitMessage(message, codingUCS2, 1)))
+}
+
+func TestConcatInfo(t *testing.T) {
+	tcs := []struct {
+		esmClass  byte
+		message   []byte
+		reference int
+		total     int
+		seq       int
+		isPart    bool
+		text      string
+	}{
+		{0, []byte("hello"), 0, 0, 0, false, "hello"},
+		{esmClassUDHI, []byte{0x05, 0x00, 0x03, 0x2A, 0x03, 0x02, 'h', 'i'}, 0x2A, 3, 2, true, "hi"},
+		{esmClassUDHI, []byte{0x06, 0x08, 0x04, 0x01, 0x02, 0x02, 0x01, 'h', 'i'}, 0x0102, 2, 1, true, "hi"},
+		{esmClassUDHI, []byte{0x05, 0x01, 0x01, 0x00, 0x00, 0x00, 'h', 'i'}, 0, 0, 0, false, "hi"},
+		{esmClassUDHI, []byte{0x05, 0x00, 0x05, 0x2A}, 0, 0, 0, false, "é@é*"},
+	}
+
+	for _, tc := range tcs {
+		sm := &shortMessage{esmClass: tc.esmClass, message: tc.message}
+		reference, total, seq, isPart := sm.concatInfo()
+		assert.Equal(t, tc.reference, reference)
+		assert.Equal(t, tc.total, total)
+		assert.Equal(t, tc.seq, seq)
+		assert.Equal(t, tc.isPart, isPart)
+		assert.Equal(t, tc.text, sm.text())
+	}
+}
+
+func TestReceipt(t *testing.T) {
+	tcs := []struct {
+		text  string
+		tlvs  map[uint16][]byte
+		id    string
+		state string
+		err   bool
+	}{
+		{"id:1234 sub:001 dlvrd:001 submit date:1710161200 done date:1710161201 stat:DELIVRD err:000 text:Hello", nil, "1234", "DELIVRD", false},
+		{"id:abc sub:001 dlvrd:000 submit date:1710161200 done date:1710161201 stat:undeliv err:001", nil, "abc", "UNDELIV", false},
+		{"", map[uint16][]byte{tlvReceiptedMessageID: []byte("5678\x00"), tlvMessageState: {2}}, "5678", "DELIVRD", false},
+		{"id:1234 stat:DELIVRD", map[uint16][]byte{tlvMessageState: {5}}, "1234", "UNDELIV", false},
+		{"not a receipt", nil, "", "", true},
+	}
+
+	for _, tc := range tcs {
+		sm := &shortMessage{esmClass: esmClassReceipt, message: encodeText(tc.text, codingDefault), tlvs: tc.tlvs}
+		assert.True(t, sm.isReceipt())
+
+		id, state, err := sm.receipt()
+		assert.Equal(t, tc.id, id, tc.text)
+		assert.Equal(t, tc.state, state, tc.text)
+		assert.Equal(t, tc.err, err != nil, tc.text)
+	}
+
+	assert.False(t, (&shortMessage{esmClass: esmClassUDHI}).isReceipt())
+}
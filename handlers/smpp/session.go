@@ -0,0 +1,358 @@
+package smpp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// how often we check our connection is alive when it is otherwise idle
+var enquireLinkInterval = 30 * time.Second
+
+// how long we wait for the SMSC to respond to a request
+var responseTimeout = 10 * time.Second
+
+// how long we wait before trying to bind again after our connection fails
+var rebindDelay = 10 * time.Second
+
+// how long we wait before trying to bind again when the SMSC tells us our system id is already bound, which is usually
+// another courier instance holding the session for the same channel
+var alreadyBoundDelay = 5 * time.Minute
+
+// errAlreadyBound is returned when the SMSC only allows one session per system id and another session holds it
+var errAlreadyBound = fmt.Errorf("system id is already bound by another session")
+
+// deliverFunc is called with each deliver_sm we receive, returning the status we should respond with
+type deliverFunc func(*pdu) uint32
+
+// transceiver holds a bound transceiver session with an SMSC, rebinding whenever the connection is lost until it is stopped
+type transceiver struct {
+	addr       string
+	systemID   string
+	password   string
+	systemType string
+	deliver    deliverFunc
+	log        *logrus.Entry
+
+	mutex    sync.Mutex
+	conn     net.Conn
+	bound    chan bool
+	sequence uint32
+	pending  map[uint32]chan *pdu
+	stopped  bool
+
+	// writes may come from many goroutines, make sure they don't interleave
+	writeMutex sync.Mutex
+
+	reference byte
+	stop      chan bool
+	done      chan bool
+}
+
+// newTransceiver creates a new transceiver, it needs to be started before it will bind
+func newTransceiver(addr string, systemID string, password string, systemType string, deliver deliverFunc) *transceiver {
+	return &transceiver{
+		addr:       addr,
+		systemID:   systemID,
+		password:   password,
+		systemType: systemType,
+		deliver:    deliver,
+		log:        logrus.WithField("comp", "smpp").WithField("addr", addr).WithField("system_id", systemID),
+
+		bound:   make(chan bool),
+		pending: make(map[uint32]chan *pdu),
+		stop:    make(chan bool),
+		done:    make(chan bool),
+	}
+}
+
+// start binds to the SMSC in the background, keeping us bound until stop is called
+func (t *transceiver) start() {
+	go func() {
+		defer close(t.done)
+
+		for {
+			delay := rebindDelay
+			err := t.bind()
+			if err == errAlreadyBound {
+				// don't fight whoever holds our session, they'll be receiving our messages
+				t.log.WithError(err).Warn("not binding, only one session allowed per system id")
+				delay = alreadyBoundDelay
+			} else if err != nil {
+				t.log.WithError(err).Error("error binding")
+			} else {
+				t.log.Info("bound")
+				err = t.serve()
+				t.log.WithError(err).Info("connection lost")
+			}
+
+			select {
+			case <-t.stop:
+				return
+			case <-time.After(delay):
+			}
+		}
+	}()
+}
+
+// stopAndWait unbinds and closes our connection, returning once we are no longer trying to bind
+func (t *transceiver) stopAndWait() {
+	t.mutex.Lock()
+	if t.stopped {
+		t.mutex.Unlock()
+		<-t.done
+		return
+	}
+	t.stopped = true
+	close(t.stop)
+	conn := t.conn
+	isBound := t.isBound()
+	t.mutex.Unlock()
+
+	// be polite and unbind if we can, we don't wait long to hear back
+	if isBound {
+		resp, err := t.requestWithTimeout(&pdu{commandID: unbind}, time.Second)
+		if err != nil || resp.status != statusOK {
+			t.log.WithError(err).Debug("error unbinding")
+		}
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	<-t.done
+}
+
+// bind opens a new connection to the SMSC and binds on it
+func (t *transceiver) bind() error {
+	conn, err := net.DialTimeout("tcp", t.addr, responseTimeout)
+	if err != nil {
+		return err
+	}
+
+	// we can't be using a connection once we've been stopped
+	t.mutex.Lock()
+	if t.stopped {
+		t.mutex.Unlock()
+		conn.Close()
+		return fmt.Errorf("transceiver stopped")
+	}
+	t.conn = conn
+	t.mutex.Unlock()
+
+	bind := newBindTransceiver(t.systemID, t.password, t.systemType)
+	bind.sequence = t.nextSequence()
+	conn.SetDeadline(time.Now().Add(responseTimeout))
+	_, err = conn.Write(bind.bytes())
+	if err == nil {
+		var resp *pdu
+		resp, err = readPDU(conn)
+		if err == nil && (resp.commandID != bindTransceiverResp || resp.sequence != bind.sequence) {
+			err = fmt.Errorf("unexpected response to bind: %s", resp)
+		} else if err == nil && resp.status == statusAlreadyBound {
+			err = errAlreadyBound
+		} else if err == nil && resp.status != statusOK {
+			err = fmt.Errorf("bind failed with status 0x%08X", resp.status)
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	if err != nil {
+		t.closeConn(conn)
+		return err
+	}
+
+	t.mutex.Lock()
+	close(t.bound)
+	t.mutex.Unlock()
+	return nil
+}
+
+// serve reads from our connection and keeps it alive until it fails
+func (t *transceiver) serve() error {
+	t.mutex.Lock()
+	conn := t.conn
+	t.mutex.Unlock()
+
+	// check the connection is still alive whenever we haven't heard from the SMSC for a while
+	activity := make(chan bool, 1)
+	readDone := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-readDone:
+				return
+			case <-activity:
+			case <-time.After(enquireLinkInterval):
+				resp, err := t.request(&pdu{commandID: enquireLink})
+				if err != nil || resp.status != statusOK {
+					t.log.WithError(err).Error("enquire_link failed, closing connection")
+					t.closeConn(conn)
+					return
+				}
+			}
+		}
+	}()
+	defer close(readDone)
+	defer t.closeConn(conn)
+
+	for {
+		p, err := readPDU(conn)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case activity <- true:
+		default:
+		}
+
+		if p.isResponse() {
+			t.mutex.Lock()
+			waiting, found := t.pending[p.sequence]
+			delete(t.pending, p.sequence)
+			t.mutex.Unlock()
+
+			if found {
+				waiting <- p
+			} else {
+				t.log.WithField("pdu", p.String()).Debug("response to unknown request")
+			}
+			continue
+		}
+
+		switch p.commandID {
+		case deliverSM:
+			status := t.deliver(p)
+			err = t.write(conn, p.newResponse(status, messageIDBody("")))
+		case enquireLink:
+			err = t.write(conn, p.newResponse(statusOK, nil))
+		case unbind:
+			t.write(conn, p.newResponse(statusOK, nil))
+			return fmt.Errorf("unbound by SMSC")
+		default:
+			err = t.write(conn, p.newResponse(statusInvalidCommand, nil))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// closeConn closes the passed in connection if it is still our current one, failing anything waiting on it
+func (t *transceiver) closeConn(conn net.Conn) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.conn != conn {
+		return
+	}
+
+	conn.Close()
+	t.conn = nil
+	if t.isBound() {
+		t.bound = make(chan bool)
+	}
+	for sequence, waiting := range t.pending {
+		close(waiting)
+		delete(t.pending, sequence)
+	}
+}
+
+// isBound returns whether we are currently bound, callers must hold our mutex
+func (t *transceiver) isBound() bool {
+	select {
+	case <-t.bound:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitBound waits until we are bound, up to our response timeout
+func (t *transceiver) waitBound() error {
+	t.mutex.Lock()
+	bound := t.bound
+	t.mutex.Unlock()
+
+	select {
+	case <-bound:
+		return nil
+	case <-t.stop:
+		return fmt.Errorf("transceiver stopped")
+	case <-time.After(responseTimeout):
+		return fmt.Errorf("not bound to %s", t.addr)
+	}
+}
+
+// nextSequence returns the sequence number for our next request
+func (t *transceiver) nextSequence() uint32 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// sequence numbers are limited to 0x7FFFFFFF
+	t.sequence = t.sequence%0x7FFFFFFF + 1
+	return t.sequence
+}
+
+// nextReference returns the reference we use to tie together the parts of our next long message
+func (t *transceiver) nextReference() byte {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.reference++
+	return t.reference
+}
+
+// write writes the passed in PDU to the passed in connection
+func (t *transceiver) write(conn net.Conn, p *pdu) error {
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+
+	conn.SetWriteDeadline(time.Now().Add(responseTimeout))
+	_, err := conn.Write(p.bytes())
+	return err
+}
+
+// request sends the passed in request once we are bound, returning the response from the SMSC
+func (t *transceiver) request(p *pdu) (*pdu, error) {
+	err := t.waitBound()
+	if err != nil {
+		return nil, err
+	}
+	return t.requestWithTimeout(p, responseTimeout)
+}
+
+func (t *transceiver) requestWithTimeout(p *pdu, timeout time.Duration) (*pdu, error) {
+	p.sequence = t.nextSequence()
+	waiting := make(chan *pdu, 1)
+
+	t.mutex.Lock()
+	conn := t.conn
+	if conn == nil || !t.isBound() {
+		t.mutex.Unlock()
+		return nil, fmt.Errorf("not bound to %s", t.addr)
+	}
+	t.pending[p.sequence] = waiting
+	t.mutex.Unlock()
+
+	err := t.write(conn, p)
+	if err == nil {
+		select {
+		case resp, ok := <-waiting:
+			if ok {
+				return resp, nil
+			}
+			err = fmt.Errorf("connection lost waiting for response")
+		case <-time.After(timeout):
+			err = fmt.Errorf("timed out waiting for response")
+		}
+	}
+
+	t.mutex.Lock()
+	delete(t.pending, p.sequence)
+	t.mutex.Unlock()
+	return nil, err
+}
@@ -0,0 +1,231 @@
+package smpp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// testSMSC is a minimal SMSC we bind to in our tests, it accepts submit_sm requests and lets tests send deliver_sm
+// requests back to whoever is bound to it
+type testSMSC struct {
+	listener net.Listener
+	systemID string
+	password string
+
+	mutex        sync.Mutex
+	writeMutex   sync.Mutex
+	conns        []net.Conn
+	binds        int
+	enquireLinks int
+	unbinds      int
+	submitted    []*shortMessage
+	submitStatus uint32
+	sequence     uint32
+	singleBind   bool
+	bound        int
+
+	responses chan *pdu
+}
+
+// newTestSMSC starts a new test SMSC listening on a random local port
+func newTestSMSC(systemID string, password string) *testSMSC {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	s := &testSMSC{
+		listener:  listener,
+		systemID:  systemID,
+		password:  password,
+		responses: make(chan *pdu, 10),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *testSMSC) host() string {
+	host, _, _ := net.SplitHostPort(s.listener.Addr().String())
+	return host
+}
+
+func (s *testSMSC) port() int {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+func (s *testSMSC) write(conn net.Conn, p *pdu) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	_, err := conn.Write(p.bytes())
+	return err
+}
+
+func (s *testSMSC) handle(conn net.Conn) {
+	s.mutex.Lock()
+	s.conns = append(s.conns, conn)
+	s.mutex.Unlock()
+
+	defer conn.Close()
+
+	for {
+		p, err := readPDU(conn)
+		if err != nil {
+			return
+		}
+
+		switch p.commandID {
+		case bindTransceiver:
+			systemID, password := p.bindCredentials()
+			s.mutex.Lock()
+			s.binds++
+			s.mutex.Unlock()
+
+			if systemID != s.systemID || password != s.password {
+				s.write(conn, p.newResponse(statusBindFailed, messageIDBody("")))
+				return
+			}
+
+			s.mutex.Lock()
+			alreadyBound := s.singleBind && s.bound > 0
+			if !alreadyBound {
+				s.bound++
+				defer s.unbound()
+			}
+			s.mutex.Unlock()
+
+			if alreadyBound {
+				s.write(conn, p.newResponse(statusAlreadyBound, messageIDBody("")))
+				return
+			}
+			s.write(conn, p.newResponse(statusOK, messageIDBody("test-smsc")))
+
+		case submitSM:
+			sm, err := p.shortMessage()
+			if err != nil {
+				s.write(conn, p.newResponse(statusInvalidCommand, nil))
+				continue
+			}
+
+			s.mutex.Lock()
+			s.submitted = append(s.submitted, sm)
+			messageID := fmt.Sprintf("msg%d", len(s.submitted))
+			status := s.submitStatus
+			s.mutex.Unlock()
+
+			if status != statusOK {
+				messageID = ""
+			}
+			s.write(conn, p.newResponse(status, messageIDBody(messageID)))
+
+		case enquireLink:
+			s.mutex.Lock()
+			s.enquireLinks++
+			s.mutex.Unlock()
+			s.write(conn, p.newResponse(statusOK, nil))
+
+		case unbind:
+			s.mutex.Lock()
+			s.unbinds++
+			s.mutex.Unlock()
+			s.write(conn, p.newResponse(statusOK, nil))
+			return
+
+		case deliverSMResp:
+			s.responses <- p
+
+		default:
+			s.write(conn, p.newResponse(statusInvalidCommand, nil))
+		}
+	}
+}
+
+// deliver sends the passed in short message to the last bound connection, returning the status it is responded to with
+func (s *testSMSC) deliver(sm *shortMessage) (uint32, error) {
+	s.mutex.Lock()
+	if len(s.conns) == 0 {
+		s.mutex.Unlock()
+		return 0, fmt.Errorf("no connections")
+	}
+	conn := s.conns[len(s.conns)-1]
+	s.sequence++
+	p := &pdu{commandID: deliverSM, sequence: s.sequence, body: sm.bytes()}
+	s.mutex.Unlock()
+
+	err := s.write(conn, p)
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case resp := <-s.responses:
+		if resp.sequence != p.sequence {
+			return 0, fmt.Errorf("response to wrong sequence: %d", resp.sequence)
+		}
+		return resp.status, nil
+	case <-time.After(5 * time.Second):
+		return 0, fmt.Errorf("timed out waiting for deliver_sm_resp")
+	}
+}
+
+// dropConnections closes all the connections to the SMSC without unbinding
+func (s *testSMSC) dropConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testSMSC) setSubmitStatus(status uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.submitStatus = status
+}
+
+func (s *testSMSC) getSubmitted() []*shortMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*shortMessage(nil), s.submitted...)
+}
+
+// setSingleBind makes us only allow one session to be bound at a time, as some SMSCs do for each system id
+func (s *testSMSC) setSingleBind() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.singleBind = true
+}
+
+func (s *testSMSC) unbound() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.bound--
+}
+
+func (s *testSMSC) counts() (int, int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.binds, s.enquireLinks, s.unbinds
+}
+
+func (s *testSMSC) close() {
+	s.listener.Close()
+	s.dropConnections()
+}
@@ -0,0 +1,461 @@
+package smpp
+
+/*
+Unlike our other handlers, SMPP channels don't receive messages over HTTP. Courier holds a bound transceiver session
+with the SMSC for each channel, which is opened when courier starts, when the channel first sends a message or when
+its bind URL is called:
+
+POST /c/smp/uuid/bind/
+
+Messages and delivery receipts are then read from that session as deliver_sm PDUs for as long as courier is running,
+rebinding whenever the connection is lost. The active channels are reloaded periodically, and sessions for channels
+which have been deactivated or deleted are unbound.
+
+Every courier instance binds every channel. Many SMSCs allow that, but some only allow one session per system id, in
+which case the SMSC refuses the bind of every instance but the first. Those instances then back off rather than
+competing for the session, so their sends fail until the session is free.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/gsm7"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// the config keys for the SMSC we bind to, the username and password are our system id and password
+const configHost = "host"
+const configPort = "port"
+const configSystemType = "system_type"
+
+// the config key for how we encode messages, same as for Kannel channels
+const configEncoding = "encoding"
+
+const defaultPort = "2775"
+
+const encodingDefault = "D"
+const encodingUnicode = "U"
+const encodingSmart = "S"
+
+// how long we hold on to the parts of a long incoming message waiting for the rest of them
+var partsTimeout = time.Hour
+
+// how often we reload our channels, binding new ones and unbinding those which are no longer active
+var channelsRefreshInterval = 5 * time.Minute
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+
+	mutex        sync.Mutex
+	transceivers map[courier.ChannelUUID]*transceiver
+	parts        *partStore
+}
+
+// NewHandler returns a new SMPP handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{
+		BaseHandler:  handlers.NewBaseHandler(courier.ChannelType("SMP"), "SMPP"),
+		transceivers: make(map[courier.ChannelUUID]*transceiver),
+		parts:        newPartStore(),
+	}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+
+	// keep our sessions in line with our channels, unbinding them all when we shut down
+	s.WaitGroup().Add(1)
+	go func() {
+		defer s.WaitGroup().Done()
+
+		ticker := time.NewTicker(channelsRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				h.bindChannels()
+			case <-s.StopChan():
+				h.stopTransceivers()
+				return
+			}
+		}
+	}()
+
+	// bind all our channels so they start receiving messages right away
+	h.bindChannels()
+
+	return s.AddChannelRoute(h, "POST", "bind", h.Bind)
+}
+
+type smppBindResponse struct {
+	Address  string `json:"address"`
+	SystemID string `json:"system_id"`
+	Bound    bool   `json:"bound"`
+}
+
+// Bind is our HTTP handler function for making sure a channel has a bound session, this should be called when an SMPP
+// channel is created or changed so that it starts receiving messages without having to send one first
+func (h *handler) Bind(channel courier.Channel, w http.ResponseWriter, r *http.Request) error {
+	t, err := h.transceiver(channel)
+	if err != nil {
+		return err
+	}
+
+	err = t.waitBound()
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(&smppBindResponse{t.addr, t.systemID, true})
+}
+
+// bindChannels starts a session for each of our active channels and stops the sessions of any channels which are no
+// longer active, sessions keep trying to rebind so we only log errors
+func (h *handler) bindChannels() {
+	log := logrus.WithField("comp", "smpp")
+
+	channels, err := h.Backend().GetChannels(h.ChannelType())
+	if err != nil {
+		log.WithError(err).Error("error loading SMP channels")
+		return
+	}
+
+	active := make(map[courier.ChannelUUID]bool, len(channels))
+	for _, channel := range channels {
+		active[channel.UUID()] = true
+
+		_, err := h.transceiver(channel)
+		if err != nil {
+			log.WithField("channel_uuid", channel.UUID()).WithError(err).Error("error binding channel")
+		}
+	}
+
+	h.mutex.Lock()
+	inactive := make([]*transceiver, 0)
+	for uuid, t := range h.transceivers {
+		if !active[uuid] {
+			log.WithField("channel_uuid", uuid).Info("unbinding inactive channel")
+			inactive = append(inactive, t)
+			delete(h.transceivers, uuid)
+		}
+	}
+	h.mutex.Unlock()
+
+	for _, t := range inactive {
+		t.stopAndWait()
+	}
+}
+
+// transceiver returns the session for the passed in channel, starting a new one if it doesn't have one or its
+// configuration has changed
+func (h *handler) transceiver(channel courier.Channel) (*transceiver, error) {
+	host := channel.StringConfigForKey(configHost, "")
+	username := channel.StringConfigForKey(courier.ConfigUsername, "")
+	password := channel.StringConfigForKey(courier.ConfigPassword, "")
+	if host == "" || username == "" || password == "" {
+		return nil, fmt.Errorf("missing host, username or password for SMP channel")
+	}
+	systemType := channel.StringConfigForKey(configSystemType, "")
+
+	// ports may be configured as numbers or strings
+	port := fmt.Sprintf("%v", channel.ConfigForKey(configPort, defaultPort))
+	addr := net.JoinHostPort(host, port)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	t, found := h.transceivers[channel.UUID()]
+	if found {
+		if t.addr == addr && t.systemID == username && t.password == password && t.systemType == systemType {
+			return t, nil
+		}
+		go t.stopAndWait()
+	}
+
+	t = newTransceiver(addr, username, password, systemType, func(p *pdu) uint32 {
+		return h.receive(channel, addr, p)
+	})
+	t.start()
+	h.transceivers[channel.UUID()] = t
+	return t, nil
+}
+
+// stopTransceivers unbinds all our sessions
+func (h *handler) stopTransceivers() {
+	h.mutex.Lock()
+	transceivers := h.transceivers
+	h.transceivers = make(map[courier.ChannelUUID]*transceiver)
+	h.mutex.Unlock()
+
+	for _, t := range transceivers {
+		t.stopAndWait()
+	}
+}
+
+var receiptStatusMapping = map[string]courier.MsgStatusValue{
+	"ENROUTE": courier.MsgSent,
+	"ACCEPTD": courier.MsgSent,
+	"DELIVRD": courier.MsgDelivered,
+	"EXPIRED": courier.MsgFailed,
+	"DELETED": courier.MsgFailed,
+	"UNDELIV": courier.MsgFailed,
+	"REJECTD": courier.MsgFailed,
+}
+
+// receive handles a deliver_sm from the SMSC for the passed in channel, which is either a message or a delivery
+// receipt. We return a temporary error if we can't write what we received so that the SMSC tries again later.
+func (h *handler) receive(channel courier.Channel, addr string, p *pdu) uint32 {
+	start := time.Now()
+	url := fmt.Sprintf("smpp://%s", addr)
+	log := logrus.WithField("comp", "smpp").WithField("channel_uuid", channel.UUID())
+
+	sm, err := p.shortMessage()
+	if err != nil {
+		log.WithError(err).Error("error reading deliver_sm")
+		return statusOK
+	}
+
+	if sm.isReceipt() {
+		externalID, state, err := sm.receipt()
+		if err != nil {
+			log.WithError(err).Error("error reading delivery receipt")
+			return statusOK
+		}
+
+		msgStatus, found := receiptStatusMapping[state]
+		if !found {
+			log.WithField("stat", state).Info("ignoring delivery receipt with unknown state")
+			return statusOK
+		}
+
+		status := h.Backend().NewMsgStatusForExternalID(channel, externalID, msgStatus)
+		err = h.Backend().WriteMsgStatus(status)
+
+		// we may not know about this message, that's ok
+		if err == courier.ErrMsgNotFound {
+			return statusOK
+		}
+		h.writeLog("Status Updated", "Status Update Error", channel, courier.NilMsgID, url, p, start, err)
+		if err != nil {
+			return statusTemporaryAppErr
+		}
+		return statusOK
+	}
+
+	// long messages come in parts, hold on to them until we have all of them and have written the message
+	message := sm.message
+	partsKey := ""
+	if reference, total, seq, isPart := sm.concatInfo(); isPart {
+		_, part := sm.udh()
+		partsKey = fmt.Sprintf("%s|%s|%d|%d", channel.UUID(), sm.source, reference, total)
+
+		var complete bool
+		message, complete = h.parts.add(partsKey, total, seq, part)
+		if !complete {
+			return statusOK
+		}
+	} else {
+		_, message = sm.udh()
+	}
+
+	// international numbers may come without their leading +
+	source := sm.source
+	if sm.sourceTON == tonInternational && !strings.HasPrefix(source, "+") {
+		source = "+" + source
+	}
+
+	urn := courier.NewTelURNForChannel(source, channel)
+	msg := h.Backend().NewIncomingMsg(channel, urn, decodeText(message, sm.dataCoding)).WithReceivedOn(time.Now().UTC())
+	err = h.Backend().WriteMsg(msg)
+	h.writeLog("Message Received", "Message Receive Error", channel, msg.ID(), url, p, start, err)
+	if err != nil {
+		return statusTemporaryAppErr
+	}
+
+	if partsKey != "" {
+		h.parts.remove(partsKey)
+	}
+	return statusOK
+}
+
+// writeLog writes a channel log for a PDU we received
+func (h *handler) writeLog(description string, errorDescription string, channel courier.Channel, msgID courier.MsgID, url string, p *pdu, start time.Time, err error) {
+	statusCode := http.StatusOK
+	if err != nil {
+		statusCode = http.StatusBadRequest
+	}
+	log := courier.NewChannelLog(description, channel, msgID, "deliver_sm", url, statusCode, p.String(), "", time.Now().Sub(start), err).WithError(errorDescription, err)
+	h.Backend().WriteChannelLogs([]*courier.ChannelLog{log})
+}
+
+var numericRegex = regexp.MustCompile(`^[0-9]+$`)
+
+// addressParts returns the type of number, numbering plan and address we send the passed in address as
+func addressParts(address string) (byte, byte, string) {
+	if strings.HasPrefix(address, "+") {
+		return tonInternational, npiISDN, strings.TrimPrefix(address, "+")
+	}
+	if !numericRegex.MatchString(address) {
+		return tonAlphanumeric, npiUnknown, address
+	}
+	return tonUnknown, npiISDN, address
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	t, err := h.transceiver(msg.Channel())
+	if err != nil {
+		return nil, err
+	}
+
+	// the status that will be written for this message
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+
+	// figure out how we encode this message, if we are smart first try to convert to GSM7 chars
+	text := courier.GetTextAndAttachments(msg)
+	dataCoding := codingDefault
+
+	switch msg.Channel().StringConfigForKey(configEncoding, encodingSmart) {
+	case encodingSmart:
+		replaced := gsm7.ReplaceNonGSM7Chars(text)
+		if gsm7.IsGSM7(replaced) {
+			text = replaced
+		} else {
+			dataCoding = codingUCS2
+		}
+	case encodingUnicode:
+		dataCoding = codingUCS2
+	}
+
+	sourceTON, sourceNPI, source := addressParts(msg.Channel().Address())
+	destTON, destNPI, dest := addressParts(msg.URN().Path())
+
+	parts := splitMessage(encodeText(text, dataCoding), dataCoding, t.nextReference())
+	for i, part := range parts {
+		sm := &shortMessage{
+			sourceTON:          sourceTON,
+			sourceNPI:          sourceNPI,
+			source:             source,
+			destTON:            destTON,
+			destNPI:            destNPI,
+			dest:               dest,
+			registeredDelivery: 1,
+			dataCoding:         dataCoding,
+			message:            part,
+		}
+		if len(parts) > 1 {
+			sm.esmClass = esmClassUDHI
+		}
+
+		start := time.Now()
+		req := newSubmitSM(sm)
+		resp, err := t.request(req)
+
+		statusCode := courier.NilStatusCode
+		response := ""
+		if resp != nil {
+			statusCode = http.StatusOK
+			response = resp.String()
+			if err == nil && resp.status != statusOK {
+				statusCode = http.StatusBadRequest
+				err = errors.Errorf("received error status 0x%08X", resp.status)
+			}
+		}
+
+		log := courier.NewChannelLog("Message Sent", msg.Channel(), msg.ID(), "submit_sm", fmt.Sprintf("smpp://%s", t.addr), statusCode,
+			req.String(), response, time.Now().Sub(start), err).WithError("Message Send Error", err)
+		status.AddLog(log)
+		if err != nil {
+			return status, nil
+		}
+
+		// the first part is the one we track our status against
+		if i == 0 {
+			status.SetExternalID(resp.messageID())
+		}
+	}
+
+	status.SetStatus(courier.MsgWired)
+	return status, nil
+}
+
+// partStore holds the parts of long incoming messages until we have all of them
+type partStore struct {
+	mutex    sync.Mutex
+	messages map[string]*partialMessage
+}
+
+type partialMessage struct {
+	parts     [][]byte
+	received  int
+	createdOn time.Time
+}
+
+func newPartStore() *partStore {
+	return &partStore{messages: make(map[string]*partialMessage)}
+}
+
+// add adds the passed in part of the message with the passed in key, returning the whole message if we now have all of
+// it. Parts are kept until the message is removed, so a part which is delivered again completes the message again.
+func (s *partStore) add(key string, total int, seq int, part []byte) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// forget about any messages we never got all the parts of
+	for k, message := range s.messages {
+		if time.Since(message.createdOn) > partsTimeout {
+			delete(s.messages, k)
+		}
+	}
+
+	if seq < 1 || seq > total {
+		return nil, false
+	}
+
+	message, found := s.messages[key]
+	if !found {
+		message = &partialMessage{parts: make([][]byte, total), createdOn: time.Now()}
+		s.messages[key] = message
+	}
+
+	if message.parts[seq-1] == nil {
+		message.received++
+	}
+	message.parts[seq-1] = part
+
+	if message.received < total {
+		return nil, false
+	}
+
+	whole := make([]byte, 0, total*len(part))
+	for _, p := range message.parts {
+		whole = append(whole, p...)
+	}
+	return whole, true
+}
+
+// remove forgets the parts of the message with the passed in key, this is called once the whole message is written
+func (s *partStore) remove(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.messages, key)
+}
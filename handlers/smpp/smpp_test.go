@@ -0,0 +1,432 @@
+package smpp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const channelUUID = "8eb23e93-5ecb-45ba-b726-3b064e0c56ab"
+
+// our sessions use timeouts short enough for our tests, these can't be changed once sessions are running
+func init() {
+	enquireLinkInterval = 100 * time.Millisecond
+	responseTimeout = time.Second
+	rebindDelay = 50 * time.Millisecond
+	alreadyBoundDelay = 5 * time.Second
+}
+
+func newTestChannel(smsc *testSMSC, config map[string]interface{}) courier.Channel {
+	channelConfig := map[string]interface{}{
+		configHost:             smsc.host(),
+		configPort:             smsc.port(),
+		courier.ConfigUsername: "courier",
+		courier.ConfigPassword: "sesame",
+	}
+	for k, v := range config {
+		channelConfig[k] = v
+	}
+	return courier.NewMockChannel(channelUUID, "SMP", "2020", "RW", channelConfig)
+}
+
+// newTestHandler returns an initialized handler and a test SMSC to bind to
+func newTestHandler(t *testing.T) (*handler, *courier.MockBackend, courier.Server, *testSMSC) {
+	mb := courier.NewMockBackend()
+	s := courier.NewServer(config.NewTest(), mb)
+	h := NewHandler().(*handler)
+	require.NoError(t, h.Initialize(s))
+
+	return h, mb, s, newTestSMSC("courier", "sesame")
+}
+
+// waitFor polls the passed in condition until it is true or we give up
+func waitFor(t *testing.T, condition func() bool) {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for condition")
+}
+
+func TestSending(t *testing.T) {
+	h, mb, _, smsc := newTestHandler(t)
+	defer smsc.close()
+	defer h.stopTransceivers()
+
+	tcs := []struct {
+		label      string
+		config     map[string]interface{}
+		text       string
+		urn        string
+		dataCoding byte
+		parts      []string
+		dest       string
+		destTON    byte
+	}{
+		{"Plain Send", nil, "Simple Message ☺", "tel:+250788383383", codingUCS2, []string{"Simple Message ☺"}, "250788383383", tonInternational},
+		{"Smart Send", nil, "Hello “World”", "tel:+250788383383", codingDefault, []string{`Hello "World"`}, "250788383383", tonInternational},
+		{"GSM7 Extended", nil, "Price: 10€ [incl]", "tel:0788383383", codingDefault, []string{"Price: 10€ [incl]"}, "0788383383", tonUnknown},
+		{"Forced Default", map[string]interface{}{configEncoding: encodingDefault}, "Hello ☺", "tel:+250788383383", codingDefault, []string{"Hello ?"}, "250788383383", tonInternational},
+		{"Forced Unicode", map[string]interface{}{configEncoding: encodingUnicode}, "Hello", "tel:+250788383383", codingUCS2, []string{"Hello"}, "250788383383", tonInternational},
+		{"Long Send", nil, strings.Repeat("0123456789", 20), "tel:+250788383383", codingDefault,
+			[]string{strings.Repeat("0123456789", 15) + "012", "3456789" + strings.Repeat("0123456789", 4)}, "250788383383", tonInternational},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.label, func(t *testing.T) {
+			before := len(smsc.getSubmitted())
+
+			channel := newTestChannel(smsc, tc.config)
+			msg := mb.NewOutgoingMsg(channel, courier.NewMsgID(10), courier.URN(tc.urn), tc.text, courier.DefaultPriority)
+
+			status, err := h.SendMsg(msg)
+			require.NoError(t, err)
+			assert.Equal(t, courier.MsgWired, status.Status())
+			assert.Equal(t, len(tc.parts), len(status.Logs()))
+
+			submitted := smsc.getSubmitted()[before:]
+			require.Equal(t, len(tc.parts), len(submitted))
+			assert.Equal(t, fmt.Sprintf("msg%d", before+1), status.ExternalID())
+
+			for i, sm := range submitted {
+				assert.Equal(t, tc.parts[i], sm.text())
+				assert.Equal(t, tc.dataCoding, sm.dataCoding)
+				assert.Equal(t, tc.dest, sm.dest)
+				assert.Equal(t, tc.destTON, sm.destTON)
+				assert.Equal(t, "2020", sm.source)
+				assert.Equal(t, byte(1), sm.registeredDelivery)
+
+				_, total, seq, isPart := sm.concatInfo()
+				assert.Equal(t, len(tc.parts) > 1, isPart)
+				if isPart {
+					assert.Equal(t, len(tc.parts), total)
+					assert.Equal(t, i+1, seq)
+				}
+			}
+		})
+	}
+
+	// errors from the SMSC error the message
+	smsc.setSubmitStatus(statusThrottled)
+	msg := mb.NewOutgoingMsg(newTestChannel(smsc, nil), courier.NewMsgID(10), "tel:+250788383383", "Hello", courier.DefaultPriority)
+	status, err := h.SendMsg(msg)
+	require.NoError(t, err)
+	assert.Equal(t, courier.MsgErrored, status.Status())
+	require.Equal(t, 1, len(status.Logs()))
+	assert.Equal(t, "received error status 0x00000058", status.Logs()[0].Error)
+
+	// as do channels missing config
+	channel := courier.NewMockChannel(channelUUID, "SMP", "2020", "RW", map[string]interface{}{configHost: smsc.host()})
+	_, err = h.SendMsg(mb.NewOutgoingMsg(channel, courier.NewMsgID(10), "tel:+250788383383", "Hello", courier.DefaultPriority))
+	assert.EqualError(t, err, "missing host, username or password for SMP channel")
+}
+
+func TestReceiving(t *testing.T) {
+	h, mb, _, smsc := newTestHandler(t)
+	defer smsc.close()
+	defer h.stopTransceivers()
+
+	channel := newTestChannel(smsc, nil)
+	tr, err := h.transceiver(channel)
+	require.NoError(t, err)
+	require.NoError(t, tr.waitBound())
+
+	// a simple message
+	status, err := smsc.deliver(&shortMessage{sourceTON: tonInternational, source: "250788383383", dest: "2020", message: encodeText("Hello World", codingDefault)})
+	require.NoError(t, err)
+	assert.Equal(t, statusOK, status)
+
+	msg, err := mb.GetLastQueueMsg()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello World", msg.Text())
+	assert.Equal(t, courier.URN("tel:+250788383383"), msg.URN())
+
+	// a unicode message from a local number
+	status, err = smsc.deliver(&shortMessage{source: "0788383383", dest: "2020", dataCoding: codingUCS2, message: encodeText("Hi ☺", codingUCS2)})
+	require.NoError(t, err)
+	assert.Equal(t, statusOK, status)
+
+	msg, err = mb.GetLastQueueMsg()
+	require.NoError(t, err)
+	assert.Equal(t, "Hi ☺", msg.Text())
+	assert.Equal(t, courier.URN("tel:+250788383383"), msg.URN())
+
+	// a long message whose parts arrive out of order
+	mb.ClearQueueMsgs()
+	parts := splitMessage(encodeText(strings.Repeat("abcdefghij", 20), codingDefault), codingDefault, 42)
+	require.Equal(t, 2, len(parts))
+
+	status, err = smsc.deliver(&shortMessage{source: "250788383383", dest: "2020", esmClass: esmClassUDHI, message: parts[1]})
+	require.NoError(t, err)
+	assert.Equal(t, statusOK, status)
+	_, err = mb.GetLastQueueMsg()
+	assert.Equal(t, courier.ErrMsgNotFound, err)
+
+	status, err = smsc.deliver(&shortMessage{source: "250788383383", dest: "2020", esmClass: esmClassUDHI, message: parts[0]})
+	require.NoError(t, err)
+	assert.Equal(t, statusOK, status)
+
+	msg, err = mb.GetLastQueueMsg()
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("abcdefghij", 20), msg.Text())
+
+	// a delivery receipt
+	receipt := "id:msg7 sub:001 dlvrd:001 submit date:1710161200 done date:1710161201 stat:DELIVRD err:000 text:Hello"
+	status, err = smsc.deliver(&shortMessage{source: "250788383383", dest: "2020", esmClass: esmClassReceipt, message: encodeText(receipt, codingDefault)})
+	require.NoError(t, err)
+	assert.Equal(t, statusOK, status)
+
+	msgStatus, err := mb.GetLastMsgStatus()
+	require.NoError(t, err)
+	assert.Equal(t, "msg7", msgStatus.ExternalID())
+	assert.Equal(t, courier.MsgDelivered, msgStatus.Status())
+
+	// and one which failed, with its state in optional parameters
+	tlvs := map[uint16][]byte{tlvReceiptedMessageID: []byte("msg8\x00"), tlvMessageState: {5}}
+	status, err = smsc.deliver(&shortMessage{source: "250788383383", dest: "2020", esmClass: esmClassReceipt, tlvs: tlvs})
+	require.NoError(t, err)
+	assert.Equal(t, statusOK, status)
+
+	msgStatus, err = mb.GetLastMsgStatus()
+	require.NoError(t, err)
+	assert.Equal(t, "msg8", msgStatus.ExternalID())
+	assert.Equal(t, courier.MsgFailed, msgStatus.Status())
+
+	// receipts with states we don't know are ignored
+	status, err = smsc.deliver(&shortMessage{source: "250788383383", dest: "2020", esmClass: esmClassReceipt, message: encodeText("id:msg9 stat:UNKNOWN", codingDefault)})
+	require.NoError(t, err)
+	assert.Equal(t, statusOK, status)
+
+	msgStatus, err = mb.GetLastMsgStatus()
+	require.NoError(t, err)
+	assert.Equal(t, "msg8", msgStatus.ExternalID())
+
+	// if we can't write the message the SMSC should try again later
+	mb.SetErrorOnQueue(true)
+	status, err = smsc.deliver(&shortMessage{source: "250788383383", dest: "2020", message: encodeText("Hello", codingDefault)})
+	require.NoError(t, err)
+	assert.Equal(t, statusTemporaryAppErr, status)
+
+	// and the parts of long messages are kept until it can
+	mb.ClearQueueMsgs()
+	parts = splitMessage(encodeText(strings.Repeat("klmnopqrst", 20), codingDefault), codingDefault, 43)
+	status, err = smsc.deliver(&shortMessage{source: "250788383383", dest: "2020", esmClass: esmClassUDHI, message: parts[0]})
+	require.NoError(t, err)
+	assert.Equal(t, statusOK, status)
+	status, err = smsc.deliver(&shortMessage{source: "250788383383", dest: "2020", esmClass: esmClassUDHI, message: parts[1]})
+	require.NoError(t, err)
+	assert.Equal(t, statusTemporaryAppErr, status)
+
+	mb.SetErrorOnQueue(false)
+	status, err = smsc.deliver(&shortMessage{source: "250788383383", dest: "2020", esmClass: esmClassUDHI, message: parts[1]})
+	require.NoError(t, err)
+	assert.Equal(t, statusOK, status)
+
+	msg, err = mb.GetLastQueueMsg()
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("klmnopqrst", 20), msg.Text())
+	assert.Equal(t, 0, len(h.parts.messages))
+}
+
+func TestBindOnInitialize(t *testing.T) {
+	smsc := newTestSMSC("courier", "sesame")
+	defer smsc.close()
+
+	mb := courier.NewMockBackend()
+	channel := newTestChannel(smsc, nil)
+	mb.AddChannel(channel)
+
+	// channels of other types aren't bound
+	mb.AddChannel(courier.NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "KN", "2020", "US", nil))
+
+	h := NewHandler().(*handler)
+	require.NoError(t, h.Initialize(courier.NewServer(config.NewTest(), mb)))
+	defer h.stopTransceivers()
+
+	require.Equal(t, 1, len(h.transceivers))
+	tr := h.transceivers[channel.UUID()]
+	require.NotNil(t, tr)
+	require.NoError(t, tr.waitBound())
+}
+
+func TestUnbindInactive(t *testing.T) {
+	smsc := newTestSMSC("courier", "sesame")
+	defer smsc.close()
+
+	mb := courier.NewMockBackend()
+	channel := newTestChannel(smsc, nil)
+	mb.AddChannel(channel)
+
+	h := NewHandler().(*handler)
+	require.NoError(t, h.Initialize(courier.NewServer(config.NewTest(), mb)))
+	defer h.stopTransceivers()
+
+	tr := h.transceivers[channel.UUID()]
+	require.NotNil(t, tr)
+	require.NoError(t, tr.waitBound())
+
+	// our channel is deactivated, refreshing unbinds it
+	mb.ClearChannels()
+	h.bindChannels()
+
+	assert.Equal(t, 0, len(h.transceivers))
+	_, _, unbinds := smsc.counts()
+	assert.Equal(t, 1, unbinds)
+	assert.EqualError(t, tr.waitBound(), "transceiver stopped")
+}
+
+func TestAlreadyBound(t *testing.T) {
+	h, _, _, smsc := newTestHandler(t)
+	defer smsc.close()
+	defer h.stopTransceivers()
+	smsc.setSingleBind()
+
+	channel := newTestChannel(smsc, nil)
+	tr, err := h.transceiver(channel)
+	require.NoError(t, err)
+	require.NoError(t, tr.waitBound())
+
+	// another instance binding the same channel is refused
+	other, _, _, _ := newTestHandler(t)
+	defer other.stopTransceivers()
+
+	otherTr, err := other.transceiver(channel)
+	require.NoError(t, err)
+	assert.EqualError(t, otherTr.waitBound(), "not bound to "+otherTr.addr)
+
+	// and backs off rather than trying again right away
+	time.Sleep(rebindDelay * 3)
+	binds, _, _ := smsc.counts()
+	assert.Equal(t, 2, binds)
+
+	// while the first instance keeps its session
+	status, err := h.SendMsg(courier.NewMockBackend().NewOutgoingMsg(channel, courier.NewMsgID(10), "tel:+250788383383", "Hello", courier.DefaultPriority))
+	require.NoError(t, err)
+	assert.Equal(t, courier.MsgWired, status.Status())
+}
+
+func TestBind(t *testing.T) {
+	h, _, s, smsc := newTestHandler(t)
+	defer smsc.close()
+	defer h.stopTransceivers()
+
+	channel := newTestChannel(smsc, map[string]interface{}{configPort: smsc.port()})
+	s.Backend().(*courier.MockBackend).AddChannel(channel)
+
+	req, _ := http.NewRequest("POST", "/c/smp/"+channelUUID+"/bind/", nil)
+	rr := httptest.NewRecorder()
+	s.Router().ServeHTTP(rr, req)
+
+	assert.Equal(t, 200, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"system_id":"courier"`)
+	assert.Contains(t, rr.Body.String(), `"bound":true`)
+
+	binds, _, _ := smsc.counts()
+	assert.Equal(t, 1, binds)
+
+	// binding again reuses our session
+	rr = httptest.NewRecorder()
+	s.Router().ServeHTTP(rr, req)
+	assert.Equal(t, 200, rr.Code)
+
+	binds, _, _ = smsc.counts()
+	assert.Equal(t, 1, binds)
+}
+
+func TestBadCredentials(t *testing.T) {
+	h, _, _, smsc := newTestHandler(t)
+	defer smsc.close()
+	defer h.stopTransceivers()
+
+	channel := newTestChannel(smsc, map[string]interface{}{courier.ConfigPassword: "wrong"})
+	tr, err := h.transceiver(channel)
+	require.NoError(t, err)
+	assert.EqualError(t, tr.waitBound(), "not bound to "+tr.addr)
+
+	// we keep trying to bind
+	binds, _, _ := smsc.counts()
+	assert.True(t, binds > 1)
+
+	// until our config is fixed
+	channel = newTestChannel(smsc, nil)
+	fixed, err := h.transceiver(channel)
+	require.NoError(t, err)
+	assert.NotEqual(t, tr, fixed)
+	assert.NoError(t, fixed.waitBound())
+
+	status, err := h.SendMsg(courier.NewMockBackend().NewOutgoingMsg(channel, courier.NewMsgID(10), "tel:+250788383383", "Hello", courier.DefaultPriority))
+	require.NoError(t, err)
+	assert.Equal(t, courier.MsgWired, status.Status())
+}
+
+func TestRebind(t *testing.T) {
+	h, mb, _, smsc := newTestHandler(t)
+	defer smsc.close()
+	defer h.stopTransceivers()
+
+	channel := newTestChannel(smsc, nil)
+	tr, err := h.transceiver(channel)
+	require.NoError(t, err)
+	require.NoError(t, tr.waitBound())
+
+	// our connection is dropped, we should bind again
+	smsc.dropConnections()
+	waitFor(t, func() bool {
+		binds, _, _ := smsc.counts()
+		return binds == 2
+	})
+
+	status, err := h.SendMsg(mb.NewOutgoingMsg(channel, courier.NewMsgID(10), "tel:+250788383383", "Hello", courier.DefaultPriority))
+	require.NoError(t, err)
+	assert.Equal(t, courier.MsgWired, status.Status())
+	assert.Equal(t, 1, len(smsc.getSubmitted()))
+}
+
+func TestEnquireLink(t *testing.T) {
+	h, _, _, smsc := newTestHandler(t)
+	defer smsc.close()
+	defer h.stopTransceivers()
+
+	tr, err := h.transceiver(newTestChannel(smsc, nil))
+	require.NoError(t, err)
+	require.NoError(t, tr.waitBound())
+
+	waitFor(t, func() bool {
+		_, enquireLinks, _ := smsc.counts()
+		return enquireLinks >= 2
+	})
+
+	// we stay bound the whole time
+	binds, _, _ := smsc.counts()
+	assert.Equal(t, 1, binds)
+}
+
+func TestStop(t *testing.T) {
+	h, _, _, smsc := newTestHandler(t)
+	defer smsc.close()
+
+	tr, err := h.transceiver(newTestChannel(smsc, nil))
+	require.NoError(t, err)
+	require.NoError(t, tr.waitBound())
+
+	h.stopTransceivers()
+
+	_, _, unbinds := smsc.counts()
+	assert.Equal(t, 1, unbinds)
+
+	// we don't try to bind again
+	time.Sleep(rebindDelay * 3)
+	binds, _, _ := smsc.counts()
+	assert.Equal(t, 1, binds)
+	assert.EqualError(t, tr.waitBound(), "transceiver stopped")
+}
@@ -4,3 +4,173 @@ package start
 POST /handlers/start/receive/uuid/
 <message><service type='sms' timestamp='1493792274' auth='1auth42d6e1aa608b6038' request_id='40599627'/><from>380975831111</from><to>4224</to><body>Msg</body></message>
 */
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+var sendURL = "http://bulk.startmobile.com.ua/clients.php"
+
+// the acknowledgement Start expects for every message we accept
+const ackResponse = `<answer type="async"><state>Accepted</state></answer>`
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Start Mobile handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("ST"), "Start Mobile")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	return s.AddReceiveMsgRoute(h, "POST", "receive", h.ReceiveMessage)
+}
+
+type stMessage struct {
+	XMLName xml.Name `xml:"message"`
+	Service struct {
+		Type      string `xml:"type,attr"`
+		Timestamp string `xml:"timestamp,attr"`
+		Auth      string `xml:"auth,attr"`
+		RequestID string `xml:"request_id,attr" validate:"required"`
+	} `xml:"service"`
+	From string `xml:"from" validate:"required"`
+	To   string `xml:"to" validate:"required"`
+	Body string `xml:"body"`
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	stMsg := &stMessage{}
+	err := handlers.DecodeAndValidateXML(stMsg, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// create our date from the timestamp
+	date := time.Now().UTC()
+	if stMsg.Service.Timestamp != "" {
+		ts, err := strconv.ParseInt(stMsg.Service.Timestamp, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp: %s", stMsg.Service.Timestamp)
+		}
+		date = time.Unix(ts, 0).UTC()
+	}
+
+	// create our URN
+	urn := courier.NewTelURNForChannel(stMsg.From, channel)
+
+	// build our msg
+	msg := h.Backend().NewIncomingMsg(channel, urn, stMsg.Body).WithExternalID(stMsg.Service.RequestID).WithReceivedOn(date)
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start expects an XML acknowledgement instead of our usual JSON response
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, err = fmt.Fprint(w, ackResponse)
+	return []courier.Msg{msg}, err
+}
+
+type stOutgoing struct {
+	XMLName xml.Name `xml:"message"`
+	Service struct {
+		ID       string `xml:"id,attr"`
+		Source   string `xml:"source,attr"`
+		Validity string `xml:"validity,attr"`
+	} `xml:"service"`
+	To   string `xml:"to"`
+	Body struct {
+		ContentType string `xml:"content-type,attr"`
+		Encoding    string `xml:"encoding,attr"`
+		Text        string `xml:",chardata"`
+	} `xml:"body"`
+}
+
+type stResponse struct {
+	XMLName xml.Name `xml:"status"`
+	ID      string   `xml:"id,attr"`
+	State   struct {
+		Error string `xml:"error,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"state"`
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
+	if username == "" {
+		return nil, fmt.Errorf("no username set for ST channel")
+	}
+
+	password := msg.Channel().StringConfigForKey(courier.ConfigPassword, "")
+	if password == "" {
+		return nil, fmt.Errorf("no password set for ST channel")
+	}
+
+	payload := &stOutgoing{}
+	payload.Service.ID = "single"
+	payload.Service.Source = msg.Channel().Address()
+	payload.Service.Validity = "+12 hours"
+	payload.To = strings.TrimPrefix(msg.URN().Path(), "+")
+	payload.Body.ContentType = "plain/text"
+	payload.Body.Encoding = "plain"
+	payload.Body.Text = courier.GetTextAndAttachments(msg)
+	body, _ := xml.Marshal(payload)
+
+	req, err := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/xml; charset=utf8")
+	req.SetBasicAuth(username, password)
+	rr, err := utils.MakeHTTPRequest(req)
+
+	// record our status and log
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+	status.AddLog(log)
+	if err != nil {
+		return status, nil
+	}
+
+	// parse our response, anything but an accepted state is an error
+	response := &stResponse{}
+	err = xml.Unmarshal(rr.Body, response)
+	if err != nil {
+		log.WithError("Message Send Error", errors.Errorf("unable to parse response body: %s", err))
+		return status, nil
+	}
+	if response.State.Value != "Accepted" {
+		log.WithError("Message Send Error", errors.Errorf("received state '%s' with error '%s'", response.State.Value, response.State.Error))
+		return status, nil
+	}
+	if response.ID == "" {
+		log.WithError("Message Send Error", errors.Errorf("no message id in response"))
+		return status, nil
+	}
+
+	status.SetStatus(courier.MsgWired)
+	status.SetExternalID(response.ID)
+
+	return status, nil
+}
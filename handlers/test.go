@@ -52,6 +52,10 @@ type ChannelSendTestCase struct {
 	URN         string
 	Attachments []string
 	Priority    courier.MsgPriority
+	URNAuth     string
+
+	ResponseToID         int64
+	ResponseToExternalID string
 
 	ResponseStatus int
 	ResponseBody   string
@@ -151,10 +155,19 @@ func RunChannelSendTestCases(t *testing.T, channel courier.Channel, handler cour
 			if testCase.Priority != 0 {
 				priority = testCase.Priority
 			}
-			msg := mb.NewOutgoingMsg(channel, courier.NewMsgID(10), courier.URN(testCase.URN), testCase.Text, priority)
+			var msg courier.Msg
+			if testCase.ResponseToID != 0 || testCase.ResponseToExternalID != "" {
+				msg = mb.NewOutgoingReply(channel, courier.NewMsgID(10), courier.URN(testCase.URN), testCase.Text, priority,
+					courier.NewMsgID(testCase.ResponseToID), testCase.ResponseToExternalID)
+			} else {
+				msg = mb.NewOutgoingMsg(channel, courier.NewMsgID(10), courier.URN(testCase.URN), testCase.Text, priority)
+			}
 			for _, a := range testCase.Attachments {
 				msg.WithAttachment(a)
 			}
+			if testCase.URNAuth != "" {
+				msg.WithURNAuth(testCase.URNAuth)
+			}
 
 			var testRequest *http.Request
 			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -284,7 +297,7 @@ func RunChannelTestCases(t *testing.T, channels []courier.Channel, handler couri
 	t.Run("Queue Error", func(t *testing.T) {
 		mb.SetErrorOnQueue(true)
 		defer mb.SetErrorOnQueue(false)
-		testHandlerRequest(t, s, validCase.URL, validCase.Data, 400, Sp("unable to queue message"), validCase.PrepRequest)
+		testHandlerRequest(t, s, validCase.URL, validCase.Data, 400, Sp("unable to queue"), validCase.PrepRequest)
 	})
 
 	t.Run("Receive With Invalid Channel", func(t *testing.T) {
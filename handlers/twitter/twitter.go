@@ -0,0 +1,643 @@
+package twitter
+
+/*
+GET /c/twt/uuid/receive/?crc_token=Kjt7Cdd2u6
+
+POST /c/twt/uuid/receive/
+{"for_user_id":"3107189279","direct_message_events":[{"type":"message_create","id":"958501034212564996","created_timestamp":"1517359429301","message_create":{"target":{"recipient_id":"3107189279"},"sender_id":"835740314006511618","message_data":{"text":"Hello World!"}}}],"users":{"835740314006511618":{"id":"835740314006511618","name":"Nic Pottier","screen_name":"nicpottier"}}}
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+// the config keys for the consumer key and secret of the Twitter app the channel belongs to
+const configAPIKey = "api_key"
+const configAPISecret = "api_secret"
+
+// the config keys for the access token and secret of the account the channel sends and receives as
+const configAccessToken = "access_token"
+const configAccessTokenSecret = "access_token_secret"
+
+// the config key for the user id of the account the channel sends and receives as
+const configHandleID = "handle_id"
+
+const twSignatureHeader = "X-Twitter-Webhooks-Signature"
+
+var sendURL = "https://api.twitter.com/1.1/direct_messages/events/new.json"
+var uploadURL = "https://upload.twitter.com/1.1/media/upload.json"
+
+// media attached to direct messages is served from this host and can only be downloaded with a signed request
+var mediaHost = "ton.twitter.com"
+
+// media is uploaded in chunks of this many bytes
+const uploadChunkSize = 1024 * 1024
+
+// the maximum number of times we check whether uploaded media has finished processing
+const maxStatusChecks = 10
+
+// the longest we wait in total for uploaded media to finish processing
+const maxProcessingWait = time.Second * 30
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Twitter handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("TWT"), "Twitter")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddReceiveMsgRoute(h, "POST", "receive", h.ReceiveMessage)
+	if err != nil {
+		return err
+	}
+
+	return s.AddChannelRoute(h, "GET", "receive", h.VerifyURL)
+}
+
+// VerifyURL is our HTTP handler function for the challenge response check Twitter makes when our webhook is
+// registered and periodically afterwards, see https://developer.twitter.com/en/docs/accounts-and-users/subscribe-account-activity/guides/securing-webhooks
+func (h *handler) VerifyURL(channel courier.Channel, w http.ResponseWriter, r *http.Request) error {
+	crcToken := r.URL.Query().Get("crc_token")
+	if crcToken == "" {
+		return fmt.Errorf("missing crc_token")
+	}
+
+	apiSecret := channel.StringConfigForKey(configAPISecret, "")
+	if apiSecret == "" {
+		return fmt.Errorf("invalid or missing api secret in config")
+	}
+
+	response, _ := json.Marshal(map[string]string{"response_token": calculateSignature(apiSecret, []byte(crcToken))})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err := w.Write(response)
+	return err
+}
+
+// {
+//   "for_user_id": "3107189279",
+//   "direct_message_events": [{
+//     "type": "message_create",
+//     "id": "958501034212564996",
+//     "created_timestamp": "1517359429301",
+//     "message_create": {
+//       "target": {"recipient_id": "3107189279"},
+//       "sender_id": "835740314006511618",
+//       "message_data": {
+//         "text": "Hello World! https://t.co/hFMXGiD9xV",
+//         "attachment": {
+//           "type": "media",
+//           "media": {
+//             "media_url_https": "https://ton.twitter.com/1.1/ton/data/dm/958501034212564996/958501023982419968/ngM5xb6T.jpg",
+//             "url": "https://t.co/hFMXGiD9xV",
+//             "type": "photo"
+//           }
+//         }
+//       }
+//     }
+//   }],
+//   "users": {
+//     "835740314006511618": {"id": "835740314006511618", "name": "Nic Pottier", "screen_name": "nicpottier"}
+//   }
+// }
+type twEnvelope struct {
+	ForUserID           string `json:"for_user_id"`
+	DirectMessageEvents []struct {
+		Type             string `json:"type"`
+		ID               string `json:"id"                 validate:"required"`
+		CreatedTimestamp string `json:"created_timestamp"  validate:"required"`
+		MessageCreate    *struct {
+			Target struct {
+				RecipientID string `json:"recipient_id"`
+			} `json:"target"`
+			SenderID    string `json:"sender_id"  validate:"required"`
+			MessageData struct {
+				Text       string `json:"text"`
+				Attachment *struct {
+					Type  string `json:"type"`
+					Media struct {
+						MediaURLHTTPS string `json:"media_url_https"`
+						URL           string `json:"url"`
+						Type          string `json:"type"`
+						VideoInfo     *struct {
+							Variants []struct {
+								Bitrate     int    `json:"bitrate"`
+								ContentType string `json:"content_type"`
+								URL         string `json:"url"`
+							} `json:"variants"`
+						} `json:"video_info"`
+					} `json:"media"`
+				} `json:"attachment"`
+			} `json:"message_data"`
+		} `json:"message_create"`
+	} `json:"direct_message_events"  validate:"dive"`
+	Users map[string]struct {
+		ID         string `json:"id"`
+		Name       string `json:"name"`
+		ScreenName string `json:"screen_name"`
+	} `json:"users"`
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages, Twitter sends all the account activity we
+// are subscribed to to this URL but we only deal with direct messages
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	err := h.validateSignature(channel, r)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &twEnvelope{}
+	err = handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, err
+	}
+
+	handleID := channel.StringConfigForKey(configHandleID, "")
+
+	msgs := make([]courier.Msg, 0, len(payload.DirectMessageEvents))
+	for _, event := range payload.DirectMessageEvents {
+		if event.Type != "message_create" || event.MessageCreate == nil {
+			continue
+		}
+
+		// messages we send show up here too, ignore them
+		senderID := event.MessageCreate.SenderID
+		if senderID == handleID {
+			continue
+		}
+
+		ts, err := strconv.ParseInt(event.CreatedTimestamp, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid created_timestamp: %s", event.CreatedTimestamp)
+		}
+		date := time.Unix(0, ts*int64(time.Millisecond)).UTC()
+
+		// we identify users by their id as screen names can change, the screen name is kept as the display
+		user := payload.Users[senderID]
+		urn, err := courier.NewURNFromParts(courier.TwitterScheme, senderID, user.ScreenName)
+		if err != nil {
+			return nil, err
+		}
+
+		text := event.MessageCreate.MessageData.Text
+		attachment := ""
+
+		if event.MessageCreate.MessageData.Attachment != nil {
+			media := event.MessageCreate.MessageData.Attachment.Media
+
+			// Twitter adds a link to the media to the text of the message, we don't need it
+			if media.URL != "" {
+				text = strings.TrimSpace(strings.Replace(text, media.URL, "", -1))
+			}
+
+			attachment = media.MediaURLHTTPS
+
+			// for videos and gifs that's a thumbnail, use the best mp4 variant instead
+			if media.VideoInfo != nil {
+				bitrate := -1
+				for _, variant := range media.VideoInfo.Variants {
+					if variant.ContentType == "video/mp4" && variant.Bitrate > bitrate {
+						attachment = variant.URL
+						bitrate = variant.Bitrate
+					}
+				}
+			}
+		}
+
+		// nothing to create a message from? skip it
+		if text == "" && attachment == "" {
+			continue
+		}
+
+		msg := h.Backend().NewIncomingMsg(channel, urn, text).WithExternalID(event.ID).WithReceivedOn(date)
+		if user.Name != "" {
+			msg.WithContactName(user.Name)
+		}
+		if attachment != "" {
+			msg.WithAttachment(attachment)
+		}
+
+		err = h.Backend().WriteMsg(msg)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	if len(msgs) == 0 {
+		return nil, courier.WriteIgnored(w, r, "Ignoring request, no message events")
+	}
+
+	return msgs, courier.WriteEventsSuccess(w, r, msgs, nil)
+}
+
+// BuildDownloadMediaRequest builds the request to fetch the passed in media URL, direct message media needs to be signed
+func (h *handler) BuildDownloadMediaRequest(channel courier.Channel, mediaURL string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.URL.Host == mediaHost {
+		creds, err := credentialsForChannel(channel)
+		if err != nil {
+			return nil, err
+		}
+		creds.sign(req)
+	}
+
+	return req, nil
+}
+
+type twMediaID struct {
+	ID string `json:"id"`
+}
+
+type twAttachment struct {
+	Type  string    `json:"type"`
+	Media twMediaID `json:"media"`
+}
+
+type twMessageData struct {
+	Text       string        `json:"text"`
+	Attachment *twAttachment `json:"attachment,omitempty"`
+}
+
+type twMessageCreate struct {
+	Target struct {
+		RecipientID string `json:"recipient_id"`
+	} `json:"target"`
+	MessageData twMessageData `json:"message_data"`
+}
+
+type twOutgoing struct {
+	Event struct {
+		Type          string          `json:"type"`
+		MessageCreate twMessageCreate `json:"message_create"`
+	} `json:"event"`
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	creds, err := credentialsForChannel(msg.Channel())
+	if err != nil {
+		return nil, err
+	}
+
+	// the status that will be written for this message
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+
+	// Twitter only takes images, gifs and videos, we send links to anything else
+	text := msg.Text()
+	media := make([]string, 0, len(msg.Attachments()))
+	for _, attachment := range msg.Attachments() {
+		mediaType, mediaURL := courier.SplitAttachment(attachment)
+		if mediaCategory(mediaType) == "" {
+			text = strings.TrimSpace(fmt.Sprintf("%s\n%s", text, mediaURL))
+		} else {
+			media = append(media, attachment)
+		}
+	}
+
+	// build up all the parts we need to send, a message can only carry one piece of media so the text goes with the
+	// first and any others are sent on their own
+	parts := make([]twMessageData, 0, len(media)+1)
+	if len(media) == 0 {
+		parts = append(parts, twMessageData{Text: text})
+	}
+	for i, attachment := range media {
+		mediaType, mediaURL := courier.SplitAttachment(attachment)
+		mediaID, err := h.uploadMedia(msg, status, creds, mediaType, mediaURL)
+		if err != nil {
+			return status, nil
+		}
+
+		part := twMessageData{Attachment: &twAttachment{Type: "media", Media: twMediaID{ID: mediaID}}}
+		if i == 0 {
+			part.Text = text
+		}
+		parts = append(parts, part)
+	}
+
+	for _, part := range parts {
+		payload := &twOutgoing{}
+		payload.Event.Type = "message_create"
+		payload.Event.MessageCreate.Target.RecipientID = msg.URN().Path()
+		payload.Event.MessageCreate.MessageData = part
+
+		body, _ := json.Marshal(payload)
+		req, err := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		creds.sign(req)
+		rr, err := utils.MakeHTTPRequest(req)
+
+		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+		status.AddLog(log)
+		if err != nil {
+			return status, nil
+		}
+
+		externalID, err := jsonparser.GetString(rr.Body, "event", "id")
+		if err != nil {
+			log.WithError("Message Send Error", errors.Errorf("unable to get event.id from body"))
+			return status, nil
+		}
+
+		// the first part is the one we track our status against
+		if status.ExternalID() == "" {
+			status.SetExternalID(externalID)
+		}
+	}
+
+	status.SetStatus(courier.MsgWired)
+	return status, nil
+}
+
+// mediaCategory returns the category Twitter needs to know media of the passed in type is for a direct message,
+// or the empty string if it can't be sent in one
+func mediaCategory(mediaType string) string {
+	switch {
+	case mediaType == "image/gif":
+		return "dm_gif"
+	case strings.HasPrefix(mediaType, "image/"):
+		return "dm_image"
+	case strings.HasPrefix(mediaType, "video/"):
+		return "dm_video"
+	}
+	return ""
+}
+
+// uploadMedia fetches the passed in media and uploads it to Twitter in chunks, returning the id of the uploaded media,
+// see https://developer.twitter.com/en/docs/media/upload-media/uploading-media/chunked-media-upload
+func (h *handler) uploadMedia(msg courier.Msg, status courier.MsgStatus, creds *oauthCredentials, mediaType string, mediaURL string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return "", err
+	}
+	rr, err := utils.MakeHTTPRequest(req)
+	status.AddLog(courier.NewChannelLogFromRR("Media Fetched", msg.Channel(), msg.ID(), rr).WithError("Media Fetch Error", err))
+	if err != nil {
+		return "", err
+	}
+	media := rr.Body
+
+	// all our upload parameters go in the query string as that's what gets signed
+	rr, err = h.uploadCommand(msg, status, creds, http.MethodPost, url.Values{
+		"command":        []string{"INIT"},
+		"total_bytes":    []string{strconv.Itoa(len(media))},
+		"media_type":     []string{mediaType},
+		"media_category": []string{mediaCategory(mediaType)},
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	mediaID, err := jsonparser.GetString(rr.Body, "media_id_string")
+	if err != nil {
+		err = errors.Errorf("unable to get media_id_string from body")
+		status.AddLog(courier.NewChannelLogFromRR("Media Uploaded", msg.Channel(), msg.ID(), rr).WithError("Media Upload Error", err))
+		return "", err
+	}
+
+	for segment := 0; segment*uploadChunkSize < len(media); segment++ {
+		end := (segment + 1) * uploadChunkSize
+		if end > len(media) {
+			end = len(media)
+		}
+
+		_, err = h.uploadCommand(msg, status, creds, http.MethodPost, url.Values{
+			"command":       []string{"APPEND"},
+			"media_id":      []string{mediaID},
+			"segment_index": []string{strconv.Itoa(segment)},
+		}, media[segment*uploadChunkSize:end])
+		if err != nil {
+			return "", err
+		}
+	}
+
+	rr, err = h.uploadCommand(msg, status, creds, http.MethodPost, url.Values{
+		"command":  []string{"FINALIZE"},
+		"media_id": []string{mediaID},
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// videos and gifs are processed before they can be sent, wait for that to finish but not for longer than we
+	// are willing to hold up sending
+	waited := time.Duration(0)
+	for checks := 0; ; checks++ {
+		state, _ := jsonparser.GetString(rr.Body, "processing_info", "state")
+		if state == "" || state == "succeeded" {
+			return mediaID, nil
+		}
+
+		checkAfterSecs, _ := jsonparser.GetInt(rr.Body, "processing_info", "check_after_secs")
+		checkAfter := time.Duration(checkAfterSecs) * time.Second
+
+		if state == "failed" || checks == maxStatusChecks || waited+checkAfter > maxProcessingWait {
+			err = errors.Errorf("media processing did not succeed, state '%s'", state)
+			status.AddLog(courier.NewChannelLogFromRR("Media Uploaded", msg.Channel(), msg.ID(), rr).WithError("Media Upload Error", err))
+			return "", err
+		}
+
+		time.Sleep(checkAfter)
+		waited += checkAfter
+
+		rr, err = h.uploadCommand(msg, status, creds, http.MethodGet, url.Values{
+			"command":  []string{"STATUS"},
+			"media_id": []string{mediaID},
+		}, nil)
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// uploadCommand makes a signed request to the media upload endpoint with the passed in parameters, and the passed in
+// chunk of media if there is one, logging the request against the passed in status
+func (h *handler) uploadCommand(msg courier.Msg, status courier.MsgStatus, creds *oauthCredentials, method string, params url.Values, chunk []byte) (*utils.RequestResponse, error) {
+	var req *http.Request
+	var err error
+	commandURL := fmt.Sprintf("%s?%s", uploadURL, params.Encode())
+
+	if chunk != nil {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("media", "media")
+		part.Write(chunk)
+		writer.Close()
+
+		req, err = http.NewRequest(method, commandURL, body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+	} else {
+		req, err = http.NewRequest(method, commandURL, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+	creds.sign(req)
+
+	rr, err := utils.MakeHTTPRequest(req)
+	status.AddLog(courier.NewChannelLogFromRR("Media Uploaded", msg.Channel(), msg.ID(), rr).WithError("Media Upload Error", err))
+	return rr, err
+}
+
+// see https://developer.twitter.com/en/docs/accounts-and-users/subscribe-account-activity/guides/securing-webhooks
+func (h *handler) validateSignature(channel courier.Channel, r *http.Request) error {
+	actual := r.Header.Get(twSignatureHeader)
+	if actual == "" {
+		return fmt.Errorf("missing request signature")
+	}
+
+	apiSecret := channel.StringConfigForKey(configAPISecret, "")
+	if apiSecret == "" {
+		return fmt.Errorf("invalid or missing api secret in config")
+	}
+
+	// read our body, we put it back afterwards so it can be decoded
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 100000))
+	r.Body.Close()
+	if err != nil {
+		return fmt.Errorf("unable to read request body: %s", err)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	expected := calculateSignature(apiSecret, body)
+
+	// compare signatures in way that isn't sensitive to a timing attack
+	if !hmac.Equal([]byte(expected), []byte(actual)) {
+		return fmt.Errorf("invalid request signature")
+	}
+	return nil
+}
+
+// calculateSignature returns the signature Twitter uses for the passed in content, sha256= followed by the base64 HMAC-SHA256
+func calculateSignature(apiSecret string, content []byte) string {
+	mac := hmac.New(sha256.New, []byte(apiSecret))
+	mac.Write(content)
+	return fmt.Sprintf("sha256=%s", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// oauthCredentials are what we need to sign requests to Twitter on behalf of a channel's account
+type oauthCredentials struct {
+	consumerKey    string
+	consumerSecret string
+	token          string
+	tokenSecret    string
+}
+
+// credentialsForChannel returns the OAuth credentials for the passed in channel
+func credentialsForChannel(channel courier.Channel) (*oauthCredentials, error) {
+	creds := &oauthCredentials{
+		consumerKey:    channel.StringConfigForKey(configAPIKey, ""),
+		consumerSecret: channel.StringConfigForKey(configAPISecret, ""),
+		token:          channel.StringConfigForKey(configAccessToken, ""),
+		tokenSecret:    channel.StringConfigForKey(configAccessTokenSecret, ""),
+	}
+	if creds.consumerKey == "" || creds.consumerSecret == "" || creds.token == "" || creds.tokenSecret == "" {
+		return nil, fmt.Errorf("missing api_key, api_secret, access_token or access_token_secret for TWT channel")
+	}
+	return creds, nil
+}
+
+// sign adds an OAuth 1.0a HMAC-SHA1 Authorization header to the passed in request
+func (c *oauthCredentials) sign(req *http.Request) {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	c.signWith(req, hex.EncodeToString(nonce), time.Now().Unix())
+}
+
+// signWith signs the passed in request using the passed in nonce and timestamp, see https://tools.ietf.org/html/rfc5849#section-3.4.
+// Note that only query parameters are signed, JSON and multipart bodies aren't part of the signature.
+func (c *oauthCredentials) signWith(req *http.Request, nonce string, timestamp int64) {
+	oauthParams := map[string]string{
+		"oauth_consumer_key":     c.consumerKey,
+		"oauth_nonce":            nonce,
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(timestamp, 10),
+		"oauth_token":            c.token,
+		"oauth_version":          "1.0",
+	}
+
+	// our signature covers our oauth parameters and those in our query string, encoded and sorted by key then value
+	params := make([][2]string, 0, len(oauthParams))
+	for key, value := range oauthParams {
+		params = append(params, [2]string{oauthEscape(key), oauthEscape(value)})
+	}
+	for key, values := range req.URL.Query() {
+		for _, value := range values {
+			params = append(params, [2]string{oauthEscape(key), oauthEscape(value)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] == params[j][0] {
+			return params[i][1] < params[j][1]
+		}
+		return params[i][0] < params[j][0]
+	})
+	pairs := make([]string, len(params))
+	for i, param := range params {
+		pairs[i] = fmt.Sprintf("%s=%s", param[0], param[1])
+	}
+
+	baseURL := fmt.Sprintf("%s://%s%s", strings.ToLower(req.URL.Scheme), strings.ToLower(req.URL.Host), req.URL.EscapedPath())
+	base := strings.Join([]string{req.Method, oauthEscape(baseURL), oauthEscape(strings.Join(pairs, "&"))}, "&")
+
+	mac := hmac.New(sha1.New, []byte(fmt.Sprintf("%s&%s", oauthEscape(c.consumerSecret), oauthEscape(c.tokenSecret))))
+	mac.Write([]byte(base))
+	oauthParams["oauth_signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	header := make([]string, 0, len(oauthParams))
+	for key, value := range oauthParams {
+		header = append(header, fmt.Sprintf(`%s="%s"`, oauthEscape(key), oauthEscape(value)))
+	}
+	sort.Strings(header)
+	req.Header.Set("Authorization", fmt.Sprintf("OAuth %s", strings.Join(header, ", ")))
+}
+
+// oauthEscape percent encodes the passed in value as OAuth requires, which is like query escaping but with spaces as %20
+func oauthEscape(value string) string {
+	return strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+}
@@ -0,0 +1,368 @@
+package twitter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/config"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/require"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "TWT", "tweeter", "",
+		map[string]interface{}{
+			configHandleID:          "835740314006511618",
+			configAPIKey:            "api_key",
+			configAPISecret:         "api_secret",
+			configAccessToken:       "access_token",
+			configAccessTokenSecret: "access_token_secret",
+		}),
+}
+
+var receiveURL = "/c/twt/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+
+var helloMsg = `{
+	"direct_message_events": [{
+		"type": "message_create",
+		"id": "958501034212564996",
+		"created_timestamp": "1517359429301",
+		"message_create": {
+			"target": {"recipient_id": "835740314006511618"},
+			"sender_id": "3107189279",
+			"message_data": {"text": "Hello World"}
+		}
+	}],
+	"users": {
+		"3107189279": {"id": "3107189279", "name": "Joe Smith", "screen_name": "joeXsmith"},
+		"835740314006511618": {"id": "835740314006511618", "name": "Tweeter", "screen_name": "tweeter"}
+	}
+}`
+
+var imageMsg = `{
+	"direct_message_events": [{
+		"type": "message_create",
+		"id": "958501034212564996",
+		"created_timestamp": "1517359429301",
+		"message_create": {
+			"target": {"recipient_id": "835740314006511618"},
+			"sender_id": "3107189279",
+			"message_data": {
+				"text": "Check this out https://t.co/hFMXGiD9xV",
+				"attachment": {
+					"type": "media",
+					"media": {
+						"media_url_https": "https://ton.twitter.com/1.1/ton/data/dm/958501034212564996/958501023982419968/ngM5xb6T.jpg",
+						"url": "https://t.co/hFMXGiD9xV",
+						"type": "photo"
+					}
+				}
+			}
+		}
+	}],
+	"users": {"3107189279": {"id": "3107189279", "name": "Joe Smith", "screen_name": "joeXsmith"}}
+}`
+
+var videoMsg = `{
+	"direct_message_events": [{
+		"type": "message_create",
+		"id": "958501034212564996",
+		"created_timestamp": "1517359429301",
+		"message_create": {
+			"target": {"recipient_id": "835740314006511618"},
+			"sender_id": "3107189279",
+			"message_data": {
+				"text": "https://t.co/hFMXGiD9xV",
+				"attachment": {
+					"type": "media",
+					"media": {
+						"media_url_https": "https://pbs.twimg.com/dm_video_preview/958501023982419968/img/thumb.jpg",
+						"url": "https://t.co/hFMXGiD9xV",
+						"type": "video",
+						"video_info": {"variants": [
+							{"bitrate": 832000, "content_type": "video/mp4", "url": "https://ton.twitter.com/1.1/ton/data/dm_video/958501023982419968/vid/640x360/low.mp4"},
+							{"content_type": "application/x-mpegURL", "url": "https://ton.twitter.com/1.1/ton/data/dm_video/958501023982419968/pl/playlist.m3u8"},
+							{"bitrate": 2176000, "content_type": "video/mp4", "url": "https://ton.twitter.com/1.1/ton/data/dm_video/958501023982419968/vid/1280x720/high.mp4"}
+						]}
+					}
+				}
+			}
+		}
+	}]
+}`
+
+var ourMsg = `{
+	"direct_message_events": [{
+		"type": "message_create",
+		"id": "958501034212564996",
+		"created_timestamp": "1517359429301",
+		"message_create": {
+			"target": {"recipient_id": "3107189279"},
+			"sender_id": "835740314006511618",
+			"message_data": {"text": "Hello World"}
+		}
+	}]
+}`
+
+var followEvent = `{
+	"follow_events": [{
+		"type": "follow",
+		"created_timestamp": "1517588749178",
+		"target": {"id": "835740314006511618"},
+		"source": {"id": "3107189279"}
+	}]
+}`
+
+var invalidTimestamp = `{
+	"direct_message_events": [{
+		"type": "message_create",
+		"id": "958501034212564996",
+		"created_timestamp": "2018-01-30",
+		"message_create": {
+			"target": {"recipient_id": "835740314006511618"},
+			"sender_id": "3107189279",
+			"message_data": {"text": "Hello World"}
+		}
+	}]
+}`
+
+var missingSender = `{
+	"direct_message_events": [{
+		"type": "message_create",
+		"id": "958501034212564996",
+		"created_timestamp": "1517359429301",
+		"message_create": {
+			"target": {"recipient_id": "835740314006511618"},
+			"message_data": {"text": "Hello World"}
+		}
+	}]
+}`
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid Message", URL: receiveURL, Data: helloMsg, Status: 200, Response: "Events Handled",
+		Name: Sp("Joe Smith"), Text: Sp("Hello World"), URN: Sp("twitter:3107189279#joexsmith"), External: Sp("958501034212564996"),
+		Date: Tp(time.Date(2018, 1, 31, 0, 43, 49, 301000000, time.UTC)), PrepRequest: addValidSignature},
+	{Label: "Receive Image Message", URL: receiveURL, Data: imageMsg, Status: 200, Response: "Events Handled",
+		Text: Sp("Check this out"), URN: Sp("twitter:3107189279#joexsmith"),
+		Attachment: Sp("https://ton.twitter.com/1.1/ton/data/dm/958501034212564996/958501023982419968/ngM5xb6T.jpg"), PrepRequest: addValidSignature},
+	{Label: "Receive Video Message", URL: receiveURL, Data: videoMsg, Status: 200, Response: "Events Handled",
+		Text: Sp(""), URN: Sp("twitter:3107189279"),
+		Attachment: Sp("https://ton.twitter.com/1.1/ton/data/dm_video/958501023982419968/vid/1280x720/high.mp4"), PrepRequest: addValidSignature},
+	{Label: "Receive Our Message", URL: receiveURL, Data: ourMsg, Status: 200, Response: "Ignoring request, no message events", PrepRequest: addValidSignature},
+	{Label: "Receive Follow", URL: receiveURL, Data: followEvent, Status: 200, Response: "Ignoring request, no message events", PrepRequest: addValidSignature},
+	{Label: "Receive Invalid Timestamp", URL: receiveURL, Data: invalidTimestamp, Status: 400, Response: "invalid created_timestamp: 2018-01-30", PrepRequest: addValidSignature},
+	{Label: "Receive Missing Sender", URL: receiveURL, Data: missingSender, Status: 400, Response: "Field validation for 'SenderID' failed", PrepRequest: addValidSignature},
+	{Label: "Receive Invalid Signature", URL: receiveURL, Data: helloMsg, Status: 400, Response: "invalid request signature", PrepRequest: addInvalidSignature},
+	{Label: "Receive Missing Signature", URL: receiveURL, Data: helloMsg, Status: 400, Response: "missing request signature"},
+
+	{Label: "Verify Valid", URL: receiveURL + "?crc_token=test_token", Status: 200, Response: `{"response_token":"sha256=2mGPFJP6/da/k1dfHLTH35nNfdUwkQZsP/r3cNy3A7A="}`},
+	{Label: "Verify Missing Token", URL: receiveURL, Status: 400, Response: "missing crc_token"},
+}
+
+func addValidSignature(r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.Header.Set(twSignatureHeader, calculateSignature("api_secret", body))
+}
+
+func addInvalidSignature(r *http.Request) {
+	r.Header.Set(twSignatureHeader, "sha256=invalidsig")
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	sendURL = server.URL
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "twitter:3107189279#joexsmith",
+		Status: "W", ExternalID: "133",
+		ResponseBody: `{"event":{"id":"133"}}`, ResponseStatus: 200,
+		Headers:     map[string]string{"Content-Type": "application/json"},
+		RequestBody: `{"event":{"type":"message_create","message_create":{"target":{"recipient_id":"3107189279"},"message_data":{"text":"Simple Message"}}}}`,
+		SendPrep:    setSendURL},
+	{Label: "Unicode Send",
+		Text: "☺", URN: "twitter:3107189279",
+		Status: "W", ExternalID: "133",
+		ResponseBody: `{"event":{"id":"133"}}`, ResponseStatus: 200,
+		RequestBody: `{"event":{"type":"message_create","message_create":{"target":{"recipient_id":"3107189279"},"message_data":{"text":"☺"}}}}`,
+		SendPrep:    setSendURL},
+	{Label: "Unsupported Attachment Send",
+		Text: "Listen", URN: "twitter:3107189279", Attachments: []string{"audio/mp3:https://foo.bar/audio.mp3"},
+		Status: "W", ExternalID: "133",
+		ResponseBody: `{"event":{"id":"133"}}`, ResponseStatus: 200,
+		RequestBody: `{"event":{"type":"message_create","message_create":{"target":{"recipient_id":"3107189279"},"message_data":{"text":"Listen\nhttps://foo.bar/audio.mp3"}}}}`,
+		SendPrep:    setSendURL},
+	{Label: "No Event ID",
+		Text: "Error", URN: "twitter:3107189279",
+		Status:       "E",
+		ResponseBody: `{"event":{}}`, ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Error Sending",
+		Text: "Error", URN: "twitter:3107189279",
+		Status:       "E",
+		ResponseBody: `{"errors":[{"code":150,"message":"You cannot send messages to users who are not following you."}]}`, ResponseStatus: 403,
+		SendPrep: setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	RunChannelSendTestCases(t, testChannels[0], NewHandler(), defaultSendTestCases)
+}
+
+func TestSendingMedia(t *testing.T) {
+	requests := make([]string, 0)
+	chunks := make([]string, 0)
+	processing := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.URL.Query().Get("command"))
+
+		// every request to Twitter must be signed
+		if !strings.HasPrefix(r.URL.Path, "/media/") && !strings.HasPrefix(r.Header.Get("Authorization"), `OAuth oauth_consumer_key="api_key", oauth_nonce=`) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/media/dog.jpg":
+			w.Write([]byte("dogdogdog"))
+		case "/media/cat.mp4":
+			w.Write([]byte("catcatcat"))
+		case "/media/slow.gif":
+			w.Write([]byte("slowslow"))
+		case "/upload":
+			switch r.URL.Query().Get("command") {
+			case "INIT":
+				w.Write([]byte(`{"media_id_string":"` + r.URL.Query().Get("media_category") + `"}`))
+			case "APPEND":
+				file, _, err := r.FormFile("media")
+				require.NoError(t, err)
+				chunk, _ := ioutil.ReadAll(file)
+				chunks = append(chunks, r.URL.Query().Get("media_id")+":"+string(chunk))
+				w.WriteHeader(http.StatusNoContent)
+			case "FINALIZE", "STATUS":
+				// videos take a while to process
+				if r.URL.Query().Get("media_id") == "dm_video" && processing < 2 {
+					processing++
+					w.Write([]byte(`{"media_id_string":"dm_video","processing_info":{"state":"in_progress","check_after_secs":0}}`))
+					return
+				}
+
+				// and gifs take longer than we are willing to wait
+				if r.URL.Query().Get("media_id") == "dm_gif" {
+					w.Write([]byte(`{"media_id_string":"dm_gif","processing_info":{"state":"in_progress","check_after_secs":3600}}`))
+					return
+				}
+				w.Write([]byte(`{"media_id_string":"` + r.URL.Query().Get("media_id") + `","processing_info":{"state":"succeeded"}}`))
+			}
+		case "/send":
+			body, _ := ioutil.ReadAll(r.Body)
+			chunks = append(chunks, string(body))
+			w.Write([]byte(`{"event":{"id":"133"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	sendURL = server.URL + "/send"
+	uploadURL = server.URL + "/upload"
+
+	mb := courier.NewMockBackend()
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	msg := mb.NewOutgoingMsg(testChannels[0], courier.NewMsgID(10), courier.URN("twitter:3107189279"), "Pets", courier.DefaultPriority)
+	msg.WithAttachment("image/jpeg:" + server.URL + "/media/dog.jpg")
+	msg.WithAttachment("video/mp4:" + server.URL + "/media/cat.mp4")
+
+	status, err := h.SendMsg(msg)
+	require.NoError(t, err)
+	require.Equal(t, courier.MsgWired, status.Status())
+	require.Equal(t, "133", status.ExternalID())
+	require.Equal(t, []string{
+		"GET /media/dog.jpg ", "POST /upload INIT", "POST /upload APPEND", "POST /upload FINALIZE",
+		"GET /media/cat.mp4 ", "POST /upload INIT", "POST /upload APPEND", "POST /upload FINALIZE", "GET /upload STATUS", "GET /upload STATUS",
+		"POST /send ", "POST /send ",
+	}, requests)
+	require.Equal(t, []string{
+		"dm_image:dogdogdog",
+		"dm_video:catcatcat",
+		`{"event":{"type":"message_create","message_create":{"target":{"recipient_id":"3107189279"},"message_data":{"text":"Pets","attachment":{"type":"media","media":{"id":"dm_image"}}}}}}`,
+		`{"event":{"type":"message_create","message_create":{"target":{"recipient_id":"3107189279"},"message_data":{"text":"","attachment":{"type":"media","media":{"id":"dm_video"}}}}}}`,
+	}, chunks)
+	require.Equal(t, len(requests), len(status.Logs()))
+
+	// media that can't be fetched means we don't send anything
+	requests = requests[:0]
+	msg = mb.NewOutgoingMsg(testChannels[0], courier.NewMsgID(10), courier.URN("twitter:3107189279"), "Pets", courier.DefaultPriority)
+	msg.WithAttachment("image/jpeg:" + server.URL + "/media/missing.jpg")
+
+	status, err = h.SendMsg(msg)
+	require.NoError(t, err)
+	require.Equal(t, courier.MsgErrored, status.Status())
+	require.Equal(t, []string{"GET /media/missing.jpg "}, requests)
+
+	// and neither does media which would take too long to process
+	requests = requests[:0]
+	msg = mb.NewOutgoingMsg(testChannels[0], courier.NewMsgID(10), courier.URN("twitter:3107189279"), "Pets", courier.DefaultPriority)
+	msg.WithAttachment("image/gif:" + server.URL + "/media/slow.gif")
+
+	status, err = h.SendMsg(msg)
+	require.NoError(t, err)
+	require.Equal(t, courier.MsgErrored, status.Status())
+	require.Equal(t, []string{"GET /media/slow.gif ", "POST /upload INIT", "POST /upload APPEND", "POST /upload FINALIZE"}, requests)
+
+	// we can't send at all if we can't build our request
+	sendURL = ":bad"
+	msg = mb.NewOutgoingMsg(testChannels[0], courier.NewMsgID(10), courier.URN("twitter:3107189279"), "Pets", courier.DefaultPriority)
+
+	_, err = h.SendMsg(msg)
+	require.Error(t, err)
+}
+
+func TestBuildDownloadMediaRequest(t *testing.T) {
+	h := NewHandler().(*handler)
+
+	// direct message media needs to be signed
+	req, err := h.BuildDownloadMediaRequest(testChannels[0], "https://ton.twitter.com/1.1/ton/data/dm/958501034212564996/958501023982419968/ngM5xb6T.jpg")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "OAuth "))
+
+	// other media doesn't
+	req, err = h.BuildDownloadMediaRequest(testChannels[0], "https://foo.bar/image.jpg")
+	require.NoError(t, err)
+	require.Equal(t, "", req.Header.Get("Authorization"))
+}
+
+func TestOAuthSignature(t *testing.T) {
+	// the example from https://developer.twitter.com/en/docs/basics/authentication/guides/creating-a-signature
+	creds := &oauthCredentials{
+		consumerKey:    "xvz1evFS4wEEPTGEFPHBog",
+		consumerSecret: "kAcSOqF21Fu85e7zjz7ZN2U4ZRhfV3WpwPAoE3Z7kBw",
+		token:          "370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb",
+		tokenSecret:    "LswwdoUaIvS8ltyTt5jkRh4J50vUPVVHtR2YPi5kE",
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "https://api.twitter.com/1.1/statuses/update.json?include_entities=true&status=Hello%20Ladies%20%2b%20Gentlemen%2c%20a%20signed%20OAuth%20request%21", nil)
+	creds.signWith(req, "kYjzVBB8Y0ZFabxSWbWovY3uYSQ2pTgmZeNu2VS4cg", 1318622958)
+
+	require.Equal(t, `OAuth oauth_consumer_key="xvz1evFS4wEEPTGEFPHBog", oauth_nonce="kYjzVBB8Y0ZFabxSWbWovY3uYSQ2pTgmZeNu2VS4cg", `+
+		`oauth_signature="hCtSmYh%2BiHYCEqBWrE7C7hYmtUk%3D", oauth_signature_method="HMAC-SHA1", oauth_timestamp="1318622958", `+
+		`oauth_token="370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb", oauth_version="1.0"`, req.Header.Get("Authorization"))
+}
@@ -1,3 +1,165 @@
 package verboice
 
-/* no logs */
+/*
+POST /c/vb/uuid/status/
+CallSid=12345&CallStatus=completed&From=%2B250788383383&To=%2B250788123123
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+// the config key for the name of the channel on Verboice which places our calls
+const configChannel = "channel"
+
+var callURL = "https://verboice.instedd.org/api/call"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Verboice handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("VB"), "Verboice")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddCallEventRoute(h, "POST", "status", h.CallStatus)
+	if err != nil {
+		return err
+	}
+
+	return s.AddCallEventRoute(h, "GET", "status", h.CallStatus)
+}
+
+type vbStatus struct {
+	CallSid    string `validate:"required"  name:"CallSid"`
+	CallStatus string `validate:"required"  name:"CallStatus"`
+	From       string `name:"From"`
+	To         string `name:"To"`
+}
+
+var vbStatusMapping = map[string]courier.CallStatusValue{
+	"queued":      courier.CallQueued,
+	"ringing":     courier.CallRinging,
+	"in-progress": courier.CallInProgress,
+	"completed":   courier.CallCompleted,
+	"busy":        courier.CallBusy,
+	"failed":      courier.CallFailed,
+	"no-answer":   courier.CallNoAnswer,
+	"canceled":    courier.CallCanceled,
+}
+
+// CallStatus is our HTTP handler function for status updates about calls we started
+func (h *handler) CallStatus(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.CallEvent, error) {
+	vbStatus := &vbStatus{}
+	err := handlers.DecodeAndValidateForm(vbStatus, r)
+	if err != nil {
+		return nil, err
+	}
+
+	callStatus, found := vbStatusMapping[vbStatus.CallStatus]
+	if !found {
+		return nil, fmt.Errorf("unknown call status '%s', must be one of queued, ringing, in-progress, completed, busy, failed, no-answer or canceled", vbStatus.CallStatus)
+	}
+
+	// calls are identified by the id Verboice gave them when we started them
+	event := h.Backend().NewCallEventForExternalID(channel, vbStatus.CallSid, callStatus)
+	err = h.Backend().WriteCallEvent(event)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.CallEvent{event}, courier.WriteCallEventSuccess(w, r, event)
+}
+
+// SendMsg sends the passed in message, Verboice can't send text so we reach the contact by starting a call to them,
+// the message is wired once the call is and takes the id of the call as its external id
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	event, err := h.startCall(msg.Channel(), msg.URN(), msg.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	for _, log := range event.Logs() {
+		status.AddLog(log)
+	}
+	if event.Status() == courier.CallWired {
+		status.SetStatus(courier.MsgWired)
+		status.SetExternalID(event.ExternalID())
+	}
+	return status, nil
+}
+
+// StartCall starts an outgoing call to the passed in URN, Verboice will report the state of the call to our status URL
+func (h *handler) StartCall(channel courier.Channel, urn courier.URN) (courier.CallEvent, error) {
+	return h.startCall(channel, urn, courier.NilMsgID)
+}
+
+// startCall starts an outgoing call to the passed in URN, logging it against the passed in msg id if there is one
+func (h *handler) startCall(channel courier.Channel, urn courier.URN, msgID courier.MsgID) (courier.CallEvent, error) {
+	username := channel.StringConfigForKey(courier.ConfigUsername, "")
+	if username == "" {
+		return nil, fmt.Errorf("no username set for VB channel")
+	}
+
+	password := channel.StringConfigForKey(courier.ConfigPassword, "")
+	if password == "" {
+		return nil, fmt.Errorf("no password set for VB channel")
+	}
+
+	vbChannel := channel.StringConfigForKey(configChannel, "")
+	if vbChannel == "" {
+		return nil, fmt.Errorf("no channel set for VB channel")
+	}
+
+	statusURL := fmt.Sprintf("%s/c/vb/%s/status", h.Server().Config().BaseURL, channel.UUID())
+
+	form := url.Values{
+		"channel":             []string{vbChannel},
+		"address":             []string{urn.Path()},
+		"status_callback_url": []string{statusURL},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s?%s", callURL, form.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(username, password)
+	rr, err := utils.MakeHTTPRequest(req)
+
+	// we don't know the id of the call until Verboice tells us
+	event := h.Backend().NewCallEventForExternalID(channel, "", courier.CallFailed)
+	log := courier.NewChannelLogFromRR("Call Started", channel, msgID, rr).WithError("Call Start Error", err)
+	event.AddLog(log)
+	if err != nil {
+		return event, nil
+	}
+
+	callID, err := jsonparser.GetInt(rr.Body, "call_id")
+	if err != nil {
+		log.WithError("Call Start Error", errors.Errorf("unable to get call_id from body"))
+		return event, nil
+	}
+
+	event.SetStatus(courier.CallWired)
+	event.SetExternalID(strconv.FormatInt(callID, 10))
+	return event, nil
+}
@@ -10,3 +10,338 @@ POST /handlers/viber_public/uuid?sig=sig
 POST /handlers/viber_public/uuid?sig=sig
 {"event":"message","timestamp":1493814248770,"message_token":50405319809731111,"sender":{"id":"iu7u0ekVY01115lOIg==","name":"User name","avatar":"https://avatar.jpg","language":"en","country":"PK","api_version":2},"message":{"text":"Msg","type":"text","tracking_data":"579777865"},"silent":false}
 */
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+const viberSignatureHeader = "X-Viber-Content-Signature"
+
+// the config key for the name our messages are sent as, defaults to the channel address
+const configSenderName = "sender_name"
+
+var sendURL = "https://chatapi.viber.com/pa/send_message"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Viber handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("VP"), "Viber")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	return s.AddReceiveMsgAndStatusRoute(h, "POST", "receive", h.ReceiveEvent)
+}
+
+var viberStatusMapping = map[string]courier.MsgStatusValue{
+	"delivered": courier.MsgDelivered,
+	"seen":      courier.MsgDelivered,
+	"failed":    courier.MsgFailed,
+}
+
+// ReceiveEvent is our HTTP handler function for incoming messages and events, Viber sends everything to one URL
+func (h *handler) ReceiveEvent(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, []courier.MsgStatus, error) {
+	err := h.validateSignature(channel, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	payload := &viberEnvelope{}
+	err = handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch payload.Event {
+	case "message":
+		msgs, err := h.receiveMessage(channel, w, r, payload)
+		return msgs, nil, err
+
+	case "delivered", "seen", "failed":
+		statuses, err := h.receiveStatus(channel, w, r, payload)
+		return nil, statuses, err
+
+	default:
+		// webhook, subscribed, unsubscribed and conversation_started need no action on our part
+		return nil, nil, courier.WriteIgnored(w, r, fmt.Sprintf("Ignoring '%s' event", payload.Event))
+	}
+}
+
+// receiveStatus handles a delivered, seen or failed event for one of our outgoing messages
+func (h *handler) receiveStatus(channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *viberEnvelope) ([]courier.MsgStatus, error) {
+	// message tokens are the external ids of our outgoing messages
+	status := h.Backend().NewMsgStatusForExternalID(channel, strconv.FormatInt(payload.MessageToken, 10), viberStatusMapping[payload.Event])
+	err := h.Backend().WriteMsgStatus(status)
+	if err == courier.ErrMsgNotFound {
+		return nil, courier.WriteIgnored(w, r, fmt.Sprintf("Ignoring '%s' event, no message found", payload.Event))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []courier.MsgStatus{status}, courier.WriteStatusSuccess(w, r, status)
+}
+
+// receiveMessage handles a message event from a user
+func (h *handler) receiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request, payload *viberEnvelope) ([]courier.Msg, error) {
+	if payload.Sender.ID == "" {
+		return nil, errors.New("missing sender id in message event")
+	}
+
+	// create our URN
+	urn, err := courier.NewURNFromParts(courier.ViberScheme, payload.Sender.ID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	text := payload.Message.Text
+	mediaURL := ""
+
+	switch payload.Message.Type {
+	case "text":
+		// nothing more to do
+
+	case "picture", "video", "file", "sticker":
+		mediaURL = payload.Message.Media
+
+	case "location":
+		text = fmt.Sprintf("%f,%f", payload.Message.Location.Latitude, payload.Message.Location.Longitude)
+		mediaURL = fmt.Sprintf("geo:%f,%f", payload.Message.Location.Latitude, payload.Message.Location.Longitude)
+
+	case "contact":
+		phone := ""
+		if payload.Message.Contact.PhoneNumber != "" {
+			phone = fmt.Sprintf("(%s)", payload.Message.Contact.PhoneNumber)
+		}
+		text = utils.JoinNonEmpty(" ", payload.Message.Contact.Name, phone)
+
+	default:
+		return nil, courier.WriteIgnored(w, r, fmt.Sprintf("Ignoring unknown message type '%s'", payload.Message.Type))
+	}
+
+	// build our msg
+	date := time.Unix(0, payload.Timestamp*int64(time.Millisecond)).UTC()
+	msg := h.Backend().NewIncomingMsg(channel, urn, text).WithExternalID(strconv.FormatInt(payload.MessageToken, 10)).WithReceivedOn(date)
+	msg.WithContactName(payload.Sender.Name)
+
+	if mediaURL != "" {
+		msg.WithAttachment(mediaURL)
+	}
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, courier.WriteReceiveSuccess(w, r, msg)
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	authToken := msg.Channel().StringConfigForKey(courier.ConfigAuthToken, "")
+	if authToken == "" {
+		return nil, fmt.Errorf("missing auth token for VP channel")
+	}
+
+	sender := viberSender{Name: msg.Channel().StringConfigForKey(configSenderName, msg.Channel().Address())}
+
+	// the status that will be written for this message
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+
+	// build up all the parts we need to send, text first then each attachment
+	parts := make([]*viberOutgoing, 0, len(msg.Attachments())+1)
+	if msg.Text() != "" {
+		parts = append(parts, &viberOutgoing{Type: "text", Text: msg.Text()})
+	}
+	for _, attachment := range msg.Attachments() {
+		mediaType, mediaURL := courier.SplitAttachment(attachment)
+		switch strings.Split(mediaType, "/")[0] {
+		case "image":
+			parts = append(parts, &viberOutgoing{Type: "picture", Media: mediaURL})
+		default:
+			// Viber requires sizes for videos and files which we don't know, send these as links instead
+			parts = append(parts, &viberOutgoing{Type: "url", Media: mediaURL})
+		}
+	}
+
+	for _, part := range parts {
+		part.AuthToken = authToken
+		part.Receiver = msg.URN().Path()
+		part.Sender = sender
+		part.TrackingData = msg.ID().String()
+
+		externalID, log, err := h.sendMsgPart(msg, authToken, part)
+
+		// no log means we couldn't even build our request
+		if log == nil {
+			return nil, err
+		}
+		status.AddLog(log)
+		if err != nil {
+			return status, nil
+		}
+
+		// the first part is the one we track our status against
+		if status.ExternalID() == "" {
+			status.SetExternalID(externalID)
+		}
+	}
+
+	status.SetStatus(courier.MsgWired)
+	return status, nil
+}
+
+func (h *handler) sendMsgPart(msg courier.Msg, authToken string, part *viberOutgoing) (string, *courier.ChannelLog, error) {
+	body, _ := json.Marshal(part)
+	req, err := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(body))
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Viber-Auth-Token", authToken)
+	rr, err := utils.MakeHTTPRequest(req)
+
+	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+	if err != nil {
+		return "", log, err
+	}
+
+	// a status of 0 means success, anything else is an error
+	responseStatus, err := jsonparser.GetInt(rr.Body, "status")
+	if err != nil || responseStatus != 0 {
+		statusMessage, _ := jsonparser.GetString(rr.Body, "status_message")
+		err = errors.Errorf("received non-zero status from Viber: %d '%s'", responseStatus, statusMessage)
+		log.WithError("Message Send Error", err)
+		return "", log, err
+	}
+
+	externalID, err := jsonparser.GetInt(rr.Body, "message_token")
+	if err != nil {
+		err = errors.Errorf("no 'message_token' in response")
+		log.WithError("Message Send Error", err)
+		return "", log, err
+	}
+
+	return strconv.FormatInt(externalID, 10), log, nil
+}
+
+// see https://developers.viber.com/docs/api/rest-bot-api/#callbacks
+func (h *handler) validateSignature(channel courier.Channel, r *http.Request) error {
+	actual := r.Header.Get(viberSignatureHeader)
+	if actual == "" {
+		return fmt.Errorf("missing request signature")
+	}
+
+	authToken := channel.StringConfigForKey(courier.ConfigAuthToken, "")
+	if authToken == "" {
+		return fmt.Errorf("invalid or missing auth token in config")
+	}
+
+	// read our body, we put it back afterwards so it can be decoded
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 100000))
+	r.Body.Close()
+	if err != nil {
+		return fmt.Errorf("unable to read request body: %s", err)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	expected := calculateSignature(authToken, body)
+
+	// compare signatures in way that isn't sensitive to a timing attack
+	if !hmac.Equal([]byte(expected), []byte(actual)) {
+		return fmt.Errorf("invalid request signature")
+	}
+	return nil
+}
+
+// calculateSignature returns the hex encoded HMAC-SHA256 of the passed in body
+func calculateSignature(authToken string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(authToken))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type viberSender struct {
+	Name   string `json:"name"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type viberOutgoing struct {
+	AuthToken    string      `json:"auth_token"`
+	Receiver     string      `json:"receiver"`
+	Sender       viberSender `json:"sender"`
+	TrackingData string      `json:"tracking_data"`
+	Type         string      `json:"type"`
+	Text         string      `json:"text,omitempty"`
+	Media        string      `json:"media,omitempty"`
+}
+
+// {
+//   "event": "message",
+//   "timestamp": 1493814248770,
+//   "message_token": 50405319809731111,
+//   "sender": {
+//     "id": "iu7u0ekVY01115lOIg==",
+//     "name": "User name",
+//     "avatar": "https://avatar.jpg",
+//     "language": "en",
+//     "country": "PK",
+//     "api_version": 2
+//   },
+//   "message": {
+//     "text": "Msg",
+//     "type": "text",
+//     "tracking_data": "579777865"
+//   },
+//   "silent": false
+// }
+type viberEnvelope struct {
+	Event        string `json:"event"          validate:"required"`
+	Timestamp    int64  `json:"timestamp"`
+	MessageToken int64  `json:"message_token"`
+	UserID       string `json:"user_id"`
+	Sender       struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"sender"`
+	Message struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Media    string `json:"media"`
+		FileName string `json:"file_name"`
+		Location struct {
+			Latitude  float64 `json:"lat"`
+			Longitude float64 `json:"lon"`
+		} `json:"location"`
+		Contact struct {
+			Name        string `json:"name"`
+			PhoneNumber string `json:"phone_number"`
+		} `json:"contact"`
+	} `json:"message"`
+}
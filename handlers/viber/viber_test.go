@@ -0,0 +1,152 @@
+package viber
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/config"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "VP", "2020", "", map[string]interface{}{"auth_token": "Token"}),
+}
+
+var (
+	receiveURL = "/c/vp/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+
+	receiveValid = `{"event":"message","timestamp":1493814248770,"message_token":50405319809731111,"sender":{"id":"xy5/5y6O81+/kbWHpLhBoA==","name":"User name","avatar":"https://avatar.jpg","language":"en","country":"PK","api_version":2},"message":{"text":"Msg","type":"text","tracking_data":"579777865"},"silent":false}`
+
+	receivePicture = `{"event":"message","timestamp":1493814248770,"message_token":50405319809731111,"sender":{"id":"xy5/5y6O81+/kbWHpLhBoA==","name":"User name"},"message":{"text":"Caption","type":"picture","media":"https://viber.com/image.jpg"}}`
+
+	receiveLocation = `{"event":"message","timestamp":1493814248770,"message_token":50405319809731111,"sender":{"id":"xy5/5y6O81+/kbWHpLhBoA==","name":"User name"},"message":{"type":"location","location":{"lat":1.2,"lon":-1.3}}}`
+
+	receiveContact = `{"event":"message","timestamp":1493814248770,"message_token":50405319809731111,"sender":{"id":"xy5/5y6O81+/kbWHpLhBoA==","name":"User name"},"message":{"type":"contact","contact":{"name":"Alex","phone_number":"+12065551212"}}}`
+
+	receiveUnknownType = `{"event":"message","timestamp":1493814248770,"message_token":50405319809731111,"sender":{"id":"xy5/5y6O81+/kbWHpLhBoA==","name":"User name"},"message":{"type":"rich_media"}}`
+
+	receiveNoSender = `{"event":"message","timestamp":1493814248770,"message_token":50405319809731111,"message":{"text":"Msg","type":"text"}}`
+
+	receiveSubscribed = `{"event":"subscribed","timestamp":1457764197627,"user":{"id":"01234567890A=","name":"John McClane"},"message_token":4912661846655238145}`
+
+	statusDelivered = `{"event":"delivered","timestamp":1493817791212,"message_token":504054678623710111,"user_id":"Iul/YIu1tJwyRWKkx7Pxyw=="}`
+	statusFailed    = `{"event":"failed","timestamp":1493817791212,"message_token":504054678623710111,"user_id":"Iul/YIu1tJwyRWKkx7Pxyw==","desc":"failure"}`
+)
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid", URL: receiveURL, Data: receiveValid, Status: 200, Response: "Accepted",
+		Text: Sp("Msg"), URN: Sp("viber:xy5/5y6O81+/kbWHpLhBoA=="), Name: Sp("User name"), External: Sp("50405319809731111"),
+		Date: Tp(time.Date(2017, 5, 3, 12, 24, 8, 770000000, time.UTC)), PrepRequest: addValidSignature},
+	{Label: "Receive Picture", URL: receiveURL, Data: receivePicture, Status: 200, Response: "Accepted",
+		Text: Sp("Caption"), URN: Sp("viber:xy5/5y6O81+/kbWHpLhBoA=="), Attachment: Sp("https://viber.com/image.jpg"), PrepRequest: addValidSignature},
+	{Label: "Receive Location", URL: receiveURL, Data: receiveLocation, Status: 200, Response: "Accepted",
+		Text: Sp("1.200000,-1.300000"), Attachment: Sp("geo:1.200000,-1.300000"), PrepRequest: addValidSignature},
+	{Label: "Receive Contact", URL: receiveURL, Data: receiveContact, Status: 200, Response: "Accepted",
+		Text: Sp("Alex (+12065551212)"), PrepRequest: addValidSignature},
+	{Label: "Receive Unknown Type", URL: receiveURL, Data: receiveUnknownType, Status: 200, Response: "Ignoring unknown message type",
+		PrepRequest: addValidSignature},
+	{Label: "Receive No Sender", URL: receiveURL, Data: receiveNoSender, Status: 400, Response: "missing sender id",
+		PrepRequest: addValidSignature},
+	{Label: "Receive Subscribed", URL: receiveURL, Data: receiveSubscribed, Status: 200, Response: "Ignoring 'subscribed' event",
+		PrepRequest: addValidSignature},
+	{Label: "Receive Invalid Signature", URL: receiveURL, Data: receiveValid, Status: 400, Response: "invalid request signature",
+		PrepRequest: addInvalidSignature},
+	{Label: "Receive Missing Signature", URL: receiveURL, Data: receiveValid, Status: 400, Response: "missing request signature"},
+	{Label: "Status Delivered", URL: receiveURL, Data: statusDelivered, Status: 200, Response: `"status":"D"`,
+		PrepRequest: addValidSignature},
+	{Label: "Status Failed", URL: receiveURL, Data: statusFailed, Status: 200, Response: `"status":"F"`,
+		PrepRequest: addValidSignature},
+}
+
+func addValidSignature(r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.Header.Set(viberSignatureHeader, calculateSignature("Token", body))
+}
+
+func addInvalidSignature(r *http.Request) {
+	r.Header.Set(viberSignatureHeader, "invalidsig")
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func TestStatusEvents(t *testing.T) {
+	mb := courier.NewMockBackend()
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	// statuses should be returned as statuses so they aren't logged as failed receives
+	r := httptest.NewRequest(http.MethodPost, receiveURL, strings.NewReader(statusDelivered))
+	addValidSignature(r)
+
+	msgs, statuses, err := h.ReceiveEvent(testChannels[0], httptest.NewRecorder(), r)
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+	require.Equal(t, 1, len(statuses))
+	assert.Equal(t, "504054678623710111", statuses[0].ExternalID())
+	assert.Equal(t, courier.MsgDelivered, statuses[0].Status())
+
+	status, err := mb.GetLastMsgStatus()
+	require.NoError(t, err)
+	assert.Equal(t, statuses[0], status)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setSendURL takes care of setting the send_url to our test server host
+func setSendURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	sendURL = server.URL
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "viber:xy5/5y6O81+/kbWHpLhBoA==",
+		Status: "W", ExternalID: "4987381194038857789",
+		ResponseBody: `{"status":0,"status_message":"ok","message_token":4987381194038857789}`, ResponseStatus: 200,
+		Headers:     map[string]string{"X-Viber-Auth-Token": "Token"},
+		RequestBody: `{"auth_token":"Token","receiver":"xy5/5y6O81+/kbWHpLhBoA==","sender":{"name":"Courier"},"tracking_data":"10","type":"text","text":"Simple Message"}`,
+		SendPrep:    setSendURL},
+	{Label: "Send Attachment",
+		Text: "", URN: "viber:xy5/5y6O81+/kbWHpLhBoA==", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status: "W", ExternalID: "4987381194038857789",
+		ResponseBody: `{"status":0,"status_message":"ok","message_token":4987381194038857789}`, ResponseStatus: 200,
+		RequestBody: `{"auth_token":"Token","receiver":"xy5/5y6O81+/kbWHpLhBoA==","sender":{"name":"Courier"},"tracking_data":"10","type":"picture","media":"https://foo.bar/image.jpg"}`,
+		SendPrep:    setSendURL},
+	{Label: "Send Video Attachment",
+		Text: "", URN: "viber:xy5/5y6O81+/kbWHpLhBoA==", Attachments: []string{"video/mp4:https://foo.bar/video.mp4"},
+		Status: "W", ExternalID: "4987381194038857789",
+		ResponseBody: `{"status":0,"status_message":"ok","message_token":4987381194038857789}`, ResponseStatus: 200,
+		RequestBody: `{"auth_token":"Token","receiver":"xy5/5y6O81+/kbWHpLhBoA==","sender":{"name":"Courier"},"tracking_data":"10","type":"url","media":"https://foo.bar/video.mp4"}`,
+		SendPrep:    setSendURL},
+	{Label: "Error Status",
+		Text: "Error Message", URN: "viber:xy5/5y6O81+/kbWHpLhBoA==",
+		Status:       "E",
+		ResponseBody: `{"status":3,"status_message":"badData"}`, ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Error Sending",
+		Text: "Error Message", URN: "viber:xy5/5y6O81+/kbWHpLhBoA==",
+		Status:       "E",
+		ResponseBody: `Internal Error`, ResponseStatus: 500,
+		SendPrep: setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "VP", "2020", "",
+		map[string]interface{}{
+			courier.ConfigAuthToken: "Token",
+			configSenderName:        "Courier"})
+
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+}
@@ -1,3 +1,243 @@
 package vumi
 
-/* no logs */
+/*
+POST /c/vm/uuid/receive/
+{"transport_name":"ch_test","in_reply_to":null,"group":null,"from_addr":"+2349067554729","message_type":"user_message","helper_metadata":{},"to_addr":"2020","content":"Hello","message_version":"20110921","transport_type":"sms","timestamp":"2017-01-01 00:00:00.000000","transport_metadata":{},"session_event":null,"message_id":"external-id"}
+
+POST /c/vm/uuid/event/
+{"transport_name":"ch_test","event_type":"delivery_report","event_id":"event-id","sent_message_id":"sent-id","helper_metadata":{},"routing_metadata":{},"message_version":"20110921","timestamp":"2017-01-01 00:00:00.000000","transport_metadata":{},"user_message_id":"external-id","message_type":"event","delivery_status":"delivered"}
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	configAccountKey      = "account_key"
+	configAccessToken     = "access_token"
+	configConversationKey = "conversation_key"
+	configTransportName   = "transport_name"
+)
+
+// USSD session events, replies continue the session the incoming message started
+const (
+	sessionNew    = "new"
+	sessionResume = "resume"
+	sessionClose  = "close"
+)
+
+var sendURL = "https://go.vumi.org/api/v1/go/http_api_nostream/%s/messages.json"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+	courier.RegisterHandler(NewUSSDHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+	ussd bool
+}
+
+// NewHandler returns a new Vumi handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("VM"), "Vumi"), false}
+}
+
+// NewUSSDHandler returns a new Vumi USSD handler, which takes care of the session events of the conversation
+func NewUSSDHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("VMU"), "Vumi USSD"), true}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddReceiveMsgRoute(h, "POST", "receive", h.ReceiveMessage)
+	if err != nil {
+		return err
+	}
+
+	return s.AddUpdateStatusRoute(h, "POST", "event", h.StatusMessage)
+}
+
+type vmMessage struct {
+	MessageID    string `json:"message_id"     validate:"required"`
+	FromAddr     string `json:"from_addr"      validate:"required"`
+	ToAddr       string `json:"to_addr"`
+	Content      string `json:"content"`
+	SessionEvent string `json:"session_event"`
+	Timestamp    string `json:"timestamp"`
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	vmMsg := &vmMessage{}
+	err := handlers.DecodeAndValidateJSON(vmMsg, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// the end of a USSD session isn't a message from the contact
+	if vmMsg.SessionEvent == sessionClose {
+		return nil, courier.WriteIgnored(w, r, "Ignoring request, session closed")
+	}
+
+	// timestamps are in UTC
+	date := time.Now().UTC()
+	if vmMsg.Timestamp != "" {
+		date, err = time.Parse("2006-01-02 15:04:05.999999", vmMsg.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp: %s", vmMsg.Timestamp)
+		}
+	}
+
+	// create our URN
+	urn := courier.NewTelURNForChannel(vmMsg.FromAddr, channel)
+
+	// build our msg
+	msg := h.Backend().NewIncomingMsg(channel, urn, vmMsg.Content).WithExternalID(vmMsg.MessageID).WithReceivedOn(date)
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, courier.WriteReceiveSuccess(w, r, msg)
+}
+
+type vmEvent struct {
+	EventType      string `json:"event_type"       validate:"required"`
+	UserMessageID  string `json:"user_message_id"  validate:"required"`
+	DeliveryStatus string `json:"delivery_status"`
+}
+
+var vmEventMapping = map[string]courier.MsgStatusValue{
+	"ack":  courier.MsgSent,
+	"nack": courier.MsgFailed,
+}
+
+var vmDeliveryMapping = map[string]courier.MsgStatusValue{
+	"pending":   courier.MsgSent,
+	"delivered": courier.MsgDelivered,
+	"failed":    courier.MsgFailed,
+}
+
+// StatusMessage is our HTTP handler function for events about messages we sent
+func (h *handler) StatusMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.MsgStatus, error) {
+	vmEvent := &vmEvent{}
+	err := handlers.DecodeAndValidateJSON(vmEvent, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// delivery reports carry their own status, acks and nacks are the status
+	var msgStatus courier.MsgStatusValue
+	var found bool
+	if vmEvent.EventType == "delivery_report" {
+		msgStatus, found = vmDeliveryMapping[vmEvent.DeliveryStatus]
+		if !found {
+			return nil, fmt.Errorf("unknown delivery_status '%s', must be one of pending, delivered or failed", vmEvent.DeliveryStatus)
+		}
+	} else {
+		msgStatus, found = vmEventMapping[vmEvent.EventType]
+		if !found {
+			return nil, courier.WriteIgnored(w, r, fmt.Sprintf("Ignoring unknown event_type '%s'", vmEvent.EventType))
+		}
+	}
+
+	// events reference the id Vumi gave the message, which is our external id
+	status := h.Backend().NewMsgStatusForExternalID(channel, vmEvent.UserMessageID, msgStatus)
+	err = h.Backend().WriteMsgStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.MsgStatus{status}, courier.WriteStatusSuccess(w, r, status)
+}
+
+type vmOutgoing struct {
+	MessageID     string `json:"message_id"`
+	InReplyTo     string `json:"in_reply_to,omitempty"`
+	SessionEvent  string `json:"session_event,omitempty"`
+	ToAddr        string `json:"to_addr"`
+	FromAddr      string `json:"from_addr"`
+	Content       string `json:"content"`
+	TransportName string `json:"transport_name,omitempty"`
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	accountKey := msg.Channel().StringConfigForKey(configAccountKey, "")
+	if accountKey == "" {
+		return nil, fmt.Errorf("no account key set for %s channel", msg.Channel().ChannelType())
+	}
+
+	accessToken := msg.Channel().StringConfigForKey(configAccessToken, "")
+	if accessToken == "" {
+		return nil, fmt.Errorf("no access token set for %s channel", msg.Channel().ChannelType())
+	}
+
+	// channels may be configured with their own URL, otherwise we send to the conversation on Vumi Go
+	url := msg.Channel().StringConfigForKey(courier.ConfigSendURL, "")
+	if url == "" {
+		conversationKey := msg.Channel().StringConfigForKey(configConversationKey, "")
+		if conversationKey == "" {
+			return nil, fmt.Errorf("no send url or conversation key set for %s channel", msg.Channel().ChannelType())
+		}
+		url = fmt.Sprintf(sendURL, conversationKey)
+	}
+
+	payload := &vmOutgoing{
+		MessageID:     msg.ID().String(),
+		InReplyTo:     msg.ResponseToExternalID(),
+		ToAddr:        strings.TrimPrefix(msg.URN().Path(), "+"),
+		FromAddr:      msg.Channel().Address(),
+		Content:       courier.GetTextAndAttachments(msg),
+		TransportName: msg.Channel().StringConfigForKey(configTransportName, ""),
+	}
+
+	// USSD replies resume the session of the message they respond to, anything else starts a new one
+	if h.ussd {
+		payload.SessionEvent = sessionNew
+		if payload.InReplyTo != "" {
+			payload.SessionEvent = sessionResume
+		}
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(accountKey, accessToken)
+	rr, err := utils.MakeHTTPRequest(req)
+
+	// record our status and log
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+	status.AddLog(log)
+	if err != nil {
+		return status, nil
+	}
+
+	// this is the id events will reference
+	externalID, err := jsonparser.GetString(rr.Body, "message_id")
+	if err != nil || externalID == "" {
+		log.WithError("Message Send Error", errors.Errorf("unable to get message_id from body"))
+		return status, nil
+	}
+
+	status.SetStatus(courier.MsgWired)
+	status.SetExternalID(externalID)
+	return status, nil
+}
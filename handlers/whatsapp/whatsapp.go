@@ -0,0 +1,470 @@
+package whatsapp
+
+/*
+POST /c/wa/uuid/receive/
+{"contacts":[{"profile":{"name":"Kerry Fisher"},"wa_id":"16315551234"}],"messages":[{"from":"16315551234","id":"ABGGFlA5FpafAgo6EhmKtDTg","timestamp":"1518694235","type":"text","text":{"body":"Hello this is an answer"}}]}
+
+POST /c/wa/uuid/receive/
+{"statuses":[{"id":"ABGGFlA5FpafAgo6EhmKtDTg","recipient_id":"16315555555","status":"read","timestamp":"1518694700"}]}
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+// the config key for the URL of the WhatsApp Business API deployment the channel talks to
+const configBaseURL = "base_url"
+
+// WhatsApp gives us the expiry of the tokens we log in for in this format
+const expiresLayout = "2006-01-02 15:04:05-07:00"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+	tokens *tokenStore
+}
+
+// NewHandler returns a new WhatsApp handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("WA"), "WhatsApp"), newTokenStore()}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+
+	// WhatsApp posts both incoming messages and statuses to the same URL
+	return s.AddReceiveMsgAndStatusRoute(h, "POST", "receive", h.ReceiveEvent)
+}
+
+// {
+//   "contacts": [{
+//     "profile": {"name": "Kerry Fisher"},
+//     "wa_id": "16315551234"
+//   }],
+//   "messages": [{
+//     "from": "16315551234",
+//     "id": "ABGGFlA5FpafAgo6EhmKtDTg",
+//     "timestamp": "1518694235",
+//     "type": "image",
+//     "image": {
+//       "id": "b1c68f38-8734-4ad3-b4a1-ef0c10d683",
+//       "mime_type": "image/jpeg",
+//       "sha256": "29ed500fa64eb55fc19dc4124acb300e5dcc54a0f822a301ae99944db",
+//       "caption": "Check out my new phone!"
+//     }
+//   }],
+//   "statuses": [{
+//     "id": "ABGGFlA5FpafAgo6EhmKtDTg",
+//     "recipient_id": "16315555555",
+//     "status": "read",
+//     "timestamp": "1518694700"
+//   }]
+// }
+type waEnvelope struct {
+	Contacts []struct {
+		Profile struct {
+			Name string `json:"name"`
+		} `json:"profile"`
+		WaID string `json:"wa_id"`
+	} `json:"contacts"`
+	Messages []struct {
+		From      string `json:"from"       validate:"required"`
+		ID        string `json:"id"         validate:"required"`
+		Timestamp string `json:"timestamp"  validate:"required"`
+		Type      string `json:"type"       validate:"required"`
+		Text      struct {
+			Body string `json:"body"`
+		} `json:"text"`
+		Image    *waMedia `json:"image"`
+		Audio    *waMedia `json:"audio"`
+		Voice    *waMedia `json:"voice"`
+		Document *waMedia `json:"document"`
+		Location *struct {
+			Latitude  float64 `json:"latitude"`
+			Longitude float64 `json:"longitude"`
+			Name      string  `json:"name"`
+			Address   string  `json:"address"`
+		} `json:"location"`
+	} `json:"messages"  validate:"dive"`
+	Statuses []struct {
+		ID          string `json:"id"      validate:"required"`
+		RecipientID string `json:"recipient_id"`
+		Status      string `json:"status"  validate:"required"`
+		Timestamp   string `json:"timestamp"`
+	} `json:"statuses"  validate:"dive"`
+}
+
+type waMedia struct {
+	ID       string `json:"id"         validate:"required"`
+	MimeType string `json:"mime_type"`
+	Caption  string `json:"caption"`
+}
+
+var waStatusMapping = map[string]courier.MsgStatusValue{
+	"sent":      courier.MsgSent,
+	"delivered": courier.MsgDelivered,
+	"read":      courier.MsgDelivered,
+	"failed":    courier.MsgFailed,
+}
+
+// ReceiveEvent is our HTTP handler function for incoming messages and statuses, which may be batched. Messages are
+// all built before any are written so that an invalid one doesn't see the others written again when WhatsApp retries
+func (h *handler) ReceiveEvent(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, []courier.MsgStatus, error) {
+	payload := &waEnvelope{}
+	err := handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the names of our contacts are sent alongside their messages
+	names := make(map[string]string, len(payload.Contacts))
+	for _, contact := range payload.Contacts {
+		names[contact.WaID] = contact.Profile.Name
+	}
+
+	msgs := make([]courier.Msg, 0, len(payload.Messages))
+	for _, waMsg := range payload.Messages {
+		ts, err := strconv.ParseInt(waMsg.Timestamp, 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid timestamp: %s", waMsg.Timestamp)
+		}
+		date := time.Unix(ts, 0).UTC()
+
+		urn, err := courier.NewURNFromParts(courier.WhatsAppScheme, waMsg.From, "")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		text := ""
+		attachment := ""
+		var media *waMedia
+
+		switch waMsg.Type {
+		case "text":
+			text = waMsg.Text.Body
+		case "image":
+			media = waMsg.Image
+		case "audio":
+			media = waMsg.Audio
+		case "voice":
+			media = waMsg.Voice
+		case "document":
+			media = waMsg.Document
+		case "location":
+			if waMsg.Location != nil {
+				text = fmt.Sprintf("%f,%f", waMsg.Location.Latitude, waMsg.Location.Longitude)
+				attachment = fmt.Sprintf("geo:%f,%f", waMsg.Location.Latitude, waMsg.Location.Longitude)
+			}
+		default:
+			// video, contacts and the like aren't something we deal with yet
+			continue
+		}
+
+		// media is fetched from the media endpoint of our deployment, see BuildDownloadMediaRequest
+		if media != nil {
+			text = media.Caption
+			attachment = h.mediaURL(channel, media.ID)
+		}
+
+		// nothing to create a message from? skip it
+		if text == "" && attachment == "" {
+			continue
+		}
+
+		msg := h.Backend().NewIncomingMsg(channel, urn, text).WithExternalID(waMsg.ID).WithReceivedOn(date)
+		if names[waMsg.From] != "" {
+			msg.WithContactName(names[waMsg.From])
+		}
+		if attachment != "" {
+			msg.WithAttachment(attachment)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	for _, msg := range msgs {
+		err = h.Backend().WriteMsg(msg)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	statuses := make([]courier.MsgStatus, 0, len(payload.Statuses))
+	for _, waStatus := range payload.Statuses {
+		// statuses we don't know are skipped so they don't fail the rest of the batch
+		msgStatus, found := waStatusMapping[waStatus.Status]
+		if !found {
+			continue
+		}
+
+		// statuses reference the id WhatsApp gave the message, which is our external id
+		status := h.Backend().NewMsgStatusForExternalID(channel, waStatus.ID, msgStatus)
+		err = h.Backend().WriteMsgStatus(status)
+
+		// we may not know about this message, that's ok
+		if err == courier.ErrMsgNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		statuses = append(statuses, status)
+	}
+
+	if len(msgs) == 0 && len(statuses) == 0 {
+		return nil, nil, courier.WriteIgnored(w, r, "Ignoring request, no message or status events")
+	}
+
+	return msgs, statuses, courier.WriteEventsSuccess(w, r, msgs, statuses)
+}
+
+// BuildDownloadMediaRequest builds the request to fetch the passed in media URL, authenticated with our token
+func (h *handler) BuildDownloadMediaRequest(channel courier.Channel, mediaURL string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// only requests to our own deployment need our token
+	if strings.HasPrefix(mediaURL, h.baseURL(channel)) {
+		token, err := h.authToken(channel)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+
+	return req, nil
+}
+
+type waText struct {
+	Body string `json:"body"`
+}
+
+type waLink struct {
+	Link string `json:"link"`
+}
+
+type waOutgoing struct {
+	To       string  `json:"to"`
+	Type     string  `json:"type"`
+	Text     *waText `json:"text,omitempty"`
+	Image    *waLink `json:"image,omitempty"`
+	Audio    *waLink `json:"audio,omitempty"`
+	Video    *waLink `json:"video,omitempty"`
+	Document *waLink `json:"document,omitempty"`
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	baseURL := h.baseURL(msg.Channel())
+	if baseURL == "" {
+		return nil, fmt.Errorf("no base url set for WA channel")
+	}
+
+	token, err := h.authToken(msg.Channel())
+	if err != nil {
+		return nil, err
+	}
+
+	// the status that will be written for this message
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+
+	// build up all the parts we need to send, text first then each attachment
+	parts := make([]*waOutgoing, 0, len(msg.Attachments())+1)
+	if msg.Text() != "" {
+		parts = append(parts, &waOutgoing{To: msg.URN().Path(), Type: "text", Text: &waText{Body: msg.Text()}})
+	}
+	for _, attachment := range msg.Attachments() {
+		mediaType, mediaURL := courier.SplitAttachment(attachment)
+		link := &waLink{Link: mediaURL}
+
+		switch strings.Split(mediaType, "/")[0] {
+		case "image":
+			parts = append(parts, &waOutgoing{To: msg.URN().Path(), Type: "image", Image: link})
+		case "audio":
+			parts = append(parts, &waOutgoing{To: msg.URN().Path(), Type: "audio", Audio: link})
+		case "video":
+			parts = append(parts, &waOutgoing{To: msg.URN().Path(), Type: "video", Video: link})
+		default:
+			parts = append(parts, &waOutgoing{To: msg.URN().Path(), Type: "document", Document: link})
+		}
+	}
+
+	sendURL := fmt.Sprintf("%s/v1/messages", baseURL)
+	for _, part := range parts {
+		body, _ := json.Marshal(part)
+		rr, err := postJSON(sendURL, token, body)
+
+		// our token may have expired, log in for a new one and try again
+		if rr.StatusCode == http.StatusUnauthorized && h.canLogin(msg.Channel()) {
+			status.AddLog(courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err))
+
+			var loginRR *utils.RequestResponse
+			token, loginRR, err = h.refreshToken(msg.Channel())
+			status.AddLog(courier.NewChannelLogFromRR("Token Refreshed", msg.Channel(), msg.ID(), loginRR).WithError("Token Refresh Error", err))
+			if err != nil {
+				return status, nil
+			}
+
+			rr, err = postJSON(sendURL, token, body)
+		}
+
+		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+		status.AddLog(log)
+		if err != nil {
+			return status, nil
+		}
+
+		externalID, err := jsonparser.GetString(rr.Body, "messages", "[0]", "id")
+		if err != nil || externalID == "" {
+			log.WithError("Message Send Error", errors.Errorf("unable to get messages[0].id from body"))
+			return status, nil
+		}
+
+		// the first part is the one we track our status against
+		if status.ExternalID() == "" {
+			status.SetExternalID(externalID)
+		}
+	}
+
+	status.SetStatus(courier.MsgWired)
+	return status, nil
+}
+
+// postJSON posts the passed in body to the passed in URL using the passed in token
+func postJSON(url string, token string, body []byte) (*utils.RequestResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return utils.MakeHTTPRequest(req)
+}
+
+// baseURL returns the URL of the deployment for the passed in channel, without any trailing slash
+func (h *handler) baseURL(channel courier.Channel) string {
+	return strings.TrimSuffix(channel.StringConfigForKey(configBaseURL, ""), "/")
+}
+
+// mediaURL returns the URL the media with the passed in id can be downloaded from
+func (h *handler) mediaURL(channel courier.Channel, mediaID string) string {
+	return fmt.Sprintf("%s/v1/media/%s", h.baseURL(channel), mediaID)
+}
+
+// canLogin returns whether we have the credentials needed to log in for a new token
+func (h *handler) canLogin(channel courier.Channel) bool {
+	return channel.StringConfigForKey(courier.ConfigUsername, "") != "" && channel.StringConfigForKey(courier.ConfigPassword, "") != ""
+}
+
+// authToken returns the token we should use for the passed in channel. That's the last one we logged in for, or the
+// one the channel was configured with, logging in for a new one if we have neither
+func (h *handler) authToken(channel courier.Channel) (string, error) {
+	token := h.tokens.get(channel.UUID())
+	if token != "" {
+		return token, nil
+	}
+
+	token = channel.StringConfigForKey(courier.ConfigAuthToken, "")
+	if token != "" {
+		return token, nil
+	}
+
+	if !h.canLogin(channel) {
+		return "", fmt.Errorf("no auth token or username and password set for WA channel")
+	}
+
+	token, _, err := h.refreshToken(channel)
+	return token, err
+}
+
+// refreshToken logs in to the deployment for the passed in channel, storing and returning the new token
+func (h *handler) refreshToken(channel courier.Channel) (string, *utils.RequestResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v1/users/login", h.baseURL(channel)), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(channel.StringConfigForKey(courier.ConfigUsername, ""), channel.StringConfigForKey(courier.ConfigPassword, ""))
+	rr, err := utils.MakeHTTPRequest(req)
+	if err != nil {
+		return "", rr, err
+	}
+
+	token, err := jsonparser.GetString(rr.Body, "users", "[0]", "token")
+	if err != nil || token == "" {
+		return "", rr, errors.Errorf("unable to get users[0].token from body")
+	}
+
+	// we hold on to tokens until they expire, if we don't know when that is until they are rejected
+	expiresOn := time.Time{}
+	expires, _ := jsonparser.GetString(rr.Body, "users", "[0]", "expires_after")
+	if expires != "" {
+		expiresOn, err = time.Parse(expiresLayout, expires)
+		if err != nil {
+			return "", rr, errors.Errorf("invalid expires_after: %s", expires)
+		}
+	}
+
+	h.tokens.set(channel.UUID(), token, expiresOn)
+	return token, rr, nil
+}
+
+// tokenStore holds the tokens we have logged in for by channel. Note that tokens are held in memory, so each courier
+// instance logs in for its own tokens.
+type tokenStore struct {
+	mutex  sync.Mutex
+	tokens map[courier.ChannelUUID]*token
+}
+
+type token struct {
+	value     string
+	expiresOn time.Time
+}
+
+func newTokenStore() *tokenStore {
+	return &tokenStore{tokens: make(map[courier.ChannelUUID]*token)}
+}
+
+// get returns the token for the passed in channel, or the empty string if we don't have one which hasn't expired
+func (s *tokenStore) get(channelUUID courier.ChannelUUID) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	t, found := s.tokens[channelUUID]
+	if !found {
+		return ""
+	}
+	if !t.expiresOn.IsZero() && time.Now().After(t.expiresOn) {
+		delete(s.tokens, channelUUID)
+		return ""
+	}
+	return t.value
+}
+
+// set stores the passed in token for the passed in channel
+func (s *tokenStore) set(channelUUID courier.ChannelUUID, value string, expiresOn time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.tokens[channelUUID] = &token{value, expiresOn}
+}
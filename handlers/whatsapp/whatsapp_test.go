@@ -0,0 +1,317 @@
+package whatsapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/config"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/require"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WA", "250788383383", "RW",
+		map[string]interface{}{configBaseURL: "https://foo.bar/"}),
+}
+
+var receiveURL = "/c/wa/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive/"
+
+var helloMsg = `{
+	"contacts": [{"profile": {"name": "Jerry Cooney"}, "wa_id": "250788123123"}],
+	"messages": [{
+		"from": "250788123123",
+		"id": "41",
+		"timestamp": "1454119029",
+		"type": "text",
+		"text": {"body": "hello world"}
+	}]
+}`
+
+var imageMsg = `{
+	"messages": [{
+		"from": "250788123123",
+		"id": "41",
+		"timestamp": "1454119029",
+		"type": "image",
+		"image": {"id": "b1c68f38-8734-4ad3-b4a1-ef0c10d683", "mime_type": "image/jpeg", "sha256": "29ed500fa64eb55fc19dc4124acb300e5dcc54a0f822a301ae99944db", "caption": "Check out my new phone!"}
+	}]
+}`
+
+var voiceMsg = `{
+	"messages": [{
+		"from": "250788123123",
+		"id": "41",
+		"timestamp": "1454119029",
+		"type": "voice",
+		"voice": {"id": "463e1f24-5f5d-4e3b-9d3b-a11e2b5fb2e8", "mime_type": "audio/ogg; codecs=opus", "sha256": "fa9e1807d936b7cebe63654ea3a7912b1fa9479220258d823590521ef53b0710"}
+	}]
+}`
+
+var documentMsg = `{
+	"messages": [{
+		"from": "250788123123",
+		"id": "41",
+		"timestamp": "1454119029",
+		"type": "document",
+		"document": {"id": "fc233119-733f-49c3-bcbd-b2f68f798e33", "mime_type": "application/pdf", "caption": "80skaraokesonglistartist"}
+	}]
+}`
+
+var locationMsg = `{
+	"messages": [{
+		"from": "250788123123",
+		"id": "41",
+		"timestamp": "1454119029",
+		"type": "location",
+		"location": {"address": "Main Street Beach, Santa Cruz, CA", "latitude": 0.000000, "longitude": 1.000000, "name": "Main Street Beach"}
+	}]
+}`
+
+var unknownTypeMsg = `{
+	"messages": [{
+		"from": "250788123123",
+		"id": "41",
+		"timestamp": "1454119029",
+		"type": "contacts"
+	}]
+}`
+
+var invalidTimestamp = `{
+	"messages": [{
+		"from": "250788123123",
+		"id": "41",
+		"timestamp": "2016-01-30",
+		"type": "text",
+		"text": {"body": "hello world"}
+	}]
+}`
+
+var missingFrom = `{
+	"messages": [{
+		"id": "41",
+		"timestamp": "1454119029",
+		"type": "text",
+		"text": {"body": "hello world"}
+	}]
+}`
+
+var statusRead = `{"statuses": [{"id": "9712A34B4A8B6AD50F", "recipient_id": "16315555555", "status": "read", "timestamp": "1518694700"}]}`
+var statusFailed = `{"statuses": [{"id": "9712A34B4A8B6AD50F", "recipient_id": "16315555555", "status": "failed", "timestamp": "1518694700"}]}`
+var statusUnknown = `{"statuses": [{"id": "9712A34B4A8B6AD50F", "recipient_id": "16315555555", "status": "in_orbit", "timestamp": "1518694700"}]}`
+
+var msgWithUnknownStatus = `{
+	"messages": [{
+		"from": "250788123123",
+		"id": "41",
+		"timestamp": "1454119029",
+		"type": "text",
+		"text": {"body": "hello world"}
+	}],
+	"statuses": [{"id": "9712A34B4A8B6AD50F", "recipient_id": "16315555555", "status": "in_orbit", "timestamp": "1518694700"}]
+}`
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Valid Message", URL: receiveURL, Data: helloMsg, Status: 200, Response: "Events Handled",
+		Name: Sp("Jerry Cooney"), Text: Sp("hello world"), URN: Sp("whatsapp:250788123123"), External: Sp("41"), Date: Tp(time.Unix(1454119029, 0).UTC())},
+	{Label: "Receive Image Message", URL: receiveURL, Data: imageMsg, Status: 200, Response: "Events Handled",
+		Text: Sp("Check out my new phone!"), URN: Sp("whatsapp:250788123123"), External: Sp("41"),
+		Attachment: Sp("https://foo.bar/v1/media/b1c68f38-8734-4ad3-b4a1-ef0c10d683"), Date: Tp(time.Unix(1454119029, 0).UTC())},
+	{Label: "Receive Voice Message", URL: receiveURL, Data: voiceMsg, Status: 200, Response: "Events Handled",
+		Text: Sp(""), URN: Sp("whatsapp:250788123123"), External: Sp("41"),
+		Attachment: Sp("https://foo.bar/v1/media/463e1f24-5f5d-4e3b-9d3b-a11e2b5fb2e8")},
+	{Label: "Receive Document Message", URL: receiveURL, Data: documentMsg, Status: 200, Response: "Events Handled",
+		Text: Sp("80skaraokesonglistartist"), URN: Sp("whatsapp:250788123123"), External: Sp("41"),
+		Attachment: Sp("https://foo.bar/v1/media/fc233119-733f-49c3-bcbd-b2f68f798e33")},
+	{Label: "Receive Location Message", URL: receiveURL, Data: locationMsg, Status: 200, Response: "Events Handled",
+		Text: Sp("0.000000,1.000000"), URN: Sp("whatsapp:250788123123"), External: Sp("41"), Attachment: Sp("geo:0.000000,1.000000")},
+	{Label: "Receive Unknown Type", URL: receiveURL, Data: unknownTypeMsg, Status: 200, Response: "Ignoring request, no message or status events"},
+	{Label: "Receive Invalid Timestamp", URL: receiveURL, Data: invalidTimestamp, Status: 400, Response: "invalid timestamp: 2016-01-30"},
+	{Label: "Receive Missing From", URL: receiveURL, Data: missingFrom, Status: 400, Response: "Field validation for 'From' failed"},
+	{Label: "Receive Invalid JSON", URL: receiveURL, Data: "not json", Status: 400, Response: "unable to parse request JSON"},
+
+	{Label: "Status Read", URL: receiveURL, Data: statusRead, Status: 200, Response: `"status":"D"`},
+	{Label: "Status Failed", URL: receiveURL, Data: statusFailed, Status: 200, Response: `"status":"F"`},
+	{Label: "Status Unknown", URL: receiveURL, Data: statusUnknown, Status: 200, Response: "Ignoring request, no message or status events"},
+	{Label: "Message With Unknown Status", URL: receiveURL, Data: msgWithUnknownStatus, Status: 200, Response: "Events Handled",
+		Text: Sp("hello world"), URN: Sp("whatsapp:250788123123"), External: Sp("41")},
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, NewHandler(), testCases)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	RunChannelBenchmarks(b, testChannels, NewHandler(), testCases)
+}
+
+// setBaseURL takes care of setting the base_url to our test server host
+func setBaseURL(server *httptest.Server, channel courier.Channel, msg courier.Msg) {
+	channel.(*courier.MockChannel).SetConfig(configBaseURL, server.URL)
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "whatsapp:250788123123",
+		Status: "W", ExternalID: "157b5e14568e8",
+		ResponseBody: `{"messages":[{"id":"157b5e14568e8"}]}`, ResponseStatus: 201,
+		Headers:     map[string]string{"Content-Type": "application/json", "Authorization": "Bearer the-token"},
+		RequestBody: `{"to":"250788123123","type":"text","text":{"body":"Simple Message"}}`,
+		SendPrep:    setBaseURL},
+	{Label: "Unicode Send",
+		Text: "☺", URN: "whatsapp:250788123123",
+		Status: "W", ExternalID: "157b5e14568e8",
+		ResponseBody: `{"messages":[{"id":"157b5e14568e8"}]}`, ResponseStatus: 201,
+		RequestBody: `{"to":"250788123123","type":"text","text":{"body":"☺"}}`,
+		SendPrep:    setBaseURL},
+	{Label: "Image Send",
+		URN: "whatsapp:250788123123", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status: "W", ExternalID: "157b5e14568e8",
+		ResponseBody: `{"messages":[{"id":"157b5e14568e8"}]}`, ResponseStatus: 201,
+		RequestBody: `{"to":"250788123123","type":"image","image":{"link":"https://foo.bar/image.jpg"}}`,
+		SendPrep:    setBaseURL},
+	{Label: "Audio Send",
+		URN: "whatsapp:250788123123", Attachments: []string{"audio/mp3:https://foo.bar/audio.mp3"},
+		Status: "W", ExternalID: "157b5e14568e8",
+		ResponseBody: `{"messages":[{"id":"157b5e14568e8"}]}`, ResponseStatus: 201,
+		RequestBody: `{"to":"250788123123","type":"audio","audio":{"link":"https://foo.bar/audio.mp3"}}`,
+		SendPrep:    setBaseURL},
+	{Label: "Document Send",
+		Text: "Your receipt", URN: "whatsapp:250788123123", Attachments: []string{"application/pdf:https://foo.bar/receipt.pdf"},
+		Status: "W", ExternalID: "157b5e14568e8",
+		ResponseBody: `{"messages":[{"id":"157b5e14568e8"}]}`, ResponseStatus: 201,
+		RequestBody: `{"to":"250788123123","type":"document","document":{"link":"https://foo.bar/receipt.pdf"}}`,
+		SendPrep:    setBaseURL},
+	{Label: "No Message ID",
+		Text: "Error", URN: "whatsapp:250788123123",
+		Status:       "E",
+		ResponseBody: `{"messages":[]}`, ResponseStatus: 201,
+		SendPrep: setBaseURL},
+	{Label: "Error Sending",
+		Text: "Error", URN: "whatsapp:250788123123",
+		Status:       "E",
+		ResponseBody: `{"errors":[{"code":1008,"title":"Required parameter is missing"}]}`, ResponseStatus: 400,
+		SendPrep: setBaseURL},
+}
+
+func TestSending(t *testing.T) {
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WA", "250788383383", "RW",
+		map[string]interface{}{
+			courier.ConfigAuthToken: "the-token",
+		})
+
+	RunChannelSendTestCases(t, defaultChannel, NewHandler(), defaultSendTestCases)
+}
+
+// newDeployment returns a stand-in for a WhatsApp deployment which only accepts the token it last handed out
+func newDeployment(t *testing.T, expiresAfter string) (*httptest.Server, *[]string) {
+	token := ""
+	logins := 0
+	requests := make([]string, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+
+		if r.URL.Path == "/v1/users/login" {
+			username, password, _ := r.BasicAuth()
+			if username != "Username" || password != "Password" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"errors":[{"code":1005,"title":"Access denied"}]}`))
+				return
+			}
+
+			logins++
+			token = "token" + string('0'+rune(logins))
+			w.Write([]byte(`{"users":[{"token":"` + token + `","expires_after":"` + expiresAfter + `"}]}`))
+			return
+		}
+
+		if token == "" || r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errors":[{"code":1005,"title":"Access denied"}]}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/messages":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"messages":[{"id":"157b5e14568e8"}]}`))
+		default:
+			w.Write([]byte(`media`))
+		}
+	}))
+
+	return server, &requests
+}
+
+func TestTokenRefresh(t *testing.T) {
+	server, requests := newDeployment(t, "2100-01-01 00:00:00+00:00")
+	defer server.Close()
+
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WA", "250788383383", "RW",
+		map[string]interface{}{
+			configBaseURL:           server.URL,
+			courier.ConfigAuthToken: "expired-token",
+			courier.ConfigUsername:  "Username",
+			courier.ConfigPassword:  "Password",
+		})
+
+	mb := courier.NewMockBackend()
+	mb.AddChannel(channel)
+	h := NewHandler().(*handler)
+	h.Initialize(courier.NewServer(config.NewTest(), mb))
+
+	// our configured token has expired, so we log in for a new one and try again
+	msg := mb.NewOutgoingMsg(channel, courier.NewMsgID(10), courier.URN("whatsapp:250788123123"), "Hello", courier.DefaultPriority)
+	status, err := h.SendMsg(msg)
+	require.NoError(t, err)
+	require.Equal(t, courier.MsgWired, status.Status())
+	require.Equal(t, "157b5e14568e8", status.ExternalID())
+	require.Equal(t, 3, len(status.Logs()))
+	require.Equal(t, []string{"POST /v1/messages", "POST /v1/users/login", "POST /v1/messages"}, *requests)
+
+	// the next send uses the token we logged in for straight away
+	status, err = h.SendMsg(msg)
+	require.NoError(t, err)
+	require.Equal(t, courier.MsgWired, status.Status())
+	require.Equal(t, 1, len(status.Logs()))
+
+	// and so does downloading media
+	req, err := h.BuildDownloadMediaRequest(channel, server.URL+"/v1/media/b1c68f38")
+	require.NoError(t, err)
+	require.Equal(t, "Bearer token1", req.Header.Get("Authorization"))
+
+	// but media hosted elsewhere doesn't get our token
+	req, err = h.BuildDownloadMediaRequest(channel, "https://foo.bar/image.jpg")
+	require.NoError(t, err)
+	require.Equal(t, "", req.Header.Get("Authorization"))
+}
+
+func TestTokenExpiry(t *testing.T) {
+	server, requests := newDeployment(t, "2000-01-01 00:00:00+00:00")
+	defer server.Close()
+
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WA", "250788383383", "RW",
+		map[string]interface{}{
+			configBaseURL:          server.URL,
+			courier.ConfigUsername: "Username",
+			courier.ConfigPassword: "Password",
+		})
+
+	h := NewHandler().(*handler)
+
+	// without a configured token we log in straight away, but that token has already expired so we log in again
+	_, err := h.BuildDownloadMediaRequest(channel, server.URL+"/v1/media/b1c68f38")
+	require.NoError(t, err)
+	req, err := h.BuildDownloadMediaRequest(channel, server.URL+"/v1/media/b1c68f38")
+	require.NoError(t, err)
+	require.Equal(t, "Bearer token2", req.Header.Get("Authorization"))
+	require.Equal(t, []string{"POST /v1/users/login", "POST /v1/users/login"}, *requests)
+
+	// bad credentials mean no token
+	channel.(*courier.MockChannel).SetConfig(courier.ConfigPassword, "Wrong")
+	_, err = h.BuildDownloadMediaRequest(channel, server.URL+"/v1/media/b1c68f38")
+	require.Error(t, err)
+}
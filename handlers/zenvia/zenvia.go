@@ -1,3 +1,242 @@
 package zenvia
 
-/* no logs */
+/*
+POST /c/zv/uuid/receive/
+{"callbackMoRequest":{"id":"20690090","mobile":"555191951711","shortCode":"40001","account":"zenvia.envio","body":"Content of reply SMS","received":"2014-08-26T12:27:08.488-03:00","correlatedMessageSmsId":"hs765939061"}}
+
+POST /c/zv/uuid/status/
+{"callbackMtRequest":{"status":"03","statusMessage":"Delivered","statusDetail":"120","statusDetailMessage":"Message received by mobile","id":"hs765939216","received":"2014-08-26T12:55:48.593-03:00","mobileOperatorName":"Claro"}}
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/gsm7"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+// the config key for the optional aggregate our messages are reported under on Zenvia
+const configAggregateID = "aggregate_id"
+
+var sendURL = "https://api-rest.zenvia360.com.br/services/send-sms"
+
+func init() {
+	courier.RegisterHandler(NewHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+}
+
+// NewHandler returns a new Zenvia handler
+func NewHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("ZV"), "Zenvia")}
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	err := s.AddReceiveMsgRoute(h, "POST", "receive", h.ReceiveMessage)
+	if err != nil {
+		return err
+	}
+
+	return s.AddUpdateStatusRoute(h, "POST", "status", h.StatusMessage)
+}
+
+// {
+//   "callbackMoRequest": {
+//     "id": "20690090",
+//     "mobile": "555191951711",
+//     "shortCode": "40001",
+//     "account": "zenvia.envio",
+//     "body": "Content of reply SMS",
+//     "received": "2014-08-26T12:27:08.488-03:00",
+//     "correlatedMessageSmsId": "hs765939061"
+//   }
+// }
+type zvMOEnvelope struct {
+	CallbackMORequest struct {
+		ID       string `json:"id"        validate:"required"`
+		Mobile   string `json:"mobile"    validate:"required"`
+		Body     string `json:"body"`
+		Received string `json:"received"`
+	} `json:"callbackMoRequest"`
+}
+
+// ReceiveMessage is our HTTP handler function for incoming messages
+func (h *handler) ReceiveMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Msg, error) {
+	payload := &zvMOEnvelope{}
+	err := handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, err
+	}
+	zvMsg := payload.CallbackMORequest
+
+	date := time.Now().UTC()
+	if zvMsg.Received != "" {
+		date, err = time.Parse(time.RFC3339Nano, zvMsg.Received)
+		if err != nil {
+			return nil, fmt.Errorf("invalid received date: %s", zvMsg.Received)
+		}
+	}
+
+	// create our URN
+	urn := courier.NewTelURNForChannel(zvMsg.Mobile, channel)
+
+	// build our msg
+	msg := h.Backend().NewIncomingMsg(channel, urn, zvMsg.Body).WithExternalID(zvMsg.ID).WithReceivedOn(date.UTC())
+
+	// and finally queue our message
+	err = h.Backend().WriteMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.Msg{msg}, courier.WriteReceiveSuccess(w, r, msg)
+}
+
+// {
+//   "callbackMtRequest": {
+//     "status": "03",
+//     "statusMessage": "Delivered",
+//     "statusDetail": "120",
+//     "statusDetailMessage": "Message received by mobile",
+//     "id": "10",
+//     "received": "2014-08-26T12:55:48.593-03:00",
+//     "mobileOperatorName": "Claro"
+//   }
+// }
+type zvStatusEnvelope struct {
+	CallbackMTRequest struct {
+		ID                  string `json:"id"      validate:"required"`
+		Status              string `json:"status"  validate:"required"`
+		StatusMessage       string `json:"statusMessage"`
+		StatusDetail        string `json:"statusDetail"`
+		StatusDetailMessage string `json:"statusDetailMessage"`
+	} `json:"callbackMtRequest"`
+}
+
+// Zenvia status codes, anything that was blocked won't be delivered no matter how many times we try
+var zvStatusMapping = map[int]courier.MsgStatusValue{
+	0:  courier.MsgWired,     // Ok
+	1:  courier.MsgWired,     // Scheduled
+	2:  courier.MsgSent,      // Sent
+	3:  courier.MsgDelivered, // Delivered
+	4:  courier.MsgFailed,    // Not Received
+	5:  courier.MsgFailed,    // Blocked - No Coverage
+	6:  courier.MsgFailed,    // Blocked - Black listed
+	7:  courier.MsgFailed,    // Blocked - Invalid Number
+	8:  courier.MsgFailed,    // Blocked - Content not allowed
+	9:  courier.MsgFailed,    // Blocked - Message Expired
+	10: courier.MsgErrored,   // Error
+}
+
+// StatusMessage is our HTTP handler function for status updates
+func (h *handler) StatusMessage(channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.MsgStatus, error) {
+	payload := &zvStatusEnvelope{}
+	err := handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, err
+	}
+	zvStatus := payload.CallbackMTRequest
+
+	code, err := strconv.Atoi(zvStatus.Status)
+	if err != nil {
+		return nil, fmt.Errorf("invalid status: %s", zvStatus.Status)
+	}
+
+	msgStatus, found := zvStatusMapping[code]
+	if !found {
+		return nil, fmt.Errorf("unknown status '%s' (%s): %s", zvStatus.Status, zvStatus.StatusDetail, zvStatus.StatusDetailMessage)
+	}
+
+	// we send our msg id as the id of the message so that's what we get back
+	msgID, err := strconv.ParseInt(zvStatus.ID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id: %s", zvStatus.ID)
+	}
+
+	status := h.Backend().NewMsgStatusForID(channel, courier.NewMsgID(msgID), msgStatus)
+	err = h.Backend().WriteMsgStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return []courier.MsgStatus{status}, courier.WriteStatusSuccess(w, r, status)
+}
+
+type zvSendSmsRequest struct {
+	From           string `json:"from"`
+	To             string `json:"to"`
+	Schedule       string `json:"schedule"`
+	Msg            string `json:"msg"`
+	CallbackOption string `json:"callbackOption"`
+	ID             string `json:"id"`
+	AggregateID    string `json:"aggregateId,omitempty"`
+}
+
+type zvOutgoing struct {
+	SendSmsRequest zvSendSmsRequest `json:"sendSmsRequest"`
+}
+
+// SendMsg sends the passed in message, returning any error
+func (h *handler) SendMsg(msg courier.Msg) (courier.MsgStatus, error) {
+	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
+	if username == "" {
+		return nil, fmt.Errorf("no username set for ZV channel")
+	}
+
+	password := msg.Channel().StringConfigForKey(courier.ConfigPassword, "")
+	if password == "" {
+		return nil, fmt.Errorf("no password set for ZV channel")
+	}
+
+	// Zenvia only delivers GSM7, so we swap accented Portuguese characters for ones which can be encoded
+	payload := &zvOutgoing{
+		SendSmsRequest: zvSendSmsRequest{
+			From:           "Sender",
+			To:             strings.TrimPrefix(msg.URN().Path(), "+"),
+			Msg:            gsm7.ReplaceNonGSM7Chars(courier.GetTextAndAttachments(msg)),
+			CallbackOption: "FINAL",
+			ID:             msg.ID().String(),
+			AggregateID:    msg.Channel().StringConfigForKey(configAggregateID, ""),
+		},
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(username, password)
+	rr, err := utils.MakeHTTPRequest(req)
+
+	// record our status and log
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+	status.AddLog(log)
+	if err != nil {
+		return status, nil
+	}
+
+	// Zenvia responds 200 even when it rejects a message, check the status code it gave us
+	statusCode, _ := jsonparser.GetString(rr.Body, "sendSmsResponse", "statusCode")
+	if statusCode != "00" && statusCode != "01" {
+		detail, _ := jsonparser.GetString(rr.Body, "sendSmsResponse", "detailDescription")
+		log.WithError("Message Send Error", errors.Errorf("received non-success status code '%s': %s", statusCode, detail))
+		return status, nil
+	}
+
+	status.SetStatus(courier.MsgWired)
+	return status, nil
+}
@@ -82,6 +82,7 @@ type Msg interface {
 	Attachments() []string
 	ExternalID() string
 	URN() URN
+	URNAuth() string
 	ContactName() string
 
 	ReceivedOn() *time.Time
@@ -89,7 +90,11 @@ type Msg interface {
 
 	Priority() MsgPriority
 
+	ResponseToID() MsgID
+	ResponseToExternalID() string
+
 	WithContactName(name string) Msg
+	WithURNAuth(auth string) Msg
 	WithReceivedOn(date time.Time) Msg
 	WithExternalID(id string) Msg
 	WithID(id MsgID) Msg
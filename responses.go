@@ -84,6 +84,60 @@ func WriteStatusSuccess(w http.ResponseWriter, r *http.Request, status MsgStatus
 		})
 }
 
+// WriteCallEventSuccess writes a JSON response for the passed in call event indicating we handled it
+func WriteCallEventSuccess(w http.ResponseWriter, r *http.Request, event CallEvent) error {
+	logrus.WithFields(logrus.Fields{
+		"url":              r.Context().Value(contextRequestURL),
+		"elapsed_ms":       getElapsedMS(r),
+		"channel_uuid":     event.ChannelUUID(),
+		"call_external_id": event.ExternalID(),
+	}).Info("call event received")
+
+	return writeData(w, http.StatusOK, "Call Event Accepted",
+		&callEventData{
+			event.ChannelUUID(),
+			event.Status(),
+			event.ExternalID(),
+		})
+}
+
+// WriteEventsSuccess writes a JSON response for the passed in msgs and statuses indicating we handled them, this
+// is used by channels which batch more than one event into a single request
+func WriteEventsSuccess(w http.ResponseWriter, r *http.Request, msgs []Msg, statuses []MsgStatus) error {
+	logrus.WithFields(logrus.Fields{
+		"url":          r.Context().Value(contextRequestURL),
+		"elapsed_ms":   getElapsedMS(r),
+		"msg_count":    len(msgs),
+		"status_count": len(statuses),
+	}).Info("events handled")
+
+	data := &eventsData{
+		Msgs:     make([]*receiveData, 0, len(msgs)),
+		Statuses: make([]*statusData, 0, len(statuses)),
+	}
+	for _, msg := range msgs {
+		data.Msgs = append(data.Msgs, &receiveData{
+			msg.Channel().UUID(),
+			msg.UUID(),
+			msg.Text(),
+			msg.URN(),
+			msg.Attachments(),
+			msg.ExternalID(),
+			msg.ReceivedOn(),
+		})
+	}
+	for _, status := range statuses {
+		data.Statuses = append(data.Statuses, &statusData{
+			status.ChannelUUID(),
+			status.Status(),
+			status.ID(),
+			status.ExternalID(),
+		})
+	}
+
+	return writeData(w, http.StatusOK, "Events Handled", data)
+}
+
 func getElapsedMS(r *http.Request) float64 {
 	start := r.Context().Value(contextRequestStart)
 	if start == nil {
@@ -122,6 +176,17 @@ type statusData struct {
 	ExternalID  string         `json:"external_id,omitempty"`
 }
 
+type callEventData struct {
+	ChannelUUID ChannelUUID     `json:"channel_uuid"`
+	Status      CallStatusValue `json:"status"`
+	ExternalID  string          `json:"external_id"`
+}
+
+type eventsData struct {
+	Msgs     []*receiveData `json:"msgs"`
+	Statuses []*statusData  `json:"statuses"`
+}
+
 func writeJSONResponse(w http.ResponseWriter, statusCode int, response interface{}) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
@@ -30,8 +30,11 @@ type Server interface {
 	AddChannelRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelActionHandlerFunc) error
 	AddReceiveMsgRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelReceiveMsgFunc) error
 	AddUpdateStatusRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelUpdateStatusFunc) error
+	AddReceiveMsgAndStatusRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelReceiveMsgAndStatusFunc) error
+	AddCallEventRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelCallEventFunc) error
 
 	SendMsg(Msg) (MsgStatus, error)
+	StartCall(Channel, URN) (CallEvent, error)
 
 	Backend() Backend
 
@@ -195,6 +198,23 @@ func (s *server) SendMsg(msg Msg) (MsgStatus, error) {
 	return handler.SendMsg(msg)
 }
 
+// StartCall starts an outgoing call to the passed in URN on the passed in channel
+func (s *server) StartCall(channel Channel, urn URN) (CallEvent, error) {
+	// find the handler for this channel type
+	handler, found := activeHandlers[channel.ChannelType()]
+	if !found {
+		return nil, fmt.Errorf("unable to find handler for channel type: %s", channel.ChannelType())
+	}
+
+	// only voice channels can start calls
+	callHandler, isCallHandler := handler.(ChannelCallHandler)
+	if !isCallHandler {
+		return nil, fmt.Errorf("channel type %s doesn't support calls", channel.ChannelType())
+	}
+
+	return callHandler.StartCall(channel, urn)
+}
+
 func (s *server) WaitGroup() *sync.WaitGroup { return s.waitGroup }
 func (s *server) StopChan() chan bool        { return s.stopChan }
 func (s *server) Config() *config.Courier    { return s.config }
@@ -316,6 +336,106 @@ func (s *server) channelUpdateStatusWrapper(handler ChannelHandler, handlerFunc
 	})
 }
 
+func (s *server) channelReceiveMsgAndStatusWrapper(handler ChannelHandler, handlerFunc ChannelReceiveMsgAndStatusFunc) http.HandlerFunc {
+	return s.channelFunctionWrapper(handler, func(channel Channel, w http.ResponseWriter, r *http.Request) error {
+		start := time.Now()
+
+		// read the bytes from our body so we can create a channel log for this request
+		response := &bytes.Buffer{}
+		request, err := httputil.DumpRequest(r, true)
+		if err != nil {
+			return err
+		}
+		url := fmt.Sprintf("%s%s", s.config.BaseURL, r.URL.RequestURI())
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(response)
+
+		logs := make([]*ChannelLog, 0, 1)
+		msgs, statuses, err := handlerFunc(channel, ww, r)
+		duration := time.Now().Sub(start)
+		secondDuration := float64(duration) / float64(time.Second)
+
+		// we received an error, write it out and report it
+		if err != nil {
+			logrus.WithError(err).WithField("url", url).WithField("request", request).Error("error receiving message or status")
+			WriteError(ww, r, err)
+		}
+
+		// if we didn't receive anything we still want a channel log, create one
+		if len(msgs) == 0 && len(statuses) == 0 {
+			logs = append(logs, NewChannelLog("Receive Error", channel, NilMsgID, r.Method, url, ww.Status(), string(request), prependHeaders(response.String(), ww.Status(), w), duration, err))
+			librato.Default.AddGauge(fmt.Sprintf("courier.msg_receive_error_%s", channel.ChannelType()), secondDuration)
+		}
+		for _, msg := range msgs {
+			logs = append(logs, NewChannelLog("Message Received", channel, msg.ID(), r.Method, url, ww.Status(), string(request), prependHeaders(response.String(), ww.Status(), w), duration, err))
+			librato.Default.AddGauge(fmt.Sprintf("courier.msg_receive_%s", channel.ChannelType()), secondDuration)
+		}
+		for _, status := range statuses {
+			logs = append(logs, NewChannelLog("Status Updated", channel, status.ID(), r.Method, url, ww.Status(), string(request), response.String(), duration, err))
+			librato.Default.AddGauge(fmt.Sprintf("courier.msg_status_%s", channel.ChannelType()), secondDuration)
+		}
+
+		// and write these out
+		err = s.backend.WriteChannelLogs(logs)
+
+		// log any error writing our channel log but don't break the request
+		if err != nil {
+			logrus.WithError(err).Error("error writing channel log")
+		}
+
+		return nil
+	})
+}
+
+func (s *server) channelCallEventWrapper(handler ChannelHandler, handlerFunc ChannelCallEventFunc) http.HandlerFunc {
+	return s.channelFunctionWrapper(handler, func(channel Channel, w http.ResponseWriter, r *http.Request) error {
+		start := time.Now()
+
+		// read the bytes from our body so we can create a channel log for this request
+		response := &bytes.Buffer{}
+		request, err := httputil.DumpRequest(r, true)
+		if err != nil {
+			return err
+		}
+		url := fmt.Sprintf("%s%s", s.config.BaseURL, r.URL.RequestURI())
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(response)
+
+		logs := make([]*ChannelLog, 0, 1)
+		events, err := handlerFunc(channel, ww, r)
+		duration := time.Now().Sub(start)
+		secondDuration := float64(duration) / float64(time.Second)
+
+		// we received an error, write it out and report it
+		if err != nil {
+			logrus.WithError(err).WithField("url", url).WithField("request", request).Error("error receiving call event")
+			WriteError(ww, r, err)
+		}
+
+		// if we don't have any events we still want a channel log, create one
+		if len(events) == 0 {
+			logs = append(logs, NewChannelLog("Call Event Error", channel, NilMsgID, r.Method, url, ww.Status(), string(request), response.String(), duration, err))
+			librato.Default.AddGauge(fmt.Sprintf("courier.call_event_error_%s", channel.ChannelType()), secondDuration)
+		}
+		for range events {
+			logs = append(logs, NewChannelLog("Call Event Received", channel, NilMsgID, r.Method, url, ww.Status(), string(request), response.String(), duration, err))
+			librato.Default.AddGauge(fmt.Sprintf("courier.call_event_%s", channel.ChannelType()), secondDuration)
+		}
+
+		// and write these out
+		err = s.backend.WriteChannelLogs(logs)
+
+		// log any error writing our channel log but don't break the request
+		if err != nil {
+			logrus.WithError(err).Error("error writing channel log")
+		}
+
+		return nil
+	})
+}
+
 func (s *server) channelReceiveMsgWrapper(handler ChannelHandler, handlerFunc ChannelReceiveMsgFunc) http.HandlerFunc {
 	return s.channelFunctionWrapper(handler, func(channel Channel, w http.ResponseWriter, r *http.Request) error {
 		start := time.Now()
@@ -375,6 +495,14 @@ func (s *server) AddUpdateStatusRoute(handler ChannelHandler, method string, act
 	return s.addRoute(handler, method, action, s.channelUpdateStatusWrapper(handler, handlerFunc))
 }
 
+func (s *server) AddReceiveMsgAndStatusRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelReceiveMsgAndStatusFunc) error {
+	return s.addRoute(handler, method, action, s.channelReceiveMsgAndStatusWrapper(handler, handlerFunc))
+}
+
+func (s *server) AddCallEventRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelCallEventFunc) error {
+	return s.addRoute(handler, method, action, s.channelCallEventWrapper(handler, handlerFunc))
+}
+
 func (s *server) AddChannelRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelActionHandlerFunc) error {
 	return s.addRoute(handler, method, action, s.channelFunctionWrapper(handler, handlerFunc))
 }
@@ -8,6 +8,7 @@ import (
 
 	_ "github.com/lib/pq" // postgres driver
 	"github.com/nyaruka/courier/config"
+	uuid "github.com/satori/go.uuid"
 )
 
 //-----------------------------------------------------------------------------
@@ -23,21 +24,57 @@ type MockBackend struct {
 	mutex        sync.RWMutex
 	outgoingMsgs []Msg
 	msgStatuses  []MsgStatus
+	callEvents   []CallEvent
 
+	contacts           map[URN]*mockContact
 	stoppedMsgContacts []Msg
+	stoppedContacts    []URN
 	sentMsgs           map[MsgID]bool
+	completedMsgs      []Msg
 }
 
 // NewMockBackend returns a new mock backend suitable for testing
 func NewMockBackend() *MockBackend {
 	return &MockBackend{
 		channels: make(map[ChannelUUID]Channel),
+		contacts: make(map[URN]*mockContact),
 		sentMsgs: make(map[MsgID]bool),
 	}
 }
 
+// GetContact returns the contact for the passed in URN, creating it if it doesn't exist
+func (mb *MockBackend) GetContact(channel Channel, urn URN, auth string, name string) (Contact, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	contact, found := mb.contacts[urn]
+	if !found {
+		contact = &mockContact{channel: channel, urn: urn, name: name, uuid: ContactUUID{uuid.NewV4()}}
+		mb.contacts[urn] = contact
+	}
+	if auth != "" {
+		contact.auth = auth
+	}
+	return contact, nil
+}
+
+// GetContactURNAuth returns the auth saved for the passed in URN
+func (mb *MockBackend) GetContactURNAuth(urn URN) string {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	contact, found := mb.contacts[urn]
+	if !found {
+		return ""
+	}
+	return contact.auth
+}
+
 // GetLastQueueMsg returns the last message queued to the server
 func (mb *MockBackend) GetLastQueueMsg() (Msg, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
 	if len(mb.queueMsgs) == 0 {
 		return nil, ErrMsgNotFound
 	}
@@ -54,6 +91,11 @@ func (mb *MockBackend) NewOutgoingMsg(channel Channel, id MsgID, urn URN, text s
 	return &mockMsg{channel: channel, id: id, urn: urn, text: text, priority: priority}
 }
 
+// NewOutgoingReply creates a new outgoing message which is a response to the passed in incoming message
+func (mb *MockBackend) NewOutgoingReply(channel Channel, id MsgID, urn URN, text string, priority MsgPriority, responseToID MsgID, responseToExternalID string) Msg {
+	return &mockMsg{channel: channel, id: id, urn: urn, text: text, priority: priority, responseToID: responseToID, responseToExternalID: responseToExternalID}
+}
+
 // PushOutgoingMsg is a test method to add a message to our queue of messages to send
 func (mb *MockBackend) PushOutgoingMsg(msg Msg) {
 	mb.mutex.Lock()
@@ -97,12 +139,33 @@ func (mb *MockBackend) GetLastStoppedMsgContact() Msg {
 	return nil
 }
 
+// StopContact stops the contact with the passed in URN
+func (mb *MockBackend) StopContact(channel Channel, urn URN) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.stoppedContacts = append(mb.stoppedContacts, urn)
+	return nil
+}
+
+// GetLastStoppedContact returns the URN of the last stopped contact
+func (mb *MockBackend) GetLastStoppedContact() URN {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	if len(mb.stoppedContacts) > 0 {
+		return mb.stoppedContacts[len(mb.stoppedContacts)-1]
+	}
+	return NilURN
+}
+
 // MarkOutgoingMsgComplete marks the passed msg as having been dealt with
 func (mb *MockBackend) MarkOutgoingMsgComplete(msg Msg, s MsgStatus) {
 	mb.mutex.Lock()
 	defer mb.mutex.Unlock()
 
 	mb.sentMsgs[msg.ID()] = true
+	mb.completedMsgs = append(mb.completedMsgs, msg)
 }
 
 // WriteChannelLogs writes the passed in channel logs to the DB
@@ -121,6 +184,9 @@ func (mb *MockBackend) WriteMsg(m Msg) error {
 		return errors.New("unable to queue message")
 	}
 
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
 	mb.queueMsgs = append(mb.queueMsgs, m)
 	return nil
 }
@@ -154,6 +220,68 @@ func (mb *MockBackend) WriteMsgStatus(status MsgStatus) error {
 	return nil
 }
 
+// WriteDeliveredWatermark marks the msgs which were marked complete on the passed in channel to the passed in URN as
+// delivered, our mock msgs don't know when they were sent so all of them are considered before the watermark
+func (mb *MockBackend) WriteDeliveredWatermark(channel Channel, urn URN, watermark time.Time) ([]MsgStatus, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	statuses := make([]MsgStatus, 0, 1)
+	for _, msg := range mb.completedMsgs {
+		if msg.Channel().UUID() == channel.UUID() && msg.URN() == urn {
+			status := &mockMsgStatus{channel: channel, id: msg.ID(), status: MsgDelivered, createdOn: time.Now().In(time.UTC)}
+			mb.msgStatuses = append(mb.msgStatuses, status)
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
+
+// GetLastMsgStatus returns the last status written to the server
+func (mb *MockBackend) GetLastMsgStatus() (MsgStatus, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	if len(mb.msgStatuses) == 0 {
+		return nil, ErrMsgNotFound
+	}
+	return mb.msgStatuses[len(mb.msgStatuses)-1], nil
+}
+
+// NewCallEventForExternalID creates a new call event for the given external id
+func (mb *MockBackend) NewCallEventForExternalID(channel Channel, externalID string, status CallStatusValue) CallEvent {
+	return &mockCallEvent{
+		channel:    channel,
+		externalID: externalID,
+		status:     status,
+		createdOn:  time.Now().In(time.UTC),
+	}
+}
+
+// WriteCallEvent writes the call event to our queue
+func (mb *MockBackend) WriteCallEvent(event CallEvent) error {
+	if mb.errorOnQueue {
+		return errors.New("unable to queue call event")
+	}
+
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.callEvents = append(mb.callEvents, event)
+	return nil
+}
+
+// GetLastCallEvent returns the last call event written to the server
+func (mb *MockBackend) GetLastCallEvent() (CallEvent, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	if len(mb.callEvents) == 0 {
+		return nil, ErrCallNotFound
+	}
+	return mb.callEvents[len(mb.callEvents)-1], nil
+}
+
 // GetChannel returns the channel with the passed in type and channel uuid
 func (mb *MockBackend) GetChannel(cType ChannelType, uuid ChannelUUID) (Channel, error) {
 	channel, found := mb.channels[uuid]
@@ -163,6 +291,17 @@ func (mb *MockBackend) GetChannel(cType ChannelType, uuid ChannelUUID) (Channel,
 	return channel, nil
 }
 
+// GetChannels returns all the test channels with the passed in type
+func (mb *MockBackend) GetChannels(cType ChannelType) ([]Channel, error) {
+	channels := make([]Channel, 0)
+	for _, channel := range mb.channels {
+		if channel.ChannelType() == cType {
+			channels = append(channels, channel)
+		}
+	}
+	return channels, nil
+}
+
 // AddChannel adds a test channel to the test server
 func (mb *MockBackend) AddChannel(channel Channel) {
 	mb.channels[channel.UUID()] = channel
@@ -184,6 +323,9 @@ func (mb *MockBackend) Cleanup() error { return nil }
 
 // ClearQueueMsgs clears our mock msg queue
 func (mb *MockBackend) ClearQueueMsgs() {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
 	mb.queueMsgs = nil
 }
 
@@ -285,9 +427,13 @@ type mockMsg struct {
 	attachments []string
 	externalID  string
 	urn         URN
+	urnAuth     string
 	contactName string
 	priority    MsgPriority
 
+	responseToID         MsgID
+	responseToExternalID string
+
 	receivedOn *time.Time
 	sentOn     *time.Time
 	wiredOn    *time.Time
@@ -300,14 +446,19 @@ func (m *mockMsg) Text() string          { return m.text }
 func (m *mockMsg) Attachments() []string { return m.attachments }
 func (m *mockMsg) ExternalID() string    { return m.externalID }
 func (m *mockMsg) URN() URN              { return m.urn }
+func (m *mockMsg) URNAuth() string       { return m.urnAuth }
 func (m *mockMsg) ContactName() string   { return m.contactName }
 func (m *mockMsg) Priority() MsgPriority { return m.priority }
 
+func (m *mockMsg) ResponseToID() MsgID          { return m.responseToID }
+func (m *mockMsg) ResponseToExternalID() string { return m.responseToExternalID }
+
 func (m *mockMsg) ReceivedOn() *time.Time { return m.receivedOn }
 func (m *mockMsg) SentOn() *time.Time     { return m.sentOn }
 func (m *mockMsg) WiredOn() *time.Time    { return m.wiredOn }
 
 func (m *mockMsg) WithContactName(name string) Msg   { m.contactName = name; return m }
+func (m *mockMsg) WithURNAuth(auth string) Msg       { m.urnAuth = auth; return m }
 func (m *mockMsg) WithReceivedOn(date time.Time) Msg { m.receivedOn = &date; return m }
 func (m *mockMsg) WithExternalID(id string) Msg      { m.externalID = id; return m }
 func (m *mockMsg) WithID(id MsgID) Msg               { m.id = id; return m }
@@ -339,3 +490,41 @@ func (m *mockMsgStatus) SetStatus(status MsgStatusValue) { m.status = status }
 
 func (m *mockMsgStatus) Logs() []*ChannelLog    { return m.logs }
 func (m *mockMsgStatus) AddLog(log *ChannelLog) { m.logs = append(m.logs, log) }
+
+//-----------------------------------------------------------------------------
+// Mock contact implementation
+//-----------------------------------------------------------------------------
+
+type mockContact struct {
+	channel Channel
+	urn     URN
+	auth    string
+	name    string
+	uuid    ContactUUID
+}
+
+func (c *mockContact) UUID() ContactUUID { return c.uuid }
+
+//-----------------------------------------------------------------------------
+// Mock call event implementation
+//-----------------------------------------------------------------------------
+
+type mockCallEvent struct {
+	channel    Channel
+	externalID string
+	status     CallStatusValue
+	createdOn  time.Time
+
+	logs []*ChannelLog
+}
+
+func (m *mockCallEvent) ChannelUUID() ChannelUUID { return m.channel.UUID() }
+
+func (m *mockCallEvent) ExternalID() string      { return m.externalID }
+func (m *mockCallEvent) SetExternalID(id string) { m.externalID = id }
+
+func (m *mockCallEvent) Status() CallStatusValue          { return m.status }
+func (m *mockCallEvent) SetStatus(status CallStatusValue) { m.status = status }
+
+func (m *mockCallEvent) Logs() []*ChannelLog    { return m.logs }
+func (m *mockCallEvent) AddLog(log *ChannelLog) { m.logs = append(m.logs, log) }
@@ -11,9 +11,15 @@ import (
 )
 
 const (
+	// FCMScheme is the scheme used for Firebase Cloud Messaging identifiers
+	FCMScheme string = "fcm"
+
 	// FacebookScheme is the scheme used for Facebook identifiers
 	FacebookScheme string = "facebook"
 
+	// LineScheme is the scheme used for LINE identifiers
+	LineScheme string = "line"
+
 	// TelegramScheme is the scheme used for telegram identifier
 	TelegramScheme string = "telegram"
 
@@ -22,6 +28,12 @@ const (
 
 	// TwitterScheme is the scheme used for Twitter identifiers
 	TwitterScheme string = "twitter"
+
+	// ViberScheme is the scheme used for Viber identifiers
+	ViberScheme string = "viber"
+
+	// WhatsAppScheme is the scheme used for WhatsApp identifiers
+	WhatsAppScheme string = "whatsapp"
 )
 
 // URN represents a Universal Resource Name, we use this for contact identifiers like phone numbers etc..
@@ -135,8 +147,12 @@ func newURN(scheme string, path string, display string) URN {
 var telRegex = regexp.MustCompile(`[^0-9a-z]`)
 
 var validSchemes = map[string]bool{
+	FCMScheme:      true,
 	FacebookScheme: true,
+	LineScheme:     true,
 	TelegramScheme: true,
 	TelScheme:      true,
 	TwitterScheme:  true,
+	ViberScheme:    true,
+	WhatsAppScheme: true,
 }
@@ -64,6 +64,10 @@ func TestFromParts(t *testing.T) {
 		{"twitter", "hello", "", "twitter:hello", "twitter:hello", false},
 		{"facebook", "hello", "", "facebook:hello", "facebook:hello", false},
 		{"telegram", "12345", "Jane", "telegram:12345#jane", "telegram:12345", false},
+		{"viber", "xy5/5y6O81+/kbWHpLhBoA==", "", "viber:xy5/5y6O81+/kbWHpLhBoA==", "viber:xy5/5y6O81+/kbWHpLhBoA==", false},
+		{"fcm", "cTvNVV1RmLc:APA91bHPPxT", "", "fcm:cTvNVV1RmLc:APA91bHPPxT", "fcm:cTvNVV1RmLc:APA91bHPPxT", false},
+		{"line", "Uabcdef0123456789", "", "line:Uabcdef0123456789", "line:Uabcdef0123456789", false},
+		{"whatsapp", "12065551212", "", "whatsapp:12065551212", "whatsapp:12065551212", false},
 	}
 
 	for _, tc := range testCases {
@@ -3,8 +3,10 @@ package utils
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"regexp"
 	"strings"
+	"unicode/utf16"
 	"unicode/utf8"
 )
 
@@ -50,6 +52,40 @@ func DecodeUTF8(bytes []byte) string {
 	return s
 }
 
+// DecodeLatin1 decodes the passed in ISO-8859-1 encoded bytes, each byte maps directly to the unicode code point
+// with the same value
+func DecodeLatin1(bytes []byte) string {
+	runes := make([]rune, len(bytes))
+	for i, b := range bytes {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// DecodeUTF16BE decodes the passed in UTF-16BE encoded bytes, as used by UCS2 encoded SMS
+func DecodeUTF16BE(bytes []byte) (string, error) {
+	if len(bytes)%2 != 0 {
+		return "", fmt.Errorf("UTF-16BE input must have an even number of bytes, got %d", len(bytes))
+	}
+
+	chars := make([]uint16, len(bytes)/2)
+	for i := range chars {
+		chars[i] = uint16(bytes[i*2])<<8 | uint16(bytes[i*2+1])
+	}
+	return string(utf16.Decode(chars)), nil
+}
+
+// EncodeUTF16BE encodes the passed in text as UTF-16BE bytes, as used by UCS2 encoded SMS
+func EncodeUTF16BE(text string) []byte {
+	chars := utf16.Encode([]rune(text))
+	bytes := make([]byte, len(chars)*2)
+	for i, c := range chars {
+		bytes[i*2] = byte(c >> 8)
+		bytes[i*2+1] = byte(c)
+	}
+	return bytes
+}
+
 // StringArrayContains returns whether a given string array contains the given element
 func StringArrayContains(s []string, e string) bool {
 	for _, a := range s {
@@ -17,6 +17,28 @@ func TestJoinNonEmpty(t *testing.T) {
 	assert.Equal(t, "hello world", JoinNonEmpty(" ", "", "hello", "", "world"))
 }
 
+func TestDecodeLatin1(t *testing.T) {
+	assert.Equal(t, "", DecodeLatin1([]byte{}))
+	assert.Equal(t, "hello", DecodeLatin1([]byte("hello")))
+	assert.Equal(t, "jours pas réglé", DecodeLatin1([]byte("jours pas r\xe9gl\xe9")))
+}
+
+func TestUTF16BE(t *testing.T) {
+	assert.Equal(t, []byte{0x00, 0x48, 0x00, 0x69, 0x26, 0x3a}, EncodeUTF16BE("Hi☺"))
+	assert.Equal(t, []byte{0xd8, 0x3d, 0xde, 0x05}, EncodeUTF16BE("😅"))
+
+	decoded, err := DecodeUTF16BE([]byte{0x00, 0x48, 0x00, 0x69, 0x26, 0x3a})
+	assert.NoError(t, err)
+	assert.Equal(t, "Hi☺", decoded)
+
+	decoded, err = DecodeUTF16BE(EncodeUTF16BE("😅 happy!"))
+	assert.NoError(t, err)
+	assert.Equal(t, "😅 happy!", decoded)
+
+	_, err = DecodeUTF16BE([]byte{0x00, 0x48, 0x00})
+	assert.Error(t, err)
+}
+
 func TestStringArrayContains(t *testing.T) {
 	assert.False(t, StringArrayContains([]string{}, "x"))
 	assert.False(t, StringArrayContains([]string{"a", "b"}, "x"))